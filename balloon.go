@@ -0,0 +1,183 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+// ErrNoBalloon is returned by BalloonStats when the VM has no
+// virtio-balloon device.
+var ErrNoBalloon = errors.New("VM has no balloon")
+
+// balloonStatsRetry is how often BalloonStats checks for a new report
+// of the guest.
+const balloonStatsRetry = 100 * time.Millisecond
+
+// SetBalloon asks the guest to shrink or grow its memory to size
+// bytes by inflating or deflating the balloon.  The guest does so
+// asynchronously; Balloon returns how far it got.
+func (v *VM) SetBalloon(ctx context.Context, size uint64) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+	return mon.Execute(ctx, "balloon", map[string]any{"value": size}, nil)
+}
+
+// Balloon returns the memory of the guest in bytes, which is its RAM
+// without the balloon.
+func (v *VM) Balloon(ctx context.Context) (uint64, error) {
+	mon, err := v.qmp()
+	if err != nil {
+		return 0, err
+	}
+	var info struct {
+		Actual uint64 `json:"actual"`
+	}
+	if err := mon.Execute(ctx, "query-balloon", nil, &info); err != nil {
+		return 0, err
+	}
+	return info.Actual, nil
+}
+
+// BalloonStats are the memory statistics the guest reported through
+// the balloon.  Statistics the guest does not report are None.
+type BalloonStats struct {
+	// LastUpdate is when the guest reported.
+	LastUpdate time.Time
+
+	// SwapIn and SwapOut are the amount of memory in bytes swapped
+	// in and out.
+	SwapIn, SwapOut qpoption.Option[uint64]
+
+	// MajorFaults and MinorFaults are the number of page faults
+	// with and without disk I/O.
+	MajorFaults, MinorFaults qpoption.Option[uint64]
+
+	// FreeMemory is the memory in bytes not used at all, while
+	// AvailableMemory also includes memory like caches that can be
+	// reclaimed without swapping.
+	FreeMemory, TotalMemory, AvailableMemory qpoption.Option[uint64]
+
+	// DiskCaches is the memory in bytes caching files.
+	DiskCaches qpoption.Option[uint64]
+
+	// HugeTLBAllocations and HugeTLBFailures are the number of huge
+	// page allocations that succeeded and failed.
+	HugeTLBAllocations, HugeTLBFailures qpoption.Option[uint64]
+}
+
+// balloonPath returns the QOM path of the balloon device.
+func balloonPath(ctx context.Context, mon *qpqmp.Client) (string, error) {
+	for _, dir := range []string{"/machine/peripheral", "/machine/peripheral-anon"} {
+		var children []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		}
+		if err := mon.Execute(ctx, "qom-list", map[string]any{"path": dir}, &children); err != nil {
+			return "", err
+		}
+		for _, child := range children {
+			if child.Type == "child<virtio-balloon-pci>" {
+				return dir + "/" + child.Name, nil
+			}
+		}
+	}
+	return "", ErrNoBalloon
+}
+
+// BalloonStats makes the guest report its memory statistics every
+// interval, rounded up to whole seconds, and returns the first report
+// it sends after that, even if polling was on already.  Polling stays
+// on until BalloonStats is called with an interval of 0, which returns
+// the last report.
+func (v *VM) BalloonStats(ctx context.Context, interval time.Duration) (*BalloonStats, error) {
+	mon, err := v.qmp()
+	if err != nil {
+		return nil, err
+	}
+	path, err := balloonPath(ctx, mon)
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := (interval + time.Second - 1) / time.Second
+	if err := mon.Execute(ctx, "qom-set", map[string]any{
+		"path": path, "property": "guest-stats-polling-interval", "value": int64(seconds),
+	}, nil); err != nil {
+		return nil, fmt.Errorf("balloon stats: %w", err)
+	}
+
+	type guestStats struct {
+		Stats      map[string]uint64 `json:"stats"`
+		LastUpdate int64             `json:"last-update"`
+	}
+	query := func() (*guestStats, error) {
+		var stats guestStats
+		if err := mon.Execute(ctx, "qom-get", map[string]any{
+			"path": path, "property": "guest-stats",
+		}, &stats); err != nil {
+			return nil, fmt.Errorf("balloon stats: %w", err)
+		}
+		return &stats, nil
+	}
+
+	// Polling may have been on already, so wait for a report newer
+	// than the one at hand.  Until the guest reported, the last
+	// update is 0.
+	stats, err := query()
+	if err != nil {
+		return nil, err
+	}
+	for last := stats.LastUpdate; interval != 0 && stats.LastUpdate <= last; {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("balloon stats: %w", ctx.Err())
+		case <-time.After(balloonStatsRetry):
+		}
+		if stats, err = query(); err != nil {
+			return nil, err
+		}
+	}
+
+	stat := func(name string) (o qpoption.Option[uint64]) {
+		// Statistics the guest did not report are UINT64_MAX.
+		if v, ok := stats.Stats[name]; ok && v != math.MaxUint64 {
+			o.Set(v)
+		}
+		return o
+	}
+	return &BalloonStats{
+		LastUpdate:         time.Unix(stats.LastUpdate, 0),
+		SwapIn:             stat("stat-swap-in"),
+		SwapOut:            stat("stat-swap-out"),
+		MajorFaults:        stat("stat-major-faults"),
+		MinorFaults:        stat("stat-minor-faults"),
+		FreeMemory:         stat("stat-free-memory"),
+		TotalMemory:        stat("stat-total-memory"),
+		AvailableMemory:    stat("stat-available-memory"),
+		DiskCaches:         stat("stat-disk-caches"),
+		HugeTLBAllocations: stat("stat-htlb-pgalloc"),
+		HugeTLBFailures:    stat("stat-htlb-pgfail"),
+	}, nil
+}
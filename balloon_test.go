@@ -0,0 +1,115 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+)
+
+// newFakeBalloon returns the handler of fakeServe for the balloon
+// commands, which reports whether it answered cmd.  Once polling is
+// enabled, the fake guest reports statistics on every second query.
+func newFakeBalloon() func(conn net.Conn, cmd string, args map[string]any, id string) bool {
+	// actual is the guest memory, lastUpdate the time of the last
+	// report and polls counts the queries since polling was enabled.
+	var (
+		actual     = 1 << 30
+		lastUpdate int64
+		polling    bool
+		polls      int
+	)
+
+	return func(conn net.Conn, cmd string, args map[string]any, id string) bool {
+		reply := func(ret string) { fmt.Fprintf(conn, `{"return": %s, "id": %q}`+"\n", ret, id) }
+
+		switch {
+		case cmd == "balloon":
+			actual = int(args["value"].(float64))
+			reply(`{}`)
+		case cmd == "query-balloon":
+			reply(fmt.Sprintf(`{"actual": %d}`, actual))
+		case cmd == "qom-list" && args["path"] == "/machine/peripheral":
+			reply(`[{"name": "type", "type": "string"}, {"name": "net0", "type": "child<virtio-net-pci>"}]`)
+		case cmd == "qom-list" && args["path"] == "/machine/peripheral-anon":
+			reply(`[{"name": "device[0]", "type": "child<virtio-balloon-pci>"}]`)
+		case cmd == "qom-set" && args["property"] == "guest-stats-polling-interval":
+			if args["path"] != "/machine/peripheral-anon/device[0]" || (args["value"] != float64(2) && args["value"] != float64(0)) {
+				fmt.Fprintf(conn, `{"error": {"class": "GenericError", "desc": "bad arguments"}, "id": %q}`+"\n", id)
+				return true
+			}
+			polling, polls = args["value"] != float64(0), 0
+			reply(`{}`)
+		case cmd == "qom-get" && args["property"] == "guest-stats":
+			if polls++; polling && polls%2 == 0 {
+				if lastUpdate == 0 {
+					lastUpdate = 1700000000
+				} else {
+					lastUpdate++
+				}
+			}
+			if lastUpdate == 0 {
+				reply(`{"stats": {"stat-swap-in": 18446744073709551615, "stat-free-memory": 18446744073709551615}, "last-update": 0}`)
+				return true
+			}
+			reply(fmt.Sprintf(`{"stats": {"stat-swap-in": 0, "stat-swap-out": 0, "stat-major-faults": 12,
+				"stat-minor-faults": 3456, "stat-free-memory": 536870912, "stat-total-memory": 1073741824,
+				"stat-available-memory": 805306368, "stat-disk-caches": 268435456,
+				"stat-htlb-pgalloc": 18446744073709551615, "stat-htlb-pgfail": 18446744073709551615}, "last-update": %d}`, lastUpdate))
+		default:
+			return false
+		}
+		return true
+	}
+}
+
+func TestVM_Balloon(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	vm := yeetFake(t)
+
+	assert.NoError(vm.SetBalloon(ctx, 512<<20))
+	actual, err := vm.Balloon(ctx)
+	if assert.NoError(err) {
+		assert.Equal(uint64(512<<20), actual)
+	}
+
+	stats, err := vm.BalloonStats(ctx, 1500*time.Millisecond)
+	if assert.NoError(err) {
+		assert.Equal(time.Unix(1700000000, 0), stats.LastUpdate)
+		assert.Equal(uint64(0), stats.SwapIn.Yank())
+		assert.Equal(uint64(12), stats.MajorFaults.Yank())
+		assert.Equal(uint64(512<<20), stats.FreeMemory.Yank())
+		assert.Equal(uint64(768<<20), stats.AvailableMemory.Yank())
+		assert.True(stats.HugeTLBAllocations.IsNone())
+	}
+
+	// Polling is on already, yet BalloonStats waits for a new report.
+	stats, err = vm.BalloonStats(ctx, 1500*time.Millisecond)
+	if assert.NoError(err) {
+		assert.Equal(time.Unix(1700000001, 0), stats.LastUpdate)
+	}
+
+	stats, err = vm.BalloonStats(ctx, 0)
+	if assert.NoError(err) {
+		assert.Equal(time.Unix(1700000001, 0), stats.LastUpdate)
+	}
+}
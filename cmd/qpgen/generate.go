@@ -0,0 +1,482 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// kind describes how the types of an option group are generated.
+type kind struct {
+	// suffix is appended to the Go type names, noun describes the
+	// types in their doc comments.
+	suffix, noun string
+
+	// base is the qpdevices type embedded by the generated types,
+	// field the field of base receiving the type name and recv the
+	// receiver name of the generated methods.
+	base, field, recv string
+
+	// covered lists the properties base already provides.
+	covered map[string]bool
+
+	// renames maps schema member names to the property names of
+	// the command line, where the two differ.
+	renames map[string]string
+
+	// getName tells whether to generate a GetName method.
+	getName bool
+
+	// order sorts the generated types by kind.
+	order int
+}
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+var (
+	deviceKind = &kind{
+		suffix: "Device", noun: "device",
+		base: "BaseDevice", field: "Type", recv: "d",
+		covered: set("id"),
+		getName: true,
+		order:   4,
+	}
+	objectKind = &kind{
+		suffix: "Object", noun: "object",
+		base: "BaseObject", field: "Type", recv: "o",
+		covered: set("qom-type", "id"),
+		order:   0,
+	}
+	chardevKind = &kind{
+		suffix: "CharDevice", noun: "chardev backend",
+		base: "CharDevice", field: "Type", recv: "d",
+		covered: set("id", "logfile", "logappend"),
+
+		// -chardev is parsed by hand rather than from the schema.
+		renames: map[string]string{"out": "path", "device": "path"},
+		order:   1,
+	}
+	blockdevKind = &kind{
+		suffix: "BlockDevice", noun: "block driver",
+		base: "BlockDevice", field: "Driver", recv: "d",
+		covered: set("driver", "node-name", "read-only", "auto-read-only", "force-share",
+			"cache", "discard", "detect-zeroes"),
+		order: 2,
+	}
+	netdevKind = &kind{
+		suffix: "NetworkPeerDevice", noun: "netdev",
+		base: "NetworkPeerDevice", field: "Type", recv: "d",
+		covered: set("id", "type"),
+		order:   3,
+	}
+)
+
+// reserved lists the Go names of the fields and methods of the
+// embedded bases, which generated fields must not shadow.
+var reserved = set("Type", "Name", "Driver", "Mux", "LogFile", "LogAppend",
+	"ReadOnly", "AutoReadOnly", "ForceShare", "CacheDirect", "CacheNoFlush",
+	"Discard", "DetectZeroes", "GetName", "GetCliArgs")
+
+type (
+	goType struct {
+		name, slug string
+		kind       *kind
+		fields     []goField
+	}
+
+	goField struct {
+		name, typ, prop, doc string
+	}
+
+	goEnum struct {
+		name   string
+		values []string
+	}
+)
+
+type generator struct {
+	schema  schema
+	only    map[string]bool
+	skip    map[string]bool
+	types   []*goType
+	enums   map[string]*goEnum
+	imports map[string]bool
+}
+
+func newGenerator(s schema, only, skip []string) *generator {
+	g := &generator{
+		schema:  s,
+		skip:    set(skip...),
+		enums:   map[string]*goEnum{},
+		imports: map[string]bool{},
+	}
+	if len(only) > 0 {
+		g.only = set(only...)
+	}
+	return g
+}
+
+func (g *generator) selected(slug string) bool {
+	return (g.only == nil || g.only[slug]) && !g.skip[slug]
+}
+
+// option returns the Option type of t.
+func (g *generator) option(t string) string {
+	g.imports["qpoption"] = true
+	return "qpoption.Option[" + t + "]"
+}
+
+// enum returns the name of the enum type for a property, reusing
+// an existing type with the same values.  Enums are named after name,
+// prefixed with owner on conflicts.
+func (g *generator) enum(owner, name string, values []string) string {
+	for _, e := range g.enums {
+		if equal(e.values, values) {
+			return e.name
+		}
+	}
+
+	name = goName(name)
+	if _, found := g.enums[name]; found {
+		name = owner + name
+	}
+	if _, found := g.enums[name]; !found {
+		g.enums[name] = &goEnum{name: name, values: values}
+	}
+	return name
+}
+
+// equal reports whether a and b hold the same values in any order.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sorted(a), sorted(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sorted(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
+
+var builtins = map[string]string{
+	"str": "", "bool": "bool", "size": "uint64",
+	"int": "int64", "int8": "int8", "int16": "int16", "int32": "int32", "int64": "int64",
+	"uint8": "uint8", "uint16": "uint16", "uint32": "uint32", "uint64": "uint64",
+}
+
+// builtin maps a builtin schema type to a Go type.
+func (g *generator) builtin(name string) (string, bool) {
+	t, found := builtins[name]
+	switch {
+	case !found:
+		return "", false
+	case t == "":
+		return "string", true
+	default:
+		return g.option(t), true
+	}
+}
+
+func (t *goType) add(f goField) {
+	if reserved[f.name] {
+		f.name += "Property"
+	}
+	t.fields = append(t.fields, f)
+}
+
+// members adds fields for the schema members to t, flattening nested
+// objects into dotted properties.
+func (g *generator) members(t *goType, members []schemaMember, prefix string) error {
+	for _, m := range members {
+		prop := prefix + m.Name
+		if t.kind.covered[prop] || m.unstable() {
+			continue
+		}
+		if r, found := t.kind.renames[prop]; found {
+			prop = r
+		}
+
+		e, err := g.schema.lookup(m.Type)
+		if err != nil {
+			return err
+		}
+
+		var typ string
+		switch e.MetaType {
+		case "builtin":
+			typ, _ = g.builtin(e.Name)
+		case "enum":
+			typ = g.enum(strings.TrimSuffix(t.name, t.kind.suffix), m.Name, e.Values)
+		case "alternate":
+			for _, alt := range e.Members {
+				if typ, _ = g.builtin(alt.Type); typ != "" {
+					break
+				}
+			}
+		case "object":
+			if e.Tag == "" {
+				if err := g.members(t, e.Members, prop+"."); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Lists, unions and builtins without a command line
+		// representation are left out.
+		if typ != "" {
+			t.add(goField{name: goName(prop), typ: typ, prop: prop})
+		}
+	}
+	return nil
+}
+
+// union generates a type for every branch of the flat union the
+// arguments of command are, or the member named member of them.
+func (g *generator) union(k *kind, command, member string) error {
+	u, err := g.schema.commandArgs(command)
+	if err != nil {
+		return err
+	}
+	if member != "" {
+		for _, m := range u.Members {
+			if m.Name == member {
+				if u, err = g.schema.lookup(m.Type); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if u.Tag == "" {
+		return fmt.Errorf("%s: not a union", command)
+	}
+
+	variants := u.Variants
+	sort.Slice(variants, func(i, j int) bool { return variants[i].Case < variants[j].Case })
+
+	for _, v := range variants {
+		if !g.selected(v.Case) {
+			continue
+		}
+
+		e, err := g.schema.lookup(v.Type)
+		if err != nil {
+			return err
+		}
+		if e, err = g.schema.unwrap(e); err != nil {
+			return err
+		}
+
+		t := &goType{name: goName(v.Case) + k.suffix, slug: v.Case, kind: k}
+		if err := g.members(t, e.Members, ""); err != nil {
+			return fmt.Errorf("%s %s: %w", command, v.Case, err)
+		}
+		g.types = append(g.types, t)
+	}
+	return nil
+}
+
+func (g *generator) unions() error {
+	for _, u := range []struct {
+		kind            *kind
+		command, member string
+	}{
+		{objectKind, "object-add", ""},
+		{chardevKind, "chardev-add", "backend"},
+		{blockdevKind, "blockdev-add", ""},
+		{netdevKind, "netdev_add", ""},
+	} {
+		if _, found := g.schema[u.command]; !found {
+			continue
+		}
+		if err := g.union(u.kind, u.command, u.member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// enumDescRe matches the descriptions QEMU generates for enum
+	// properties, e.g. "on/off/auto".
+	enumDescRe = regexp.MustCompile(`^[a-z0-9_-]+(/[a-z0-9_-]+)+$`)
+
+	// qomReferences are property types naming another object.
+	qomReferences = set("drive", "chr", "netdev")
+
+	// qomOverrides forces the Go type of properties whose QOM type
+	// does not match what the command line takes.
+	qomOverrides = map[string]string{
+		// PCI addresses are reported as int32 but given as
+		// "slot.function".
+		"addr": "string",
+	}
+)
+
+// qomField maps a device property to a field.
+func (g *generator) qomField(t *goType, p qomProperty) (goField, bool) {
+	f := goField{name: goName(p.Name), prop: p.Name, doc: strings.Join(strings.Fields(p.Description), " ")}
+
+	switch {
+	case qomOverrides[p.Name] != "":
+		f.typ = qomOverrides[p.Name]
+	case strings.HasPrefix(p.Type, "child<"):
+		return f, false
+	case strings.HasPrefix(p.Type, "link<"), qomReferences[p.Type]:
+		f.typ = "qpdevices.Reference"
+	case enumDescRe.MatchString(p.Description):
+		f.typ, f.doc = g.enum(strings.TrimSuffix(t.name, t.kind.suffix), p.Type, strings.Split(p.Description, "/")), ""
+	default:
+		var found bool
+		if f.typ, found = g.builtin(p.Type); !found {
+			f.typ = "string"
+		}
+	}
+	return f, true
+}
+
+func (g *generator) devices(devices map[string][]qomProperty) {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !g.selected(name) {
+			continue
+		}
+
+		props := devices[name]
+		sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+
+		t := &goType{name: goName(name) + deviceKind.suffix, slug: name, kind: deviceKind}
+		for _, p := range props {
+			// Experimental properties are subject to change.
+			if strings.HasPrefix(p.Name, "x-") {
+				continue
+			}
+			if f, ok := g.qomField(t, p); ok {
+				t.add(f)
+			}
+		}
+		g.types = append(g.types, t)
+	}
+}
+
+const header = `// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+`
+
+// render writes the Go source of all generated types.
+func (g *generator) render(pkg, version string) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString(header)
+	fmt.Fprintf(&b, "\n// Code generated by qpgen from QEMU %s; DO NOT EDIT.\n\n", version)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+
+	b.WriteString("import (\n")
+	if len(g.types) > 0 {
+		b.WriteString("\t\"github.com/qatapult/libqatapult/internal/serializer\"\n")
+		b.WriteString("\t\"github.com/qatapult/libqatapult/qpdevices\"\n")
+	}
+	if g.imports["qpoption"] {
+		b.WriteString("\t\"github.com/qatapult/libqatapult/qpoption\"\n")
+	}
+	b.WriteString(")\n")
+
+	enums := make([]string, 0, len(g.enums))
+	for name := range g.enums {
+		enums = append(enums, name)
+	}
+	sort.Strings(enums)
+
+	for _, name := range enums {
+		e := g.enums[name]
+		fmt.Fprintf(&b, "\ntype %s struct{ slug string }\n\n", e.name)
+		fmt.Fprintf(&b, "func (e %s) String() string { return e.slug }\n\n", e.name)
+		b.WriteString("var (\n")
+		for _, v := range e.values {
+			fmt.Fprintf(&b, "\t%s%s = %s{%q}\n", e.name, goName(v), e.name, v)
+		}
+		b.WriteString(")\n")
+	}
+
+	sort.SliceStable(g.types, func(i, j int) bool { return g.types[i].kind.order < g.types[j].kind.order })
+	for _, t := range g.types {
+		k := t.kind
+		fmt.Fprintf(&b, "\n// %s is the %s %s.\n", t.name, t.slug, k.noun)
+		fmt.Fprintf(&b, "type %s struct {\n\tqpdevices.%s\n", t.name, k.base)
+		for i, f := range t.fields {
+			if i == 0 || f.doc != "" || t.fields[i-1].doc != "" {
+				b.WriteString("\n")
+			}
+			if f.doc != "" {
+				fmt.Fprintf(&b, "\t// %s\n", f.doc)
+			}
+			fmt.Fprintf(&b, "\t%s %s `qp:\"name=%s\"`\n", f.name, f.typ, tagName(f.prop))
+		}
+		b.WriteString("}\n\n")
+
+		if k.getName {
+			fmt.Fprintf(&b, "func (%s %s) GetName() string { return %s.Name }\n\n", k.recv, t.name, k.recv)
+		}
+
+		fmt.Fprintf(&b, "func (%s %s) GetCliArgs() ([]string, error) {\n", k.recv, t.name)
+		if k == deviceKind {
+			fmt.Fprintf(&b, "\t%s.%s = qpdevices.NewDeviceType(%q)\n", k.recv, k.field, t.slug)
+		} else {
+			fmt.Fprintf(&b, "\t%s.%s = %q\n", k.recv, k.field, t.slug)
+		}
+		fmt.Fprintf(&b, "\treturn serializer.GetCliArgs(%s)\n}\n", k.recv)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format: %w\n%s", err, b.Bytes())
+	}
+	return src, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Command qpgen generates qpdevices types from the introspection
+// data of a QEMU binary.
+//
+// It reads the output of the query-qmp-schema QMP command and a JSON
+// object mapping device drivers to the output of device-list-properties
+// for them, and writes a Go file with one type per device, object,
+// chardev backend, block driver and netdev type, along with the enum
+// types of their properties:
+//
+//	qpgen -schema qmp-schema.json -devices devices.json -qemu 8.2.0 -o types_gen.go
+//
+// The generated types embed the matching qpdevices base type.
+// Properties without a command line representation, such as lists and
+// nested unions, as well as deprecated and experimental ones, are left
+// out.  The -only flag restricts the output to the given types, the
+// -skip flag leaves the given types out, such as those qpdevices
+// already provides.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type options struct {
+	schema, devices string
+	only, skip      []string
+	pkg, version    string
+	out             string
+}
+
+func generate(o *options) ([]byte, error) {
+	s := schema{}
+	if o.schema != "" {
+		var err error
+		if s, err = loadSchema(o.schema); err != nil {
+			return nil, err
+		}
+	}
+
+	// Devices go first, as their enums carry proper names while
+	// the schema masks them.
+	g := newGenerator(s, o.only, o.skip)
+	if o.devices != "" {
+		devices, err := loadDevices(o.devices)
+		if err != nil {
+			return nil, err
+		}
+		g.devices(devices)
+	}
+
+	if err := g.unions(); err != nil {
+		return nil, err
+	}
+
+	return g.render(o.pkg, o.version)
+}
+
+func run(args []string) error {
+	var (
+		o          options
+		only, skip string
+	)
+
+	fs := flag.NewFlagSet("qpgen", flag.ContinueOnError)
+	fs.StringVar(&o.schema, "schema", "", "`file` holding the query-qmp-schema output")
+	fs.StringVar(&o.devices, "devices", "", "`file` holding the device-list-properties output per driver")
+	fs.StringVar(&only, "only", "", "comma separated `list` of types to generate")
+	fs.StringVar(&skip, "skip", "", "comma separated `list` of types to leave out")
+	fs.StringVar(&o.pkg, "package", "qpqemu", "package `name` of the generated file")
+	fs.StringVar(&o.version, "qemu", "", "QEMU `version` the data was taken from")
+	fs.StringVar(&o.out, "o", "", "output `file`, standard output if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if o.schema == "" && o.devices == "" {
+		return fmt.Errorf("one of -schema and -devices is required")
+	}
+	if o.version == "" {
+		return fmt.Errorf("-qemu is required")
+	}
+	if only != "" {
+		o.only = strings.Split(only, ",")
+	}
+	if skip != "" {
+		o.skip = strings.Split(skip, ",")
+	}
+
+	src, err := generate(&o)
+	if err != nil {
+		return err
+	}
+
+	if o.out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(o.out, src, 0o644)
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "qpgen:", err)
+		os.Exit(1)
+	}
+}
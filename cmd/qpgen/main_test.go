@@ -0,0 +1,137 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden file")
+
+func TestGenerate_Golden(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	got, err := generate(&options{
+		schema:  "testdata/qmp-schema.json",
+		devices: "testdata/devices.json",
+		pkg:     "golden",
+		version: "8.2.0",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	golden := filepath.Join("testdata", "golden.go.txt")
+	if *update {
+		assert.NoError(os.WriteFile(golden, got, 0o644))
+	}
+
+	want, err := os.ReadFile(golden)
+	if assert.NoError(err) {
+		assert.Equal(string(want), string(got))
+	}
+}
+
+func TestGenerate_Only(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	got, err := generate(&options{
+		schema:  "testdata/qmp-schema.json",
+		devices: "testdata/devices.json",
+		only:    []string{"pvpanic", "rng-random"},
+		pkg:     "golden",
+		version: "8.2.0",
+	})
+	if assert.NoError(err) {
+		assert.Contains(string(got), "type PvpanicDevice struct")
+		assert.Contains(string(got), "type RNGRandomObject struct")
+		assert.NotContains(string(got), "type OnOffAuto struct")
+		assert.NotContains(string(got), "type FileCharDevice struct")
+	}
+}
+
+func TestGenerate_Skip(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	got, err := generate(&options{
+		schema:  "testdata/qmp-schema.json",
+		devices: "testdata/devices.json",
+		skip:    []string{"rng-random", "file"},
+		pkg:     "golden",
+		version: "8.2.0",
+	})
+	if assert.NoError(err) {
+		assert.Contains(string(got), "type RNGBuiltinObject struct")
+		assert.NotContains(string(got), "type RNGRandomObject struct")
+		assert.NotContains(string(got), "type FileCharDevice struct")
+	}
+}
+
+// TestGenerate_UpToDate makes sure the generated qpqemu package
+// matches its snapshots.
+func TestGenerate_UpToDate(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	got, err := generate(&options{
+		schema:  "../../qpqemu/testdata/qmp-schema.json",
+		devices: "../../qpqemu/testdata/devices.json",
+		skip:    []string{"iothread", "rng-builtin", "rng-random", "file"},
+		pkg:     "qpqemu",
+		version: "8.2.0",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	want, err := os.ReadFile("../../qpqemu/types_gen.go")
+	if assert.NoError(err) {
+		assert.Equal(string(want), string(got), "run go generate in qpqemu")
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no input", []string{"-qemu", "8.2.0"}},
+		{"no version", []string{"-devices", "testdata/devices.json"}},
+		{"missing file", []string{"-schema", "testdata/missing.json", "-qemu", "8.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertpkg.Error(t, run(tt.args))
+		})
+	}
+}
+
+func Test_goName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"max-bytes", "MaxBytes"},
+		{"virtio-rng-pci", "VirtIORNGPCI"},
+		{"usb_version", "USBVersion"},
+		{"cache.no-flush", "CacheNoFlush"},
+		{"poll-max-ns", "PollMaxNS"},
+		{"9p", "X9p"},
+	}
+	for _, tt := range tests {
+		assertpkg.Equal(t, tt.want, goName(tt.in), tt.in)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// initialisms are spelled in upper case as a whole, following the Go
+// naming conventions.
+var initialisms = map[string]string{
+	"aio":    "AIO",
+	"cpu":    "CPU",
+	"id":     "ID",
+	"io":     "IO",
+	"ip":     "IP",
+	"isa":    "ISA",
+	"mac":    "MAC",
+	"msi":    "MSI",
+	"msix":   "MSIX",
+	"nbd":    "NBD",
+	"ns":     "NS",
+	"nvme":   "NVMe",
+	"pci":    "PCI",
+	"rng":    "RNG",
+	"scsi":   "SCSI",
+	"tls":    "TLS",
+	"uri":    "URI",
+	"url":    "URL",
+	"usb":    "USB",
+	"uuid":   "UUID",
+	"virtio": "VirtIO",
+	"xhci":   "XHCI",
+}
+
+// goName converts a QEMU name such as "max-bytes" or "poll_grow" to
+// an exported Go identifier.
+func goName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if i, found := initialisms[strings.ToLower(word)]; found {
+			b.WriteString(i)
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+
+	s := b.String()
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+// tagName quotes property names the tag parser would split.
+func tagName(name string) string {
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "'" + name + "'"
+		}
+	}
+	return name
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// schemaEntry is an entry of the query-qmp-schema output.  QEMU masks
+// the names of all types but builtins, which is why types are only
+// ever reached through commands.
+type schemaEntry struct {
+	Name     string         `json:"name"`
+	MetaType string         `json:"meta-type"`
+	ArgType  string         `json:"arg-type"`
+	Members  []schemaMember `json:"members"`
+	Tag      string         `json:"tag"`
+	Variants []struct {
+		Case string `json:"case"`
+		Type string `json:"type"`
+	} `json:"variants"`
+	Values      []string `json:"values"`
+	ElementType string   `json:"element-type"`
+	JSONType    string   `json:"json-type"`
+}
+
+type schemaMember struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Features []string `json:"features"`
+
+	// Optional is set for members carrying a default, which QEMU
+	// always reports as null.
+	Optional bool `json:"-"`
+}
+
+func (m *schemaMember) UnmarshalJSON(b []byte) error {
+	type plain schemaMember
+	if err := json.Unmarshal(b, (*plain)(m)); err != nil {
+		return err
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return err
+	}
+	_, m.Optional = keys["default"]
+	return nil
+}
+
+// unstable reports whether the member is deprecated or unstable.
+func (m *schemaMember) unstable() bool {
+	for _, f := range m.Features {
+		if f == "deprecated" || f == "unstable" {
+			return true
+		}
+	}
+	return false
+}
+
+type schema map[string]*schemaEntry
+
+func loadSchema(path string) (schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*schemaEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s := schema{}
+	for _, e := range entries {
+		s[e.Name] = e
+	}
+	return s, nil
+}
+
+func (s schema) lookup(name string) (*schemaEntry, error) {
+	e, found := s[name]
+	if !found {
+		return nil, fmt.Errorf("schema: unknown type %q", name)
+	}
+	return e, nil
+}
+
+// commandArgs returns the argument type of command.
+func (s schema) commandArgs(command string) (*schemaEntry, error) {
+	cmd, err := s.lookup(command)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.MetaType != "command" {
+		return nil, fmt.Errorf("schema: %s is not a command", command)
+	}
+	return s.lookup(cmd.ArgType)
+}
+
+// unwrap returns the type wrapped by the single data member of the
+// wrapper objects QEMU generates for simple union branches.
+func (s schema) unwrap(e *schemaEntry) (*schemaEntry, error) {
+	if len(e.Members) == 1 && e.Members[0].Name == "data" && e.Tag == "" {
+		return s.lookup(e.Members[0].Type)
+	}
+	return e, nil
+}
+
+// qomProperty is an entry of the device-list-properties output.
+type qomProperty struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// loadDevices reads a file mapping device drivers to the result of
+// device-list-properties for the driver.
+func loadDevices(path string) (map[string][]qomProperty, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices map[string][]qomProperty
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return devices, nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/ring"
+)
+
+var (
+	ErrDetached    = errors.New("detached from VM")
+	ErrNotRunning  = errors.New("VM not running")
+	ErrUnknownExit = errors.New("qemu exited without shutting down")
+)
+
+// YeetWithDetach launches QEMU with -daemonize, so it keeps running
+// when the host process exits.  It implies YeetWithMonitor, with the
+// QMP monitor listening on a socket in the runtime directory, and the
+// VM can be attached to again with Attach.
+//
+// Yeet returns once QEMU finished initializing and daemonized.  The
+// context passed to Yeet only bounds that phase.
+//
+// What QEMU writes to stderr up to then shows up in Logs, the rest
+// goes to a file in the runtime directory, as does the output of
+// YeetWithDebugLog.  A stderr given with YeetWithStdPipes has to be
+// an *os.File, since the daemon may keep it open.
+func YeetWithDetach() YeetOption {
+	return func(o *yeetOptions) { o.detach = true }
+}
+
+func openPidfd(pid int) (*os.File, error) {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("pidfd_open", err)
+	}
+	return os.NewFile(uintptr(fd), "pidfd"), nil
+}
+
+func readPidFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return pid, nil
+}
+
+// adoptDaemon waits for the foreground QEMU process to daemonize and
+// takes over the daemon it left behind.
+func (v *VM) adoptDaemon(monitorPath string) (err error) {
+	cmd := v.cmd
+	v.cmd = nil
+
+	waitErr := cmd.Wait()
+	if v.daemonStderr != nil {
+		_, _ = v.daemonStderr.Seek(0, io.SeekStart)
+		_, _ = io.Copy(v.stderrTail, v.daemonStderr)
+		_ = v.daemonStderr.Close()
+		v.daemonStderr = nil
+	}
+	v.stderrTail.Flush()
+	if waitErr != nil {
+		// QEMU only daemonizes once it initialized.
+		status := v.exitStatus(waitErr, cmd.ProcessState)
+		status.startKnown = true
+		return status.Err()
+	}
+
+	if v.pid, err = readPidFile(v.runtimeDir.PidFile()); err != nil {
+		return err
+	}
+	if v.pidfd, err = openPidfd(v.pid); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, v.Kill())
+		}
+	}()
+
+	return v.watch(monitorPath)
+}
+
+// watch prepares watching a QEMU process that is not a child of the
+// host process.
+func (v *VM) watch(monitorPath string) (err error) {
+	if v.detachR, v.detachW, err = os.Pipe(); err != nil {
+		return err
+	}
+	if monitorPath == "" {
+		return nil
+	}
+
+	if v.monitor, err = dialMonitor(monitorPath); err != nil {
+		return err
+	}
+	v.monitor.Listen(v.recordShutdown)
+	return nil
+}
+
+// Detach stops watching a VM that was launched with YeetWithDetach
+// or attached to, leaving QEMU running.  Done is closed and Error
+// returns ErrDetached afterwards.
+func (v *VM) Detach() error {
+	if v.detachW == nil {
+		return errors.New("detach: QEMU is a child process")
+	}
+
+	select {
+	case <-v.doneCh:
+		return os.ErrProcessDone
+	default:
+	}
+
+	if _, err := v.detachW.Write([]byte{0}); err != nil {
+		return err
+	}
+	<-v.doneCh
+	return nil
+}
+
+// Attach rebuilds a VM handle for the running VM with the given
+// name, e.g. one launched with YeetWithDetach by an earlier run of
+// the host process.
+//
+// QEMU only serves a single QMP client, so attaching to a VM that
+// is still watched somewhere else leaves the new handle without a
+// working monitor.
+func Attach(name string, opts ...RuntimeDirOpt) (_ *VM, err error) {
+	o := (&runtimeDirOpts{}).apply(opts)
+	if err := checkRuntimeDirName(name); err != nil {
+		return nil, err
+	}
+
+	dir := &RuntimeDir{name: name, path: filepath.Join(o.root, name)}
+	m, err := dir.ReadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("attach %s: %w", name, err)
+	}
+
+	vm := &VM{
+		pid:        m.Pid,
+		doneCh:     make(chan struct{}),
+		name:       name,
+		logs:       ring.New[LogRecord](defaultLogTail),
+		runtimeDir: dir,
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(fmt.Errorf("attach %s: %w", name, err), vm.release(false))
+		}
+	}()
+
+	if vm.pidfd, err = openPidfd(m.Pid); err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			err = ErrNotRunning
+		}
+		return nil, err
+	}
+	// The pidfd pins the process, so once it is known to be the
+	// one the metadata describes, it stays that way.
+	if !m.Running() {
+		return nil, ErrNotRunning
+	}
+
+	if err = vm.watch(m.Monitor); err != nil {
+		return nil, err
+	}
+
+	go vm.wait()
+
+	return vm, nil
+}
+
+// ListVMs returns the metadata of all VMs running on the host with a
+// runtime directory below the runtime root.  Directories without
+// readable metadata, e.g. of VMs still launching, are skipped.
+func ListVMs(opts ...RuntimeDirOpt) ([]RuntimeMetadata, error) {
+	o := (&runtimeDirOpts{}).apply(opts)
+
+	entries, err := os.ReadDir(o.root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var vms []RuntimeMetadata
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := readMetadata(filepath.Join(o.root, e.Name()))
+		if err != nil || !m.Running() {
+			continue
+		}
+		vms = append(vms, *m)
+	}
+	return vms, nil
+}
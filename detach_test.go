@@ -0,0 +1,260 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+)
+
+const fakeQEMUEnv = "QATAPULT_FAKE_QEMU"
+
+// daemonConfig returns a Config that runs the test binary as a QEMU
+// understanding just enough of -daemonize and QMP.
+func daemonConfig(args ...string) *libqatapult.Config {
+	c := shellConfig("")
+	c.Emulator = append([]string{os.Args[0], "-test.run=^TestHelperQEMU$", "--"}, args...)
+	c.Environment = append(os.Environ(), fakeQEMUEnv+"=foreground")
+	return c
+}
+
+func TestHelperQEMU(t *testing.T) {
+	switch os.Getenv(fakeQEMUEnv) {
+	case "foreground":
+		fakeForeground()
+	case "daemon":
+		fakeDaemon()
+	default:
+		return
+	}
+	os.Exit(0)
+}
+
+func fakeArg(name string) string {
+	for i, arg := range os.Args {
+		if arg == name && i+1 < len(os.Args) {
+			return os.Args[i+1]
+		}
+	}
+	return ""
+}
+
+func fakeFail(msg string) {
+	fmt.Fprintln(os.Stderr, "qemu-system-x86_64: "+msg)
+	os.Exit(1)
+}
+
+func fakeForeground() {
+	if fakeArg("-fail") != "" {
+		fakeFail(fakeArg("-fail"))
+	}
+	if fakeArg("-daemonize") == "" && os.Args[len(os.Args)-1] != "-daemonize" {
+		fakeFail("-daemonize missing")
+	}
+
+	_, path, _ := strings.Cut(fakeArg("-chardev"), "path=")
+	path, _, _ = strings.Cut(path, ",")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		fakeFail(err.Error())
+	}
+	f, err := l.(*net.UnixListener).File()
+	if err != nil {
+		fakeFail(err.Error())
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fakeQEMUEnv+"=daemon")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if fakeArg("-D") != "" {
+		// Like QEMU, keep stderr open when logging to a file.
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		fakeFail(err.Error())
+	}
+	if err := os.WriteFile(fakeArg("-pidfile"), []byte(strconv.Itoa(cmd.Process.Pid)), 0o600); err != nil {
+		fakeFail(err.Error())
+	}
+}
+
+func fakeDaemon() {
+	// Never outlive a broken test for long.
+	time.AfterFunc(30*time.Second, func() { os.Exit(2) })
+
+	if path := fakeArg("-D"); path != "" {
+		_ = os.WriteFile(path, []byte("guest_errors: Invalid write\n"), 0o600)
+	}
+
+	l, err := net.FileListener(os.NewFile(3, "listener"))
+	if err != nil {
+		os.Exit(1)
+	}
+	balloon := newFakeBalloon()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		fakeServe(conn, balloon)
+	}
+}
+
+func fakeServe(conn net.Conn, balloon func(conn net.Conn, cmd string, args map[string]any, id string) bool) {
+	defer conn.Close()
+
+	fmt.Fprintln(conn, `{"QMP": {"version": {"qemu": {"micro": 0, "minor": 2, "major": 8}, "package": ""}, "capabilities": []}}`)
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		var req struct {
+			Execute   string         `json:"execute"`
+			Arguments map[string]any `json:"arguments"`
+			ID        string         `json:"id"`
+		}
+		if json.Unmarshal(s.Bytes(), &req) != nil {
+			continue
+		}
+		if fakeCPU(conn, req.Execute, req.Arguments, req.ID) || balloon(conn, req.Execute, req.Arguments, req.ID) ||
+			fakeHotplug(conn, req.Execute, req.Arguments, req.ID) {
+			continue
+		}
+		fmt.Fprintf(conn, `{"return": {}, "id": %q}`+"\n", req.ID)
+		if req.Execute == "quit" {
+			fmt.Fprintln(conn, `{"event": "SHUTDOWN", "data": {"guest": false, "reason": "host-qmp-quit"}, "timestamp": {"seconds": 1, "microseconds": 0}}`)
+			os.Exit(0)
+		}
+	}
+}
+
+func TestYeet_Detach(t *testing.T) {
+	assert := assertpkg.New(t)
+	root := t.TempDir()
+	rootOpt := libqatapult.WithRuntimeRoot(root)
+
+	// Detaching brings its own monitor.
+	vm, err := libqatapult.Yeet(context.Background(), daemonConfig(),
+		libqatapult.YeetWithDetach(),
+		libqatapult.YeetWithName("vm"),
+		libqatapult.YeetWithRuntimeRoot(root))
+	if !assert.NoError(err) {
+		return
+	}
+	pid := vm.Pid()
+	defer func() { _ = syscall.Kill(pid, syscall.SIGKILL) }()
+
+	_, err = vm.Monitor().Greeting(context.Background())
+	assert.NoError(err)
+
+	vms, err := libqatapult.ListVMs(rootOpt)
+	if assert.NoError(err) && assert.Len(vms, 1) {
+		assert.Equal("vm", vms[0].Name)
+		assert.Equal(pid, vms[0].Pid)
+		assert.True(vms[0].Detached)
+	}
+
+	assert.NoError(vm.Detach())
+	assert.ErrorIs(vm.Error(), libqatapult.ErrDetached)
+	assert.Nil(vm.ExitStatus())
+
+	vm, err = libqatapult.Attach("vm", rootOpt)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(pid, vm.Pid())
+
+	assert.NoError(vm.Monitor().Execute(context.Background(), "quit", nil, nil))
+	if !waitDone(t, vm) {
+		return
+	}
+
+	s := vm.ExitStatus()
+	if assert.NotNil(s) {
+		assert.True(s.Detached)
+		assert.True(s.Success())
+		assert.True(s.IsHostShutdown())
+	}
+	assert.NoDirExists(vm.RuntimeDir().Path())
+
+	vms, err = libqatapult.ListVMs(rootOpt)
+	assert.NoError(err)
+	assert.Empty(vms)
+
+	_, err = libqatapult.Attach("vm", rootOpt)
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestYeet_DetachConfigError(t *testing.T) {
+	assert := assertpkg.New(t)
+	root := t.TempDir()
+
+	_, err := libqatapult.Yeet(context.Background(), daemonConfig("-fail", "-device foo: 'foo' is not a valid device model name"),
+		libqatapult.YeetWithDetach(),
+		libqatapult.YeetWithMonitor(),
+		libqatapult.YeetWithRuntimeRoot(root))
+
+	var exitErr *libqatapult.ExitError
+	if assert.ErrorAs(err, &exitErr) {
+		assert.True(exitErr.IsConfigError())
+		assert.Equal([]string{"qemu-system-x86_64: -device foo: 'foo' is not a valid device model name"}, exitErr.Stderr)
+	}
+
+	entries, err := os.ReadDir(root)
+	assert.NoError(err)
+	assert.Empty(entries)
+}
+
+func TestYeet_DetachDebugLog(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	vm, err := libqatapult.Yeet(ctx, daemonConfig(),
+		libqatapult.YeetWithDetach(),
+		libqatapult.YeetWithMonitor(),
+		libqatapult.YeetWithDebugLog("guest_errors"),
+		libqatapult.YeetWithRuntimeRoot(t.TempDir()))
+	if !assert.NoError(err) {
+		return
+	}
+	defer func() { _ = syscall.Kill(vm.Pid(), syscall.SIGKILL); waitDone(t, vm) }()
+
+	assert.Eventually(func() bool {
+		b, _ := os.ReadFile(vm.RuntimeDir().Join("debug.log"))
+		return string(b) == "guest_errors: Invalid write\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestYeet_DetachPipedStderr(t *testing.T) {
+	_, err := libqatapult.Yeet(context.Background(), daemonConfig(),
+		libqatapult.YeetWithDetach(),
+		libqatapult.YeetWithStdPipes(nil, nil, &strings.Builder{}),
+		libqatapult.YeetWithRuntimeRoot(t.TempDir()))
+	assertpkg.ErrorContains(t, err, "stderr has to be an *os.File")
+}
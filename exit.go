@@ -0,0 +1,219 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ShutdownReason is the cause of a shutdown as reported by QEMU.
+type ShutdownReason string
+
+const (
+	ShutdownNone           ShutdownReason = "none"
+	ShutdownHostError      ShutdownReason = "host-error"
+	ShutdownHostQMPQuit    ShutdownReason = "host-qmp-quit"
+	ShutdownHostQMPReset   ShutdownReason = "host-qmp-system-reset"
+	ShutdownHostSignal     ShutdownReason = "host-signal"
+	ShutdownHostUI         ShutdownReason = "host-ui"
+	ShutdownGuestShutdown  ShutdownReason = "guest-shutdown"
+	ShutdownGuestReset     ShutdownReason = "guest-reset"
+	ShutdownGuestPanic     ShutdownReason = "guest-panic"
+	ShutdownSubsystemReset ShutdownReason = "subsystem-reset"
+	ShutdownSnapshotLoad   ShutdownReason = "snapshot-load"
+)
+
+// ShutdownEvent is the data of the last SHUTDOWN event QEMU emitted
+// before exiting.
+type ShutdownEvent struct {
+	Guest     bool           `json:"guest"`
+	Reason    ShutdownReason `json:"reason"`
+	Timestamp time.Time      `json:"-"`
+}
+
+// crashSignals are signals QEMU only dies from when something went
+// terribly wrong inside of it.
+var crashSignals = map[syscall.Signal]bool{
+	syscall.SIGSEGV: true,
+	syscall.SIGABRT: true,
+	syscall.SIGBUS:  true,
+	syscall.SIGILL:  true,
+	syscall.SIGFPE:  true,
+	syscall.SIGSYS:  true,
+	syscall.SIGTRAP: true,
+}
+
+// ExitStatus describes how a QEMU process terminated.
+type ExitStatus struct {
+	// Code is the exit code of QEMU or -1 if it was terminated
+	// by a signal.
+	Code int
+
+	// Signal is the signal that terminated QEMU, if any.
+	Signal syscall.Signal
+
+	// CoreDumped reports whether QEMU dumped core.
+	CoreDumped bool
+
+	// Rusage holds the resource usage of QEMU, if available.
+	Rusage *syscall.Rusage
+
+	// Shutdown is the last SHUTDOWN event QEMU emitted, if any.
+	Shutdown *ShutdownEvent
+
+	// Stderr holds the last lines QEMU wrote to stderr.
+	Stderr []string
+
+	// Log holds the last records QEMU logged.
+	Log []LogRecord
+
+	// startKnown tells whether Started is known, either from the
+	// qatapult monitor or because QEMU failed before daemonizing.
+	startKnown bool
+
+	// Detached reports that QEMU was not a child of the process
+	// watching it, so Code, Signal and Rusage are unknown.  Such an
+	// exit is only successful if QEMU shut down properly.
+	Detached bool
+
+	// Started reports whether QEMU got as far as greeting the
+	// monitor, i.e. finished parsing its command line.
+	Started bool
+
+	err error
+}
+
+func (s *ExitStatus) reason() ShutdownReason {
+	if s.Shutdown == nil {
+		return ""
+	}
+	return s.Shutdown.Reason
+}
+
+// Success reports whether QEMU exited with exit code zero.
+func (s *ExitStatus) Success() bool { return s.err == nil && s.Code == 0 }
+
+// IsGuestShutdown reports whether the guest powered itself off.
+func (s *ExitStatus) IsGuestShutdown() bool { return s.reason() == ShutdownGuestShutdown }
+
+// IsGuestReset reports whether QEMU exited because the guest
+// rebooted while running with -no-reboot.
+func (s *ExitStatus) IsGuestReset() bool { return s.reason() == ShutdownGuestReset }
+
+// IsGuestPanic reports whether QEMU exited because of a guest panic.
+func (s *ExitStatus) IsGuestPanic() bool { return s.reason() == ShutdownGuestPanic }
+
+// IsHostShutdown reports whether QEMU was asked to shut down by the
+// host, e.g. through the monitor, a signal or its user interface.
+func (s *ExitStatus) IsHostShutdown() bool {
+	switch s.reason() {
+	case ShutdownHostQMPQuit, ShutdownHostQMPReset, ShutdownHostSignal, ShutdownHostUI:
+		return true
+	}
+	return false
+}
+
+// IsKilled reports whether QEMU was terminated by SIGKILL.
+func (s *ExitStatus) IsKilled() bool { return s.Signal == syscall.SIGKILL }
+
+// IsConfigError reports whether QEMU refused to start, usually
+// because of an invalid command line.  Telling that apart from QEMU
+// failing later takes the monitor, so without YeetWithMonitor it is
+// always false.
+func (s *ExitStatus) IsConfigError() bool {
+	return s.startKnown && s.Code == 1 && !s.Started
+}
+
+// IsCrash reports whether QEMU terminated abnormally on its own.
+// Without YeetWithMonitor, only crash signals and core dumps count, as
+// an exit code alone does not tell whether QEMU started.
+func (s *ExitStatus) IsCrash() bool {
+	if crashSignals[s.Signal] || s.CoreDumped {
+		return true
+	}
+	return s.Started && s.Code > 0 && s.Shutdown == nil
+}
+
+func (s *ExitStatus) String() string {
+	var b strings.Builder
+
+	switch {
+	case s.err != nil && s.Code == 0 && s.Signal == 0:
+		b.WriteString(s.err.Error())
+	case s.Signal != 0:
+		fmt.Fprintf(&b, "signal: %s", s.Signal)
+		if s.CoreDumped {
+			b.WriteString(" (core dumped)")
+		}
+	default:
+		fmt.Fprintf(&b, "exit status %d", s.Code)
+	}
+
+	if s.Shutdown != nil {
+		fmt.Fprintf(&b, " after shutdown (%s)", s.Shutdown.Reason)
+	}
+	return b.String()
+}
+
+// Err returns an *ExitError if QEMU did not exit successfully.
+func (s *ExitStatus) Err() error {
+	if s.Success() {
+		return nil
+	}
+	return &ExitError{ExitStatus: s}
+}
+
+func newExitStatus(waitErr error, state *os.ProcessState) *ExitStatus {
+	s := &ExitStatus{Code: -1, err: waitErr}
+	if state == nil {
+		return s
+	}
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok {
+		s.Code = ws.ExitStatus()
+		if ws.Signaled() {
+			s.Signal, s.CoreDumped = ws.Signal(), ws.CoreDump()
+		}
+	}
+	s.Rusage, _ = state.SysUsage().(*syscall.Rusage)
+	return s
+}
+
+// stderrTailLines is the number of stderr lines an ExitError reports.
+const stderrTailLines = 5
+
+// ExitError is returned by VM.Error if QEMU exited unsuccessfully.
+type ExitError struct {
+	*ExitStatus
+}
+
+func (e *ExitError) Error() string {
+	msg := "qemu: " + e.ExitStatus.String()
+
+	tail := e.Stderr
+	if len(tail) > stderrTailLines {
+		tail = tail[len(tail)-stderrTailLines:]
+	}
+	if len(tail) > 0 {
+		msg += " (" + strings.Join(tail, "; ") + ")"
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() error { return e.err }
@@ -0,0 +1,229 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+// ErrNoMonitor is returned by methods that need the QMP monitor when
+// the VM was launched without YeetWithMonitor.
+var ErrNoMonitor = errors.New("VM has no monitor")
+
+// Hotpluggable is a device or object that can be added to a running
+// VM.
+type Hotpluggable interface {
+	Device
+
+	// GetHotplugCommand returns the QMP command adding it, e.g.
+	// device_add, and the arguments of the command.
+	GetHotplugCommand() (command string, args map[string]any, err error)
+}
+
+func (v *VM) qmp() (*qpqmp.Client, error) {
+	if v.monitor == nil {
+		return nil, ErrNoMonitor
+	}
+	return v.monitor, nil
+}
+
+// hotplugID returns the id argument of a hotplug command.
+func hotplugID(args map[string]any) string {
+	id, _ := args["id"].(string)
+	return id
+}
+
+// Hotplug adds devices and objects to the running VM in order, e.g. a
+// memory backend object before the device using it.  Files cannot be
+// passed to QEMU at runtime, so devices and objects with files, e.g.
+// a qpdevices.MemoryBackendFileObject, have to be added when the VM
+// starts.
+func (v *VM) Hotplug(ctx context.Context, devices ...Hotpluggable) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		if p, ok := d.(FilesProvider); ok && len(p.GetFiles()) > 0 {
+			return errors.New("hotplug: cannot pass files at runtime")
+		}
+		cmd, args, err := d.GetHotplugCommand()
+		if err != nil {
+			return err
+		}
+		if err := mon.Execute(ctx, cmd, args, nil); err != nil {
+			return fmt.Errorf("hotplug %s: %w", hotplugID(args), err)
+		}
+	}
+	return nil
+}
+
+// Unplug asks the guest to release the device with the given id and
+// waits until QEMU removed it.
+func (v *VM) Unplug(ctx context.Context, id string) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+
+	deleted := mon.Await("DEVICE_DELETED", func(ev qpqmp.Event) bool {
+		var data struct {
+			Device string `json:"device"`
+		}
+		return ev.Decode(&data) == nil && data.Device == id
+	})
+	defer deleted.Stop()
+
+	if err := mon.Execute(ctx, "device_del", map[string]any{"id": id}, nil); err != nil {
+		return fmt.Errorf("unplug %s: %w", id, err)
+	}
+	if _, err := deleted.Wait(ctx); err != nil {
+		return fmt.Errorf("unplug %s: %w", id, err)
+	}
+	return nil
+}
+
+// RemoveObject removes the object with the given id, e.g. the memory
+// backend of an unplugged DIMM.
+func (v *VM) RemoveObject(ctx context.Context, id string) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+	return mon.Execute(ctx, "object-del", map[string]any{"id": id}, nil)
+}
+
+// ACPIOSTError is returned when the guest reports failing to handle
+// a hotplugged device through ACPI _OST.
+type ACPIOSTError struct {
+	Device string
+	Status int
+}
+
+func (e *ACPIOSTError) Error() string {
+	return fmt.Sprintf("guest failed to add %s: ACPI _OST status %#x", e.Device, e.Status)
+}
+
+// PlugDIMM adds the memory backend object and the DIMM using it, and
+// waits until the guest reports through ACPI that it added the
+// memory.  If adding the DIMM fails, the backend is removed again.
+//
+// Guests without ACPI memory hotplug never report, which makes
+// PlugDIMM wait until ctx is done.
+func (v *VM) PlugDIMM(ctx context.Context, backend, dimm Hotpluggable) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+	_, args, err := dimm.GetHotplugCommand()
+	if err != nil {
+		return err
+	}
+	id := hotplugID(args)
+
+	type ostInfo struct {
+		Device string `json:"device"`
+		Source int    `json:"source"`
+		Status int    `json:"status"`
+	}
+	ost := mon.Await("ACPI_DEVICE_OST", func(ev qpqmp.Event) bool {
+		var data struct {
+			Info ostInfo `json:"info"`
+		}
+		// Source 1 is the device check notification of an insertion.
+		return ev.Decode(&data) == nil && data.Info.Device == id && data.Info.Source == 1
+	})
+	defer ost.Stop()
+
+	if err := v.Hotplug(ctx, backend); err != nil {
+		return err
+	}
+	if err := v.Hotplug(ctx, dimm); err != nil {
+		if _, backendArgs, argsErr := backend.GetHotplugCommand(); argsErr == nil {
+			_ = v.RemoveObject(ctx, hotplugID(backendArgs))
+		}
+		return err
+	}
+
+	ev, err := ost.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("plug %s: %w", id, err)
+	}
+	var data struct {
+		Info ostInfo `json:"info"`
+	}
+	if err := ev.Decode(&data); err != nil {
+		return err
+	}
+	if data.Info.Status != 0 {
+		return &ACPIOSTError{Device: id, Status: data.Info.Status}
+	}
+	return nil
+}
+
+// UnplugDIMM unplugs the DIMM with the given id once the guest
+// released its memory and removes its memory backend object.
+func (v *VM) UnplugDIMM(ctx context.Context, id, backendID string) error {
+	if err := v.Unplug(ctx, id); err != nil {
+		return err
+	}
+	return v.RemoveObject(ctx, backendID)
+}
+
+// ResizeVirtIOMem sets the requested size of the virtio-mem device
+// with the given id and waits until the guest plugged or unplugged
+// memory to reach it.
+func (v *VM) ResizeVirtIOMem(ctx context.Context, id string, size uint64) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+	path := "/machine/peripheral/" + id
+
+	resized := mon.Await("MEMORY_DEVICE_SIZE_CHANGE", func(ev qpqmp.Event) bool {
+		var data struct {
+			ID   string `json:"id"`
+			Size uint64 `json:"size"`
+		}
+		return ev.Decode(&data) == nil && data.ID == id && data.Size == size
+	})
+	defer resized.Stop()
+
+	if err := mon.Execute(ctx, "qom-set", map[string]any{
+		"path": path, "property": "requested-size", "value": size,
+	}, nil); err != nil {
+		return fmt.Errorf("resize %s: %w", id, err)
+	}
+
+	// The guest may have the requested size already, in which case
+	// the size does not change.
+	var current uint64
+	if err := mon.Execute(ctx, "qom-get", map[string]any{"path": path, "property": "size"}, &current); err != nil {
+		return fmt.Errorf("resize %s: %w", id, err)
+	}
+	if current == size {
+		return nil
+	}
+
+	if _, err := resized.Wait(ctx); err != nil {
+		return fmt.Errorf("resize %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+)
+
+// fakeMemSize is the size of the virtio-mem device of the fake QEMU,
+// which follows the requested size after the next qom-get.
+var fakeMemSize, fakeMemRequested float64
+
+func fakeEvent(conn net.Conn, name, data string) {
+	fmt.Fprintf(conn, `{"event": %q, "data": %s, "timestamp": {"seconds": 1, "microseconds": 0}}`+"\n", name, data)
+}
+
+// fakeHotplug answers the hotplug commands of fakeServe and reports
+// whether it did.
+func fakeHotplug(conn net.Conn, cmd string, args map[string]any, id string) bool {
+	switch cmd {
+	case "device_add":
+		if args["id"] == "fail" {
+			fmt.Fprintf(conn, `{"error": {"class": "GenericError", "desc": "no slot"}, "id": %q}`+"\n", id)
+			return true
+		}
+		fmt.Fprintf(conn, `{"return": {}, "id": %q}`+"\n", id)
+		if args["driver"] == "pc-dimm" {
+			status := 0
+			if args["id"] == "rejected" {
+				status = 0x80
+			}
+			fakeEvent(conn, "ACPI_DEVICE_OST", fmt.Sprintf(`{"info": {"device": %q, "slot": "0", "slot-type": "DIMM", "source": 1, "status": %d}}`, args["id"], status))
+		}
+	case "device_del":
+		fmt.Fprintf(conn, `{"return": {}, "id": %q}`+"\n", id)
+		fakeEvent(conn, "DEVICE_DELETED", fmt.Sprintf(`{"device": %q, "path": "/machine/peripheral/%s"}`, args["id"], args["id"]))
+	case "qom-set":
+		fakeMemRequested = args["value"].(float64)
+		fmt.Fprintf(conn, `{"return": {}, "id": %q}`+"\n", id)
+	case "qom-get":
+		fmt.Fprintf(conn, `{"return": %d, "id": %q}`+"\n", uint64(fakeMemSize), id)
+		if fakeMemSize != fakeMemRequested {
+			fakeMemSize = fakeMemRequested
+			fakeEvent(conn, "MEMORY_DEVICE_SIZE_CHANGE", fmt.Sprintf(`{"id": "vmem", "size": %d, "qom-path": "/machine/peripheral/vmem"}`, uint64(fakeMemSize)))
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func yeetFake(t *testing.T) *libqatapult.VM {
+	t.Helper()
+
+	vm, err := libqatapult.Yeet(context.Background(), daemonConfig(),
+		libqatapult.YeetWithDetach(),
+		libqatapult.YeetWithMonitor(),
+		libqatapult.YeetWithRuntimeRoot(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = syscall.Kill(vm.Pid(), syscall.SIGKILL)
+		waitDone(t, vm)
+	})
+	return vm
+}
+
+func TestVM_PlugDIMM(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	vm := yeetFake(t)
+
+	backend := qpdevices.MemoryBackendRAMObject{}
+	backend.Name = "mem1"
+	backend.Size = 1 << 30
+
+	dimm := func(id string) qpdevices.PCDIMMDevice {
+		d := qpdevices.PCDIMMDevice{MemDev: qpdevices.Ref(backend)}
+		d.Name = id
+		return d
+	}
+
+	assert.NoError(vm.PlugDIMM(ctx, backend, dimm("dimm1")))
+
+	var ostErr *libqatapult.ACPIOSTError
+	if assert.ErrorAs(vm.PlugDIMM(ctx, backend, dimm("rejected")), &ostErr) {
+		assert.Equal("rejected", ostErr.Device)
+		assert.Equal(0x80, ostErr.Status)
+	}
+	assert.ErrorContains(vm.PlugDIMM(ctx, backend, dimm("fail")), "no slot")
+
+	assert.NoError(vm.UnplugDIMM(ctx, "dimm1", "mem1"))
+}
+
+func TestVM_ResizeVirtIOMem(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	vm := yeetFake(t)
+
+	assert.NoError(vm.ResizeVirtIOMem(ctx, "vmem", 2<<30))
+	assert.NoError(vm.ResizeVirtIOMem(ctx, "vmem", 2<<30))
+	assert.NoError(vm.ResizeVirtIOMem(ctx, "vmem", 0))
+}
+
+func TestVM_Hotplug(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx := context.Background()
+	vm := yeetFake(t)
+
+	mem := qpdevices.MemoryBackendRAMObject{}
+	mem.Name = "vmem-mem"
+	mem.Size = 4 << 30
+	vmem := qpdevices.VirtIOMemPCIDevice{MemDev: qpdevices.Ref(mem)}
+	vmem.Name = "vmem"
+
+	assert.NoError(vm.Hotplug(ctx, mem, vmem))
+	assert.NoError(vm.Unplug(ctx, "vmem"))
+	assert.NoError(vm.RemoveObject(ctx, "vmem-mem"))
+
+	file := qpdevices.MemoryBackendFileObject{MemPath: libqatapult.NewOsFile(os.Stdin)}
+	file.Name = "file"
+	assert.ErrorContains(vm.Hotplug(ctx, file), "cannot pass files")
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var Root = "/proc"
+
+// Stat holds the fields of /proc/<pid>/stat qatapult cares about.
+type Stat struct {
+	Pid     int
+	Comm    string
+	State   byte
+	PPid    int
+	PGrp    int
+	Session int
+
+	// StartTime is the time the process started after system boot
+	// in clock ticks.  Together with Pid it identifies a process
+	// uniquely for the lifetime of the system.
+	StartTime uint64
+}
+
+// ParseStat parses the contents of a /proc/<pid>/stat file.
+func ParseStat(b []byte) (s Stat, err error) {
+	// The comm field may contain spaces and parentheses itself,
+	// so the last closing parenthesis marks its end.
+	l, r := bytes.IndexByte(b, '('), bytes.LastIndexByte(b, ')')
+	if l < 0 || r < l {
+		return s, fmt.Errorf("procfs: malformed stat")
+	}
+
+	if s.Pid, err = strconv.Atoi(string(bytes.TrimSpace(b[:l]))); err != nil {
+		return s, fmt.Errorf("procfs: pid: %w", err)
+	}
+	s.Comm = string(b[l+1 : r])
+
+	// fields[0] is the state, which is the third field of the file.
+	fields := strings.Fields(string(b[r+1:]))
+	if len(fields) < 20 {
+		return s, fmt.Errorf("procfs: short stat")
+	}
+	s.State = fields[0][0]
+
+	for _, f := range []struct {
+		dst *int
+		idx int
+	}{{&s.PPid, 1}, {&s.PGrp, 2}, {&s.Session, 3}} {
+		if *f.dst, err = strconv.Atoi(fields[f.idx]); err != nil {
+			return s, fmt.Errorf("procfs: stat field %d: %w", f.idx+3, err)
+		}
+	}
+
+	if s.StartTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return s, fmt.Errorf("procfs: starttime: %w", err)
+	}
+	return s, nil
+}
+
+// ReadStat reads and parses /proc/<pid>/stat.
+func ReadStat(pid int) (Stat, error) {
+	b, err := os.ReadFile(filepath.Join(Root, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return Stat{}, err
+	}
+	return ParseStat(b)
+}
+
+func readNulSeparated(pid int, name string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(Root, strconv.Itoa(pid), name))
+	if err != nil {
+		return nil, err
+	}
+	if b = bytes.TrimRight(b, "\x00"); len(b) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(b), "\x00"), nil
+}
+
+// ReadEnviron returns the initial environment of the given process.
+func ReadEnviron(pid int) ([]string, error) { return readNulSeparated(pid, "environ") }
+
+// ReadCmdline returns the command line of the given process.
+func ReadCmdline(pid int) ([]string, error) { return readNulSeparated(pid, "cmdline") }
+
+// Pids lists the ids of all processes currently visible.
+func Pids() (out []int, err error) {
+	entries, err := os.ReadDir(Root)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			out = append(out, pid)
+		}
+	}
+	return out, nil
+}
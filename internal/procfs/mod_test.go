@@ -0,0 +1,54 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package procfs_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/internal/procfs"
+)
+
+func TestParseStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		inp     string
+		want    procfs.Stat
+		wantErr assertpkg.ErrorAssertionFunc
+	}{
+		{"simple",
+			"4242 (qemu-system-x86) S 1 4242 4242 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 987654 0 0",
+			procfs.Stat{Pid: 4242, Comm: "qemu-system-x86", State: 'S', PPid: 1, PGrp: 4242, Session: 4242, StartTime: 987654},
+			assertpkg.NoError,
+		},
+		{"comm-with-parens",
+			"17 (a) b (c) R 2 3 4 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 55 0 0",
+			procfs.Stat{Pid: 17, Comm: "a) b (c", State: 'R', PPid: 2, PGrp: 3, Session: 4, StartTime: 55},
+			assertpkg.NoError,
+		},
+		{"short", "17 (a) R 2 3", procfs.Stat{}, assertpkg.Error},
+		{"garbage", "garbage", procfs.Stat{}, assertpkg.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := procfs.ParseStat([]byte(tt.inp))
+			if !tt.wantErr(t, err) || err != nil {
+				return
+			}
+			assertpkg.Equal(t, tt.want, got)
+		})
+	}
+}
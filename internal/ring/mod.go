@@ -0,0 +1,68 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package ring
+
+import (
+	"sync"
+)
+
+// Buffer is a bounded, concurrency safe buffer which keeps the most
+// recently pushed items and discards older ones.
+type Buffer[T any] struct {
+	mu    sync.Mutex
+	items []T
+	start int
+	n     int
+}
+
+// Push adds v to the buffer, evicting the oldest item when full.
+func (b *Buffer[T]) Push(v T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.items) == 0 {
+		return
+	}
+
+	b.items[(b.start+b.n)%len(b.items)] = v
+	if b.n < len(b.items) {
+		b.n++
+	} else {
+		b.start = (b.start + 1) % len(b.items)
+	}
+}
+
+// Len returns the number of items held by the buffer.
+func (b *Buffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// Snapshot returns a copy of the buffered items, oldest first.
+func (b *Buffer[T]) Snapshot() []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]T, b.n)
+	for i := range out {
+		out[i] = b.items[(b.start+i)%len(b.items)]
+	}
+	return out
+}
+
+func New[T any](size int) *Buffer[T] {
+	return &Buffer[T]{items: make([]T, size)}
+}
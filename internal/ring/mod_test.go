@@ -0,0 +1,48 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package ring_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/internal/ring"
+)
+
+func TestBuffer(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		push []int
+		want []int
+	}{
+		{"empty", 3, nil, []int{}},
+		{"partial", 3, []int{1, 2}, []int{1, 2}},
+		{"full", 3, []int{1, 2, 3}, []int{1, 2, 3}},
+		{"wrapped", 3, []int{1, 2, 3, 4, 5}, []int{3, 4, 5}},
+		{"zero-sized", 0, []int{1, 2}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := ring.New[int](tt.size)
+			for _, v := range tt.push {
+				b.Push(v)
+			}
+			assertpkg.Equal(t, tt.want, b.Snapshot())
+			assertpkg.Equal(t, len(tt.want), b.Len())
+		})
+	}
+}
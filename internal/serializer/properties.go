@@ -0,0 +1,168 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package serializer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// propertyState collects the properties of a single option with the
+// types QMP expects, e.g. for device_add or object-add.
+type propertyState struct {
+	props   map[string]any
+	typeKey string
+	opt     *string
+}
+
+func (p *propertyState) set(name string, v any) {
+	m := p.props
+	keys := strings.Split(name, ".")
+	for _, k := range keys[:len(keys)-1] {
+		sub, ok := m[k].(map[string]any)
+		if !ok {
+			sub = map[string]any{}
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[keys[len(keys)-1]] = v
+}
+
+func (p *propertyState) encodeStruct(v reflect.Value, vt reflect.Type) error {
+	for i := 0; i < vt.NumField(); i++ {
+		f, ft := v.Field(i), vt.Field(i)
+
+		opts, err := loadOptions(ft)
+		if err != nil {
+			return err
+		}
+
+		if opts.Opt != nil {
+			if p.opt != nil && *p.opt != *opts.Opt {
+				return fmt.Errorf("options %s and %s: %w", *p.opt, *opts.Opt, ErrUnsupportedType)
+			}
+			p.opt = opts.Opt
+		}
+
+		if opts.Skip || f.IsZero() {
+			continue
+		}
+
+		if opts.Name == nil && !opts.Unnamed {
+			name := fieldName(ft, &opts)
+			opts.Name = &name
+		}
+
+		if err := p.reflectValue(f, &opts); err != nil {
+			return fmt.Errorf(".%s: %w", ft.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *propertyState) reflectValue(v reflect.Value, opt *options) error {
+	vt := v.Type()
+
+	switch {
+	case vt.Implements(holderType):
+		if v.Interface().(holder).IsSome() {
+			return p.reflectValue(v.MethodByName("Yank").Call(nil)[0], opt)
+		}
+		return nil
+	case vt.Implements(markerType):
+		return p.setValue(v.Interface().(marker).GetPath(), opt)
+	case vt.Implements(stringerType):
+		return p.setValue(v.Interface().(fmt.Stringer).String(), opt)
+	case vt.Implements(referencerType):
+		return p.setValue(v.Interface().(pointer).PointingTo(), opt)
+	}
+
+	switch vt.Kind() {
+	case reflect.Struct:
+		return p.encodeStruct(v, vt)
+	case reflect.Slice:
+		return p.encodeSlice(v, opt)
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			name := k.String()
+			if err := p.reflectValue(v.MapIndex(k), &options{Name: &name}); err != nil {
+				return fmt.Errorf(".%s: %w", name, err)
+			}
+		}
+		return nil
+	case reflect.String:
+		return p.setValue(v.String(), opt)
+	case reflect.Bool:
+		return p.setValue(v.Bool(), opt)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return p.setValue(v.Int(), opt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return p.setValue(v.Uint(), opt)
+	case reflect.Pointer, reflect.Interface:
+		return p.reflectValue(v.Elem(), opt)
+	default:
+		return fmt.Errorf("%s(%s): %w", vt.Kind(), v.String(), ErrUnsupportedType)
+	}
+}
+
+func (p *propertyState) encodeSlice(v reflect.Value, opt *options) error {
+	if v.Len() == 0 {
+		return nil
+	}
+	list := make([]any, v.Len())
+	for i := range list {
+		elem := &propertyState{props: map[string]any{}}
+		name := "v"
+		if err := elem.reflectValue(v.Index(i), &options{Name: &name}); err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+		list[i] = elem.props[name]
+	}
+	if opt.Join != nil {
+		s := make([]string, len(list))
+		for i, elem := range list {
+			s[i] = fmt.Sprint(elem)
+		}
+		return p.setValue(strings.Join(s, *opt.Join), opt)
+	}
+	return p.setValue(list, opt)
+}
+
+func (p *propertyState) setValue(v any, opt *options) error {
+	if opt != nil && opt.Name != nil {
+		p.set(*opt.Name, v)
+		return nil
+	}
+	if p.typeKey == "" || p.props[p.typeKey] != nil {
+		return fmt.Errorf("positional value %v: %w", v, ErrUnsupportedType)
+	}
+	p.props[p.typeKey] = v
+	return nil
+}
+
+// GetProperties returns the properties data renders on the command
+// line as QMP arguments, with their values typed, e.g. for
+// device_add.  The leading unnamed value, the type of a device or
+// object, is stored under typeKey.  Dotted names become nested
+// objects.
+func GetProperties(data any, typeKey string) (map[string]any, error) {
+	p := &propertyState{props: map[string]any{}, typeKey: typeKey}
+	if err := p.reflectValue(reflect.ValueOf(data), nil); err != nil {
+		return nil, fmt.Errorf("qpdevices/serialize: %w", err)
+	}
+	return p.props, nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package serializer_test
+
+import (
+	"net"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+type reference string
+
+func (r reference) PointingTo() string { return string(r) }
+
+func TestGetProperties(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	type Base struct {
+		_    any    `qp:"opt=device"`
+		Type string `qp:"~unnamed"`
+		Name string `qp:"name=id"`
+	}
+	type TestStruct struct {
+		Base
+		MemDev      reference               `qp:"name=memdev"`
+		Size        uint64                  `qp:""`
+		Node        qpoption.Option[uint32] `qp:""`
+		Prealloc    qpoption.Option[bool]   `qp:""`
+		Off         qpoption.Option[bool]   `qp:""`
+		Addr        net.IP                  `qp:""`
+		Accel       []string                `qp:"join=':'"`
+		IOPS        int                     `qp:"name='limits.iops-total'"`
+		Unset       string                  `qp:""`
+		Flags       []uint32                `qp:""`
+		Attributes  map[string]any
+		SkipMe      string `qp:"~skip"`
+		DetectZeros bool   `qp:"~kebab"`
+	}
+
+	got, err := serializer.GetProperties(TestStruct{
+		Base:        Base{Type: "pc-dimm", Name: "dimm0"},
+		MemDev:      "mem0",
+		Size:        1 << 30,
+		Node:        qpoption.Value[uint32](0),
+		Prealloc:    qpoption.Value(true),
+		Off:         qpoption.Value(false),
+		Addr:        net.IPv4(10, 0, 2, 2),
+		Accel:       []string{"kvm", "tcg"},
+		IOPS:        200,
+		Flags:       []uint32{1, 2},
+		Attributes:  map[string]any{"x-extra": "yes"},
+		SkipMe:      "skipped",
+		DetectZeros: true,
+	}, "driver")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(map[string]any{
+		"driver":       "pc-dimm",
+		"id":           "dimm0",
+		"memdev":       "mem0",
+		"size":         uint64(1 << 30),
+		"node":         uint64(0),
+		"prealloc":     true,
+		"off":          false,
+		"addr":         "10.0.2.2",
+		"accel":        "kvm:tcg",
+		"limits":       map[string]any{"iops-total": int64(200)},
+		"flags":        []any{uint64(1), uint64(2)},
+		"x-extra":      "yes",
+		"detect-zeros": true,
+	}, got)
+}
+
+func TestGetProperties_Errors(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	type Positional struct {
+		Args []string `qp:"~unnamed,~repeat"`
+	}
+	_, err := serializer.GetProperties(Positional{Args: []string{"a"}}, "")
+	assert.ErrorIs(err, serializer.ErrUnsupportedType)
+
+	type TwoOptions struct {
+		Kernel string `qp:"opt=kernel,~unnamed"`
+		InitRd string `qp:"opt=initrd,~unnamed"`
+	}
+	_, err = serializer.GetProperties(TwoOptions{Kernel: "a", InitRd: "b"}, "file")
+	assert.ErrorIs(err, serializer.ErrUnsupportedType)
+}
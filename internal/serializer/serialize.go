@@ -128,12 +128,7 @@ func (e *encoderState) encodeStruct(v reflect.Value, vt reflect.Type) error {
 		}
 
 		if opts.Name == nil && !opts.Unnamed {
-			var name string
-			if opts.Kebab {
-				name = toKebabCase(ft.Name)
-			} else {
-				name = strings.ToLower(ft.Name)
-			}
+			name := fieldName(ft, &opts)
 			opts.Name = &name
 		}
 
@@ -144,6 +139,15 @@ func (e *encoderState) encodeStruct(v reflect.Value, vt reflect.Type) error {
 	return nil
 }
 
+// fieldName returns the property name of a field without an explicit
+// name.
+func fieldName(ft reflect.StructField, opts *options) string {
+	if opts.Kebab {
+		return toKebabCase(ft.Name)
+	}
+	return strings.ToLower(ft.Name)
+}
+
 func (e *encoderState) encodeMap(v reflect.Value, opt *options) error {
 	for _, k := range v.MapKeys() {
 		kString := k.String()
@@ -211,7 +215,9 @@ func (e *encoderState) encodeReference(v reflect.Value, opt *options) error {
 
 func (e *encoderState) encodeOption(v reflect.Value, opt *options) error {
 	if o, ok := v.Interface().(holder); ok && o.IsSome() {
-		return e.reflectValue(v.Field(0).Elem(), opt)
+		// Go through Yank, values read from the unexported field
+		// cannot be turned back into interfaces, e.g. Stringers.
+		return e.reflectValue(v.MethodByName("Yank").Call(nil)[0], opt)
 	}
 	return nil
 }
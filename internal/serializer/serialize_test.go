@@ -285,6 +285,7 @@ func TestGetCliArgs_Option(t *testing.T) {
 	type TestStruct struct {
 		_     any `qp:"opt='netdev'"`
 		Alpha qpoption.Option[int]
+		Bravo qpoption.Option[net.IP]
 	}
 
 	tests := []struct {
@@ -295,6 +296,8 @@ func TestGetCliArgs_Option(t *testing.T) {
 		{"nil", TestStruct{}, []string(nil)},
 
 		{"value", TestStruct{Alpha: qpoption.Value(4)}, []string{"-netdev", "alpha=4"}},
+
+		{"stringer", TestStruct{Bravo: qpoption.Value(net.IPv4(10, 0, 2, 2))}, []string{"-netdev", "bravo=10.0.2.2"}},
 	}
 
 	for _, tt := range tests {
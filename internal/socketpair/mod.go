@@ -17,14 +17,21 @@ package socketpair
 import (
 	"os"
 
+	"go.uber.org/multierr"
 	"golang.org/x/sys/unix"
 )
 
+// New creates a pair of connected sockets.  Only the left side is
+// non-blocking, the right side is meant to be passed to a child
+// process, which may not expect a non-blocking file descriptor.
 func New(name string, typ, proto int) (l, r *os.File, err error) {
-	socketFds, err := unix.Socketpair(unix.AF_UNIX, typ|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, proto)
+	socketFds, err := unix.Socketpair(unix.AF_UNIX, typ|unix.SOCK_CLOEXEC, proto)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := unix.SetNonblock(socketFds[0], true); err != nil {
+		return nil, nil, multierr.Combine(err, unix.Close(socketFds[0]), unix.Close(socketFds[1]))
+	}
 	lfd, rfd := uintptr(socketFds[0]), uintptr(socketFds[1])
 	return os.NewFile(lfd, name+":l"), os.NewFile(rfd, name+":r"), nil
 }
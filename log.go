@@ -0,0 +1,215 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogSeverity classifies a line logged by QEMU.
+type LogSeverity int
+
+const (
+	LogDebug LogSeverity = iota
+	LogInfo
+	LogDeprecation
+	LogWarning
+	LogError
+)
+
+func (s LogSeverity) String() string {
+	switch s {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogDeprecation:
+		return "deprecation"
+	case LogWarning:
+		return "warning"
+	case LogError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Level maps the severity to a slog.Level.
+func (s LogSeverity) Level() slog.Level {
+	switch s {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogInfo:
+		return slog.LevelInfo
+	case LogDeprecation, LogWarning:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// LogSource tells where QEMU logged a line to.
+type LogSource string
+
+const (
+	LogSourceStderr  LogSource = "stderr"
+	LogSourceLogfile LogSource = "logfile"
+)
+
+// LogRecord is a single line logged by QEMU.
+type LogRecord struct {
+	Time     time.Time
+	Source   LogSource
+	Severity LogSeverity
+
+	// Location is the command line option or source location the
+	// message refers to, e.g. "-device foo".
+	Location string
+
+	Message string
+
+	// Line is the line as QEMU logged it.
+	Line string
+}
+
+// cutProgram strips the "qemu-system-x86_64: " prefix QEMU puts in
+// front of its messages.
+func cutProgram(line string) (rest string, found bool) {
+	prog, rest, found := strings.Cut(line, ": ")
+	if !found || prog == "" || strings.ContainsAny(prog, " \t") {
+		return line, false
+	}
+	return rest, true
+}
+
+// ParseLogLine parses a single line QEMU logged to source.
+func ParseLogLine(source LogSource, line string) LogRecord {
+	r := LogRecord{Time: time.Now(), Source: source, Severity: LogDebug, Line: line, Message: line}
+	if source != LogSourceStderr {
+		return r
+	}
+
+	// Messages are prefixed with a timestamp with -msg timestamp=on.
+	msg := line
+	if ts, rest, found := strings.Cut(msg, " "); found {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			r.Time, msg = t, rest
+		}
+	}
+
+	msg, found := cutProgram(msg)
+	if !found {
+		// Continuation lines and the like.
+		r.Severity, r.Message = LogInfo, msg
+		return r
+	}
+
+	if strings.HasPrefix(msg, "-") {
+		if loc, rest, found := strings.Cut(msg, ": "); found {
+			r.Location, msg = loc, rest
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "warning: "):
+		r.Severity, msg = LogWarning, strings.TrimPrefix(msg, "warning: ")
+		if strings.Contains(strings.ToLower(msg), "deprecat") {
+			r.Severity = LogDeprecation
+		}
+	case strings.HasPrefix(msg, "info: "):
+		r.Severity, msg = LogInfo, strings.TrimPrefix(msg, "info: ")
+	default:
+		r.Severity = LogError
+	}
+	r.Message = msg
+	return r
+}
+
+// log records a line QEMU logged.
+func (v *VM) log(source LogSource, line string) {
+	r := ParseLogLine(source, line)
+	v.logs.Push(r)
+
+	if v.logFile != nil {
+		_, _ = fmt.Fprintf(v.logFile, "%s %s: %s\n", r.Time.Format(time.RFC3339Nano), r.Source, r.Line)
+	}
+
+	if v.logHandler == nil {
+		return
+	}
+
+	ctx := context.Background()
+	if !v.logHandler.Enabled(ctx, r.Severity.Level()) {
+		return
+	}
+
+	rec := slog.NewRecord(r.Time, r.Severity.Level(), r.Message, 0)
+	rec.AddAttrs(slog.String("source", string(r.Source)))
+	if r.Location != "" {
+		rec.AddAttrs(slog.String("location", r.Location))
+	}
+	if r.Severity == LogDeprecation {
+		rec.AddAttrs(slog.Bool("deprecated", true))
+	}
+	_ = v.logHandler.Handle(ctx, rec)
+}
+
+// maxLogLine is the length at which lines QEMU logs are split, so a
+// long line never stops the log from being drained.
+const maxLogLine = 64 << 10
+
+// readLog reads lines logged to r by QEMU until it is closed.
+func (v *VM) readLog(source LogSource, r *os.File) {
+	defer v.logWG.Done()
+	defer r.Close()
+
+	br := bufio.NewReaderSize(r, maxLogLine)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			v.log(source, strings.TrimSuffix(string(line), "\n"))
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return
+		}
+	}
+}
+
+// Logs returns the most recent lines QEMU logged.
+func (v *VM) Logs() []LogRecord { return v.logs.Snapshot() }
+
+// YeetWithLogHandler forwards every line QEMU logs as a structured
+// record to h.
+func YeetWithLogHandler(h slog.Handler) YeetOption {
+	return func(o *yeetOptions) { o.logHandler = h }
+}
+
+// YeetWithDebugLog enables the given QEMU log items (see -d help)
+// and captures their output like stderr.  Detached VMs write it to a
+// file in their runtime directory instead.
+func YeetWithDebugLog(items ...string) YeetOption {
+	return func(o *yeetOptions) { o.debugLog = append(o.debugLog, items...) }
+}
+
+// YeetWithLogTail sets the number of log records kept by the VM, which
+// must not be negative.
+func YeetWithLogTail(n int) YeetOption {
+	return func(o *yeetOptions) { o.logTail = n }
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		source libqatapult.LogSource
+		line   string
+		want   libqatapult.LogRecord
+	}{
+		{"error", libqatapult.LogSourceStderr,
+			"qemu-system-x86_64: could not load PC BIOS 'bios-256k.bin'",
+			libqatapult.LogRecord{Severity: libqatapult.LogError, Message: "could not load PC BIOS 'bios-256k.bin'"},
+		},
+		{"error-with-location", libqatapult.LogSourceStderr,
+			"qemu-system-x86_64: -device foo: 'foo' is not a valid device model name",
+			libqatapult.LogRecord{Severity: libqatapult.LogError, Location: "-device foo", Message: "'foo' is not a valid device model name"},
+		},
+		{"warning", libqatapult.LogSourceStderr,
+			"qemu-system-x86_64: warning: host doesn't support requested feature: CPUID.80000001H:ECX.svm [bit 2]",
+			libqatapult.LogRecord{Severity: libqatapult.LogWarning, Message: "host doesn't support requested feature: CPUID.80000001H:ECX.svm [bit 2]"},
+		},
+		{"deprecation", libqatapult.LogSourceStderr,
+			"qemu-system-x86_64: -machine pc-i440fx-2.0: warning: Machine type 'pc-i440fx-2.0' is deprecated",
+			libqatapult.LogRecord{Severity: libqatapult.LogDeprecation, Location: "-machine pc-i440fx-2.0", Message: "Machine type 'pc-i440fx-2.0' is deprecated"},
+		},
+		{"info", libqatapult.LogSourceStderr,
+			"qemu-system-x86_64: info: Launching VM",
+			libqatapult.LogRecord{Severity: libqatapult.LogInfo, Message: "Launching VM"},
+		},
+		{"unprefixed", libqatapult.LogSourceStderr,
+			"Supported machines are:",
+			libqatapult.LogRecord{Severity: libqatapult.LogInfo, Message: "Supported machines are:"},
+		},
+		{"logfile", libqatapult.LogSourceLogfile,
+			"Invalid read at addr 0xFED40000, size 1, region '(null)', reason: rejected",
+			libqatapult.LogRecord{Severity: libqatapult.LogDebug, Message: "Invalid read at addr 0xFED40000, size 1, region '(null)', reason: rejected"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := libqatapult.ParseLogLine(tt.source, tt.line)
+
+			tt.want.Source, tt.want.Line, tt.want.Time = tt.source, tt.line, got.Time
+			assertpkg.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseLogLine_Timestamp(t *testing.T) {
+	got := libqatapult.ParseLogLine(libqatapult.LogSourceStderr,
+		"2023-03-01T10:20:30.123456Z qemu-system-x86_64: warning: dubious")
+
+	assertpkg.Equal(t, time.Date(2023, 3, 1, 10, 20, 30, 123456000, time.UTC), got.Time)
+	assertpkg.Equal(t, libqatapult.LogWarning, got.Severity)
+	assertpkg.Equal(t, "dubious", got.Message)
+}
+
+// recordingHandler is a slog.Handler remembering every record.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestYeet_LogCapture(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	script := `
+for arg; do logfile=$arg; done
+echo "guest_errors: Invalid write at addr 0x0" > "$logfile"
+sleep 0.2
+echo "qemu-system-x86_64: warning: -smp 2 is deprecated" >&2
+echo "qemu-system-x86_64: -device foo: broken" >&2
+exit 1`
+
+	h := &recordingHandler{}
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(script),
+		libqatapult.YeetWithLogHandler(h),
+		libqatapult.YeetWithDebugLog("guest_errors"),
+		libqatapult.YeetWithLogTail(2))
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	levels := map[string]slog.Level{}
+	for _, r := range h.records {
+		levels[r.Message] = r.Level
+	}
+	assert.Equal(map[string]slog.Level{
+		"guest_errors: Invalid write at addr 0x0": slog.LevelDebug,
+		"-smp 2 is deprecated":                    slog.LevelWarn,
+		"broken":                                  slog.LevelError,
+	}, levels)
+
+	// Only the last two records are kept.
+	logs := vm.Logs()
+	if assert.Len(logs, 2) {
+		assert.Equal(libqatapult.LogError, logs[1].Severity)
+		assert.Equal("-device foo", logs[1].Location)
+	}
+	assert.Equal(logs, vm.ExitStatus().Log)
+}
+
+func TestYeet_LogLongLine(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	script := `
+for arg; do logfile=$arg; done
+{ head -c 100000 /dev/zero | tr '\0' x; echo; echo after; } > "$logfile"`
+
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(script),
+		libqatapult.YeetWithDebugLog("guest_errors"))
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+
+	var lengths []int
+	for _, r := range vm.Logs() {
+		lengths = append(lengths, len(r.Line))
+	}
+	assert.Equal([]int{64 << 10, 100000 - 64<<10, len("after")}, lengths)
+}
+
+func TestYeet_StderrLongLine(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(`head -c 150000 /dev/zero | tr '\0' x >&2`))
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+
+	var lengths []int
+	for _, r := range vm.Logs() {
+		lengths = append(lengths, len(r.Line))
+	}
+	assert.Equal([]int{64 << 10, 64 << 10, 150000 - 128<<10}, lengths)
+}
+
+func TestYeet_NegativeLogTail(t *testing.T) {
+	_, err := libqatapult.Yeet(context.Background(), shellConfig("exit 0"), libqatapult.YeetWithLogTail(-1))
+	assertpkg.ErrorContains(t, err, "negative log tail")
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/socketpair"
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+// MonitorName is the chardev id of the QMP monitor attached with
+// YeetWithMonitor.
+const MonitorName = "qatapult-qmp"
+
+// monitor connects a QMP monitor to QEMU through a socket pair.
+type monitor struct {
+	client *qpqmp.Client
+	vmFile *os.File
+}
+
+// install passes the QEMU side of the monitor to cmd.
+func (m *monitor) install(o *yeetOptions) {
+	fd := o.addFile(m.vmFile)
+	installMonitor(o, fmt.Sprintf("fd=%d", fd))
+}
+
+// installMonitor adds a QMP monitor on the socket chardev described
+// by addr to the QEMU command line.
+func installMonitor(o *yeetOptions, addr string) {
+	o.args = append(o.args,
+		"-chardev", fmt.Sprintf("socket,id=%s,%s", MonitorName, addr),
+		"-mon", fmt.Sprintf("chardev=%s,mode=control", MonitorName))
+}
+
+// installMonitorSocket makes QEMU listen for a single QMP client on
+// the unix socket at path.
+func installMonitorSocket(o *yeetOptions, path string) {
+	installMonitor(o, fmt.Sprintf("path=%s,server=on,wait=off", path))
+}
+
+// dialMonitor connects to the QMP monitor listening at path.
+func dialMonitor(path string) (*qpqmp.Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return qpqmp.NewClient(conn), nil
+}
+
+func newMonitor() (m *monitor, err error) {
+	l, r, err := socketpair.New("monitor", unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer multierr.AppendInvoke(&err, multierr.Close(l))
+
+	conn, err := net.FileConn(l)
+	if err != nil {
+		return nil, multierr.Append(err, r.Close())
+	}
+	return &monitor{client: qpqmp.NewClient(conn), vmFile: r}, nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/procfs"
+)
+
+// OwnerEnv is the environment variable every QEMU process launched
+// by Yeet is tagged with.  It identifies the launching process by
+// its pid and start time.
+const OwnerEnv = "QATAPULT_OWNER"
+
+// Owner identifies a process that launched QEMU instances.
+type Owner struct {
+	Pid       int
+	StartTime uint64
+}
+
+func (o Owner) String() string { return fmt.Sprintf("%d:%d", o.Pid, o.StartTime) }
+
+// Alive reports whether the owning process is still running.
+func (o Owner) Alive() bool {
+	st, err := procfs.ReadStat(o.Pid)
+	return err == nil && st.StartTime == o.StartTime
+}
+
+func parseOwner(s string) (o Owner, err error) {
+	_, err = fmt.Sscanf(s, "%d:%d", &o.Pid, &o.StartTime)
+	return
+}
+
+var (
+	selfOnce  sync.Once
+	selfOwner Owner
+	selfErr   error
+)
+
+// self returns the Owner identifying the current process.
+func self() (Owner, error) {
+	selfOnce.Do(func() {
+		st, err := procfs.ReadStat(os.Getpid())
+		if err != nil {
+			selfErr = fmt.Errorf("owner: %w", err)
+			return
+		}
+		selfOwner = Owner{Pid: st.Pid, StartTime: st.StartTime}
+	})
+	return selfOwner, selfErr
+}
+
+// tagEnviron adds the OwnerEnv tag to the given environment.
+func tagEnviron(env []string) ([]string, error) {
+	o, err := self()
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env[:len(env):len(env)], OwnerEnv+"="+o.String()), nil
+}
+
+// untagEnviron removes the OwnerEnv tag from the given environment,
+// for processes that are meant to outlive their launching process.
+func untagEnviron(env []string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env[:len(env):len(env)], OwnerEnv+"=")
+}
+
+func lookupOwner(env []string) (o Owner, found bool) {
+	// Later entries take precedence, same as in os/exec.
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], OwnerEnv+"="); ok {
+			o, err := parseOwner(v)
+			return o, err == nil
+		}
+	}
+	return o, false
+}
+
+// Orphan is a QEMU process launched by Yeet whose Owner no longer
+// exists, usually because the owning process crashed.
+type Orphan struct {
+	Pid     int
+	Owner   Owner
+	CmdLine []string
+
+	startTime uint64
+}
+
+// Signal sends sig to the orphaned process.  The process identity
+// is verified through a pidfd first, so a process that reused the
+// pid of the orphan in the meantime is never signalled.
+func (o Orphan) Signal(sig syscall.Signal) (err error) {
+	fd, err := unix.PidfdOpen(o.Pid, 0)
+	if err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	defer multierr.AppendInvoke(&err, multierr.Close(os.NewFile(uintptr(fd), "pidfd")))
+
+	if st, err := procfs.ReadStat(o.Pid); err != nil || st.StartTime != o.startTime {
+		return os.ErrProcessDone
+	}
+	return unix.PidfdSendSignal(fd, sig, nil, 0)
+}
+
+// Kill terminates the orphaned process immediately.
+func (o Orphan) Kill() error { return o.Signal(syscall.SIGKILL) }
+
+// FindOrphans scans the system for QEMU processes launched by Yeet
+// whose owning process is gone.  Processes that can not be
+// inspected, e.g. because they belong to other users, are skipped.
+func FindOrphans() (out []Orphan, err error) {
+	pids, err := procfs.Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pid := range pids {
+		orphan, err := inspectOrphan(pid)
+		if err != nil {
+			// The process may vanish while it is being inspected.
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, unix.ESRCH) {
+				continue
+			}
+			return nil, err
+		}
+		if orphan != nil {
+			out = append(out, *orphan)
+		}
+	}
+	return out, nil
+}
+
+func inspectOrphan(pid int) (*Orphan, error) {
+	env, err := procfs.ReadEnviron(pid)
+	if err != nil {
+		return nil, err
+	}
+	owner, found := lookupOwner(env)
+	if !found || owner.Alive() {
+		return nil, nil
+	}
+
+	st, err := procfs.ReadStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	if st.State == 'Z' {
+		// Zombies are waiting for their parent and can not be
+		// reaped by signals anymore.
+		return nil, nil
+	}
+
+	cmdline, err := procfs.ReadCmdline(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &Orphan{Pid: pid, Owner: owner, CmdLine: cmdline, startTime: st.StartTime}, nil
+}
+
+// ReapOrphans kills all orphaned QEMU processes and returns the ones
+// that were killed.
+func ReapOrphans() (killed []Orphan, err error) {
+	orphans, err := FindOrphans()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range orphans {
+		if kErr := o.Kill(); kErr != nil {
+			if !errors.Is(kErr, os.ErrProcessDone) {
+				err = multierr.Append(err, fmt.Errorf("orphan %d: %w", o.Pid, kErr))
+			}
+			continue
+		}
+		killed = append(killed, o)
+	}
+	return killed, err
+}
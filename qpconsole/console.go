@@ -0,0 +1,327 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpconsole automates text consoles of guests, like serial
+// consoles connected through a Conduit.
+package qpconsole
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed      = errors.New("qpconsole: console closed")
+	ErrLoginFailed = errors.New("qpconsole: login incorrect")
+)
+
+var (
+	// LoginPrompt matches the prompt of getty asking for the user.
+	LoginPrompt = regexp.MustCompile(`login:\s*$`)
+
+	// PasswordPrompt matches the prompt asking for the password.
+	PasswordPrompt = regexp.MustCompile(`(?i)password:\s*$`)
+
+	// ShellPrompt matches the default prompt of most shells.
+	ShellPrompt = regexp.MustCompile(`[#$>]\s*$`)
+
+	loginIncorrect = regexp.MustCompile(`Login incorrect`)
+)
+
+// TimeoutError is returned when the console did not print what was
+// expected in time.  It carries the last screen of output, which
+// usually tells what went wrong.
+type TimeoutError struct {
+	Patterns []string
+	Waited   time.Duration
+	Screen   string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("qpconsole: timed out after %s waiting for %s, last output:\n%s",
+		e.Waited, strings.Join(e.Patterns, " or "), e.Screen)
+}
+
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Match describes the output matched by Expect.
+type Match struct {
+	// Groups holds the text of the whole match followed by the
+	// text of each capture group.
+	Groups []string
+
+	// Before holds the output between the previous match and this
+	// one.
+	Before string
+}
+
+func (m *Match) String() string { return m.Groups[0] }
+
+type options struct {
+	lineEnding     string
+	prompt         *regexp.Regexp
+	transcriptSize int
+	screenLines    int
+}
+
+type Opt func(o *options)
+
+// WithLineEnding sets what SendLine terminates lines with, which is
+// "\n" by default.
+func WithLineEnding(s string) Opt { return func(o *options) { o.lineEnding = s } }
+
+// WithPrompt sets the shell prompt Login waits for, which is
+// ShellPrompt by default.
+func WithPrompt(re *regexp.Regexp) Opt { return func(o *options) { o.prompt = re } }
+
+// WithTranscriptSize bounds the number of bytes the transcript keeps.
+func WithTranscriptSize(n int) Opt { return func(o *options) { o.transcriptSize = n } }
+
+// WithScreenLines sets the number of lines reported in TimeoutError.
+func WithScreenLines(n int) Opt { return func(o *options) { o.screenLines = n } }
+
+// Console drives a text console by waiting for output and sending
+// input in response, like expect(1).
+//
+// All output is read by a background goroutine as soon as it becomes
+// available, so the guest is never stalled by a full console buffer.
+type Console struct {
+	opts options
+
+	wmu sync.Mutex
+	w   io.Writer
+
+	mu         sync.Mutex
+	pending    []byte // output not consumed by Expect yet
+	transcript []byte
+	changed    chan struct{}
+	err        error
+	doneCh     chan struct{}
+
+	closer io.Closer
+}
+
+// Done is closed once the console stopped reading output.
+func (c *Console) Done() <-chan struct{} { return c.doneCh }
+
+// Close closes the underlying connection if it is an io.Closer.
+func (c *Console) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func (c *Console) read(r io.Reader) {
+	defer close(c.doneCh)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+
+		c.mu.Lock()
+		c.pending = appendBounded(c.pending, buf[:n], c.opts.transcriptSize)
+		c.transcript = appendBounded(c.transcript, buf[:n], c.opts.transcriptSize)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrClosed
+			}
+			c.err = err
+		}
+		close(c.changed)
+		c.changed = make(chan struct{})
+		c.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// appendBounded appends p to b, dropping data from the front of b so
+// it holds at most limit bytes.
+func appendBounded(b, p []byte, limit int) []byte {
+	b = append(b, p...)
+	if over := len(b) - limit; over > 0 {
+		b = append(b[:0], b[over:]...)
+	}
+	return b
+}
+
+// Transcript returns all output of the console, limited to the size
+// set with WithTranscriptSize.
+func (c *Console) Transcript() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.transcript)
+}
+
+// ansiEscape matches ANSI escape sequences, which are noise in error
+// messages.
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|[@-Z\\-_])`)
+
+// Screen returns the last lines of output, stripped of escape
+// sequences.
+func (c *Console) Screen() string {
+	text := ansiEscape.ReplaceAllString(string(c.Transcript()), "")
+	text = strings.ReplaceAll(text, "\r", "")
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > c.opts.screenLines {
+		lines = lines[len(lines)-c.opts.screenLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Expect waits for output matching re and consumes all output up to
+// the end of the match.
+func (c *Console) Expect(re *regexp.Regexp, timeout time.Duration) (*Match, error) {
+	_, m, err := c.ExpectAny(timeout, re)
+	return m, err
+}
+
+// ExpectString waits for s to be printed, see Expect.
+func (c *Console) ExpectString(s string, timeout time.Duration) (*Match, error) {
+	return c.Expect(regexp.MustCompile(regexp.QuoteMeta(s)), timeout)
+}
+
+// ExpectAny waits for output matching any of res and returns the
+// index of the expression matching first.
+func (c *Console) ExpectAny(timeout time.Duration, res ...*regexp.Regexp) (int, *Match, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		i, m := c.match(res)
+		changed, err := c.changed, c.err
+		c.mu.Unlock()
+
+		switch {
+		case m != nil:
+			return i, m, nil
+		case err != nil:
+			return -1, nil, fmt.Errorf("qpconsole: waiting for %s: %w", patterns(res), err)
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return -1, nil, &TimeoutError{Patterns: patterns(res), Waited: timeout, Screen: c.Screen()}
+		}
+	}
+}
+
+// match finds the earliest match of any of res in the pending output
+// and consumes it.
+func (c *Console) match(res []*regexp.Regexp) (int, *Match) {
+	best, bestLoc := -1, []int(nil)
+	for i, re := range res {
+		loc := re.FindSubmatchIndex(c.pending)
+		if loc != nil && (bestLoc == nil || loc[0] < bestLoc[0]) {
+			best, bestLoc = i, loc
+		}
+	}
+	if bestLoc == nil {
+		return -1, nil
+	}
+
+	m := &Match{Before: string(c.pending[:bestLoc[0]])}
+	for i := 0; i < len(bestLoc); i += 2 {
+		var group string
+		if bestLoc[i] >= 0 {
+			group = string(c.pending[bestLoc[i]:bestLoc[i+1]])
+		}
+		m.Groups = append(m.Groups, group)
+	}
+	c.pending = append(c.pending[:0], c.pending[bestLoc[1]:]...)
+	return best, m
+}
+
+func patterns(res []*regexp.Regexp) []string {
+	out := make([]string, len(res))
+	for i, re := range res {
+		out[i] = fmt.Sprintf("/%s/", re)
+	}
+	return out
+}
+
+// Send writes s to the console.
+func (c *Console) Send(s string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := io.WriteString(c.w, s)
+	return err
+}
+
+// SendLine writes s followed by the line ending to the console.
+func (c *Console) SendLine(s string) error { return c.Send(s + c.opts.lineEnding) }
+
+// Login logs into a getty on the console and waits for the shell
+// prompt.  An empty password skips the password prompt.
+func (c *Console) Login(user, password string, timeout time.Duration) error {
+	if _, err := c.Expect(LoginPrompt, timeout); err != nil {
+		return err
+	}
+	if err := c.SendLine(user); err != nil {
+		return err
+	}
+
+	if password != "" {
+		if _, err := c.Expect(PasswordPrompt, timeout); err != nil {
+			return err
+		}
+		if err := c.SendLine(password); err != nil {
+			return err
+		}
+	}
+
+	i, _, err := c.ExpectAny(timeout, c.opts.prompt, loginIncorrect)
+	if err != nil {
+		return err
+	}
+	if i == 1 {
+		return ErrLoginFailed
+	}
+	return nil
+}
+
+// New creates a Console on rw, which usually is the connection of a
+// Conduit.
+func New(rw io.ReadWriter, opts ...Opt) *Console {
+	c := &Console{
+		opts: options{
+			lineEnding:     "\n",
+			prompt:         ShellPrompt,
+			transcriptSize: 1 << 20,
+			screenLines:    24,
+		},
+		w:       rw,
+		changed: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	c.closer, _ = rw.(io.Closer)
+
+	go c.read(rw)
+	return c
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpconsole_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpconsole"
+)
+
+// fakeGetty plays a getty followed by a shell that echoes commands.
+func fakeGetty(conn net.Conn, password string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		fmt.Fprint(conn, "\x1b[2J\r\nguest login: ")
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		fmt.Fprint(conn, "Password: ")
+		pw, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(pw) == password {
+			break
+		}
+		fmt.Fprint(conn, "\r\nLogin incorrect\r\n")
+	}
+
+	for {
+		fmt.Fprint(conn, "root@guest:~# ")
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "%s\r\n", strings.ToUpper(strings.TrimSpace(line)))
+	}
+}
+
+func TestConsole_Login(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	go fakeGetty(r, "secret")
+	c := qpconsole.New(l)
+	defer c.Close()
+
+	if !assert.NoError(c.Login("root", "secret", time.Second)) {
+		return
+	}
+
+	assert.NoError(c.SendLine("echo hello"))
+	m, err := c.Expect(regexp.MustCompile(`([A-Z]+) ([A-Z]+)\r\n`), time.Second)
+	if assert.NoError(err) {
+		assert.Equal([]string{"ECHO HELLO\r\n", "ECHO", "HELLO"}, m.Groups)
+	}
+
+	i, _, err := c.ExpectAny(time.Second, regexp.MustCompile(`nope`), qpconsole.ShellPrompt)
+	assert.NoError(err)
+	assert.Equal(1, i)
+
+	assert.Contains(string(c.Transcript()), "guest login: ")
+}
+
+func TestConsole_LoginFailed(t *testing.T) {
+	l, r := net.Pipe()
+	go fakeGetty(r, "secret")
+	c := qpconsole.New(l)
+	defer c.Close()
+
+	assertpkg.ErrorIs(t, c.Login("root", "wrong", time.Second), qpconsole.ErrLoginFailed)
+}
+
+func TestConsole_Timeout(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	go func() {
+		for i := 0; i < 30; i++ {
+			fmt.Fprintf(r, "\x1b[1mline %d\x1b[0m\r\n", i)
+		}
+	}()
+	c := qpconsole.New(l, qpconsole.WithScreenLines(2))
+	defer c.Close()
+
+	_, err := c.ExpectString("kernel panic", 100*time.Millisecond)
+
+	var timeoutErr *qpconsole.TimeoutError
+	if assert.True(errors.As(err, &timeoutErr)) {
+		assert.Equal("line 28\nline 29", timeoutErr.Screen)
+		assert.Equal([]string{"/kernel panic/"}, timeoutErr.Patterns)
+	}
+	assert.EqualError(err, "qpconsole: timed out after 100ms waiting for /kernel panic/, last output:\nline 28\nline 29")
+
+	assert.NoError(r.Close())
+	_, err = c.ExpectString("kernel panic", time.Second)
+	assert.ErrorIs(err, qpconsole.ErrClosed)
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpconsole
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrHubClosed = errors.New("qpconsole: hub closed")
+)
+
+// defaultSubscriberBuffer is the number of bytes buffered for each
+// subscriber by default.
+const defaultSubscriberBuffer = 64 << 10
+
+// Hub shares a single console connection between several consumers.
+// Output of the guest is fanned out to all subscribers and input of
+// all subscribers is merged, without interleaving single writes.
+//
+// Each subscriber has its own bounded buffer.  A subscriber that does
+// not keep up loses the oldest buffered output instead of stalling
+// the guest or the other subscribers.
+type Hub struct {
+	conn io.ReadWriter
+
+	wmu sync.Mutex
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	subs   map[*Subscriber]struct{}
+	owner  *Subscriber
+	err    error
+	doneCh chan struct{}
+}
+
+// Done is closed once the hub stopped reading output.
+func (h *Hub) Done() <-chan struct{} { return h.doneCh }
+
+// Err returns the reason the hub stopped reading output.
+func (h *Hub) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Close closes the underlying connection if it is an io.Closer.
+func (h *Hub) Close() error {
+	if c, ok := h.conn.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (h *Hub) read() {
+	defer close(h.doneCh)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := h.conn.Read(buf)
+
+		h.mu.Lock()
+		for s := range h.subs {
+			s.push(buf[:n])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrHubClosed
+			}
+			h.err = err
+			for s := range h.subs {
+				s.end(io.EOF)
+			}
+			h.cond.Broadcast()
+		}
+		h.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Write sends p to the guest on behalf of no particular subscriber.
+func (h *Hub) Write(p []byte) (int, error) { return h.write(nil, p) }
+
+func (h *Hub) write(s *Subscriber, p []byte) (int, error) {
+	h.mu.Lock()
+	for h.owner != nil && h.owner != s && h.err == nil {
+		h.cond.Wait()
+	}
+	err := h.err
+	h.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	h.wmu.Lock()
+	defer h.wmu.Unlock()
+	return h.conn.Write(p)
+}
+
+type subscribeOptions struct {
+	buffer int
+}
+
+type SubscribeOpt func(o *subscribeOptions)
+
+// SubscribeBuffer sets the number of bytes buffered for the
+// subscriber.
+func SubscribeBuffer(n int) SubscribeOpt {
+	return func(o *subscribeOptions) { o.buffer = n }
+}
+
+// Subscribe adds a subscriber receiving all output from now on.
+func (h *Hub) Subscribe(opts ...SubscribeOpt) *Subscriber {
+	o := subscribeOptions{buffer: defaultSubscriberBuffer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := &Subscriber{h: h, limit: o.buffer}
+	s.cond = sync.NewCond(&s.mu)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		s.err = io.EOF
+	} else {
+		h.subs[s] = struct{}{}
+	}
+	return s
+}
+
+// Tee copies all output to w in the background until the hub or the
+// returned subscriber is closed, e.g. to write a log file.
+func (h *Hub) Tee(w io.Writer, opts ...SubscribeOpt) *Subscriber {
+	s := h.Subscribe(opts...)
+	go func() { _, _ = io.Copy(w, s) }()
+	return s
+}
+
+// Subscriber is a consumer of a Hub.  It reads the output of the
+// guest and writes input to it, like the connection shared by the
+// Hub, so a Console can be run on top of it.
+type Subscriber struct {
+	h *Hub
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	limit   int
+	dropped uint64
+	err     error
+}
+
+func (s *Subscriber) push(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	if over := len(s.buf) + len(p) - s.limit; over > 0 {
+		s.dropped += uint64(over)
+	}
+	s.buf = appendBounded(s.buf, p, s.limit)
+	s.cond.Broadcast()
+}
+
+func (s *Subscriber) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+}
+
+// Read reads buffered output.  It returns io.EOF once the hub or the
+// subscriber was closed and all buffered output was read.
+func (s *Subscriber) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		return 0, s.err
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Write sends p to the guest.  It blocks while another subscriber
+// holds the input lock.
+func (s *Subscriber) Write(p []byte) (int, error) { return s.h.write(s, p) }
+
+// Dropped returns the number of bytes of output lost because the
+// subscriber did not keep up.
+func (s *Subscriber) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// LockInput gives the subscriber exclusive access to the input of the
+// guest, e.g. for an interactive session, until the returned function
+// is called.  Writes of other subscribers block in the meantime.
+func (s *Subscriber) LockInput() (unlock func()) {
+	h := s.h
+
+	h.mu.Lock()
+	for h.owner != nil && h.owner != s && h.err == nil {
+		h.cond.Wait()
+	}
+	h.owner = s
+	h.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if h.owner == s {
+				h.owner = nil
+				h.cond.Broadcast()
+			}
+		})
+	}
+}
+
+// Close unsubscribes from the hub and releases the input lock, if
+// held.
+func (s *Subscriber) Close() error {
+	h := s.h
+
+	h.mu.Lock()
+	delete(h.subs, s)
+	if h.owner == s {
+		h.owner = nil
+		h.cond.Broadcast()
+	}
+	h.mu.Unlock()
+
+	s.end(io.EOF)
+	return nil
+}
+
+// NewHub creates a Hub sharing conn, which usually is the connection
+// of a Conduit.  The hub starts reading output right away, output
+// read before a subscriber subscribed is not delivered to it.
+func NewHub(conn io.ReadWriter) *Hub {
+	h := &Hub{
+		conn:   conn,
+		subs:   map[*Subscriber]struct{}{},
+		doneCh: make(chan struct{}),
+	}
+	h.cond = sync.NewCond(&h.mu)
+
+	go h.read()
+	return h
+}
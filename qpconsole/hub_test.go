@@ -0,0 +1,122 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpconsole_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpconsole"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestHub_FanOut(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	h := qpconsole.NewHub(l)
+
+	var log syncBuffer
+	h.Tee(&log)
+	slow := h.Subscribe(qpconsole.SubscribeBuffer(4))
+	c := qpconsole.New(h.Subscribe())
+
+	go func() {
+		_, _ = io.WriteString(r, "guest login: ")
+		buf := make([]byte, 16)
+		n, _ := r.Read(buf)
+		_, _ = io.WriteString(r, "got "+string(buf[:n]))
+		_ = r.Close()
+	}()
+
+	_, err := c.ExpectString("login: ", time.Second)
+	assert.NoError(err)
+	assert.NoError(c.SendLine("root"))
+	_, err = c.ExpectString("got root\n", time.Second)
+	assert.NoError(err)
+
+	<-h.Done()
+	assert.ErrorIs(h.Err(), qpconsole.ErrHubClosed)
+	assert.Eventually(func() bool { return log.String() == "guest login: got root\n" },
+		time.Second, 10*time.Millisecond)
+
+	// The slow subscriber kept the last 4 bytes only.
+	got, err := io.ReadAll(slow)
+	assert.NoError(err)
+	assert.Equal("oot\n", string(got))
+	assert.Equal(uint64(len("guest login: got root\n")-4), slow.Dropped())
+}
+
+func TestHub_LockInput(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	defer r.Close()
+	h := qpconsole.NewHub(l)
+	defer h.Close()
+
+	var input syncBuffer
+	go func() { _, _ = io.Copy(&input, r) }()
+
+	interactive, other := h.Subscribe(), h.Subscribe()
+	unlock := interactive.LockInput()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		_, _ = io.WriteString(other, "other")
+	}()
+	_, err := io.WriteString(interactive, "mine ")
+	assert.NoError(err)
+
+	select {
+	case <-written:
+		t.Error("write went through while input was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-written
+	assert.Eventually(func() bool { return input.String() == "mine other" },
+		time.Second, 10*time.Millisecond)
+
+	assert.NoError(other.Close())
+	n, err := other.Read(make([]byte, 1))
+	assert.Zero(n)
+	assert.ErrorIs(err, io.EOF)
+}
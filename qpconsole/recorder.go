@@ -0,0 +1,284 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpconsole
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Direction tells whether recorded data was sent to or received from
+// the guest.
+type Direction string
+
+const (
+	// Output is data printed by the guest.
+	Output Direction = "o"
+	// Input is data sent to the guest.
+	Input Direction = "i"
+)
+
+// Event is a chunk of recorded console traffic.
+type Event struct {
+	// Time is the time since the start of the recording, taken
+	// from the monotonic clock.
+	Time time.Duration
+	Dir  Direction
+	Data []byte
+}
+
+type recorderOptions struct {
+	w             io.Writer
+	memoryLimit   int
+	width, height int
+	title         string
+}
+
+type RecorderOpt func(o *recorderOptions)
+
+// RecordTo streams the recording to w in the asciicast v2 format.
+func RecordTo(w io.Writer) RecorderOpt { return func(o *recorderOptions) { o.w = w } }
+
+// RecordInMemory keeps the last limit bytes of traffic in memory, to
+// be retrieved with Events or WriteAsciicast.
+func RecordInMemory(limit int) RecorderOpt {
+	return func(o *recorderOptions) { o.memoryLimit = limit }
+}
+
+// RecordSize sets the terminal size announced in the recording.
+func RecordSize(width, height int) RecorderOpt {
+	return func(o *recorderOptions) { o.width, o.height = width, height }
+}
+
+// RecordTitle sets the title of the recording.
+func RecordTitle(title string) RecorderOpt {
+	return func(o *recorderOptions) { o.title = title }
+}
+
+// Recorder records console traffic in both directions with
+// timestamps, in a format that can be replayed with asciinema.
+//
+// <https://docs.asciinema.org/manual/asciicast/v2/>
+type Recorder struct {
+	opts  recorderOptions
+	start time.Time
+
+	mu     sync.Mutex
+	cast   *castEncoder
+	err    error
+	events []Event
+	size   int
+}
+
+// Err returns the first error writing the stream set with RecordTo.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Record records p as sent in direction dir.
+func (r *Recorder) Record(dir Direction, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	ev := Event{Time: time.Since(r.start), Dir: dir, Data: append([]byte(nil), p...)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cast != nil && r.err == nil {
+		r.err = r.cast.encode(ev)
+	}
+
+	if r.opts.memoryLimit > 0 {
+		r.events = append(r.events, ev)
+		r.size += len(ev.Data)
+		for r.size > r.opts.memoryLimit && len(r.events) > 1 {
+			r.size -= len(r.events[0].Data)
+			r.events = r.events[1:]
+		}
+	}
+}
+
+// Flush writes out data held back from the stream set with RecordTo
+// because it ended in an incomplete character.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cast != nil && r.err == nil {
+		r.err = r.cast.flush()
+	}
+	return r.err
+}
+
+// Events returns the events kept in memory.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// WriteAsciicast writes the events kept in memory to w in the
+// asciicast v2 format.
+func (r *Recorder) WriteAsciicast(w io.Writer) error {
+	enc, err := r.newCastEncoder(w)
+	if err != nil {
+		return err
+	}
+	for _, ev := range r.Events() {
+		if err := enc.encode(ev); err != nil {
+			return err
+		}
+	}
+	return enc.flush()
+}
+
+// TB is the part of testing.TB used by AttachTo.
+type TB interface {
+	Cleanup(func())
+	Failed() bool
+	Logf(format string, args ...any)
+}
+
+// AttachTo logs the recording kept in memory when tb failed.
+func (r *Recorder) AttachTo(tb TB) {
+	tb.Cleanup(func() {
+		if !tb.Failed() {
+			return
+		}
+		var b bytes.Buffer
+		if err := r.WriteAsciicast(&b); err != nil {
+			tb.Logf("console recording: %v", err)
+			return
+		}
+		tb.Logf("console recording (asciicast v2):\n%s", b.String())
+	})
+}
+
+// Conn returns conn with all traffic recorded by r.
+func (r *Recorder) Conn(conn net.Conn) net.Conn { return &recordingConn{Conn: conn, r: r} }
+
+type recordingConn struct {
+	net.Conn
+	r *Recorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.r.Record(Output, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.r.Record(Input, p[:n])
+	return n, err
+}
+
+// castEncoder writes events as asciicast v2.  Event data has to be
+// valid UTF-8, so multi-byte characters split across events are held
+// back until they are complete.
+type castEncoder struct {
+	w       io.Writer
+	partial map[Direction][]byte
+	last    time.Duration
+}
+
+func (r *Recorder) newCastEncoder(w io.Writer) (*castEncoder, error) {
+	header := map[string]any{
+		"version":   2,
+		"width":     r.opts.width,
+		"height":    r.opts.height,
+		"timestamp": r.start.Unix(),
+	}
+	if r.opts.title != "" {
+		header["title"] = r.opts.title
+	}
+	if err := writeJSONLine(w, header); err != nil {
+		return nil, err
+	}
+	return &castEncoder{w: w, partial: map[Direction][]byte{}}, nil
+}
+
+func (e *castEncoder) encode(ev Event) error {
+	data := append(e.partial[ev.Dir], ev.Data...)
+
+	// Hold back an incomplete character at the end.
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	e.partial[ev.Dir] = append([]byte(nil), data[cut:]...)
+	e.last = ev.Time
+
+	if cut == 0 {
+		return nil
+	}
+	return writeJSONLine(e.w, []any{ev.Time.Seconds(), ev.Dir, string(data[:cut])})
+}
+
+// flush writes out data held back, even if incomplete.
+func (e *castEncoder) flush() error {
+	for _, dir := range []Direction{Output, Input} {
+		if data := e.partial[dir]; len(data) > 0 {
+			delete(e.partial, dir)
+			if err := writeJSONLine(e.w, []any{e.last.Seconds(), dir, string(data)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// NewRecorder creates a Recorder.  Without RecordTo or RecordInMemory
+// it records nothing.
+func NewRecorder(opts ...RecorderOpt) (*Recorder, error) {
+	r := &Recorder{
+		opts:  recorderOptions{width: 80, height: 24},
+		start: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+
+	if r.opts.w != nil {
+		var err error
+		if r.cast, err = r.newCastEncoder(r.opts.w); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
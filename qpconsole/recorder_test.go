@@ -0,0 +1,98 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpconsole_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpconsole"
+)
+
+func readCast(t *testing.T, b []byte) (header map[string]any, events [][]any) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if header == nil {
+			assertpkg.NoError(t, json.Unmarshal(s.Bytes(), &header))
+			continue
+		}
+		var ev []any
+		assertpkg.NoError(t, json.Unmarshal(s.Bytes(), &ev))
+		events = append(events, ev)
+	}
+	return
+}
+
+func TestRecorder_Conn(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	var stream bytes.Buffer
+	rec, err := qpconsole.NewRecorder(
+		qpconsole.RecordTo(&stream),
+		qpconsole.RecordInMemory(8),
+		qpconsole.RecordTitle("guest"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	l, r := net.Pipe()
+	defer l.Close()
+	conn := rec.Conn(l)
+
+	go func() {
+		// "ü" split in the middle.
+		_, _ = r.Write([]byte("hello \xc3"))
+		_, _ = r.Write([]byte("\xbc"))
+		buf := make([]byte, 2)
+		_, _ = r.Read(buf)
+	}()
+
+	buf := make([]byte, 16)
+	for _, want := range []string{"hello \xc3", "\xbc"} {
+		n, err := conn.Read(buf)
+		assert.NoError(err)
+		assert.Equal(want, string(buf[:n]))
+	}
+	_, err = conn.Write([]byte("ls"))
+	assert.NoError(err)
+	assert.NoError(rec.Flush())
+
+	header, events := readCast(t, stream.Bytes())
+	assert.Equal(float64(2), header["version"])
+	assert.Equal("guest", header["title"])
+	if assert.Len(events, 3) {
+		assert.Equal([]any{"o", "hello "}, events[0][1:])
+		assert.Equal([]any{"o", "ü"}, events[1][1:])
+		assert.Equal([]any{"i", "ls"}, events[2][1:])
+		assert.LessOrEqual(events[0][0], events[2][0])
+	}
+
+	// The in-memory recording only keeps the last 8 bytes.
+	got := rec.Events()
+	if assert.Len(got, 2) {
+		assert.Equal(qpconsole.Output, got[0].Dir)
+		assert.Equal(qpconsole.Input, got[1].Dir)
+	}
+
+	var dump bytes.Buffer
+	assert.NoError(rec.WriteAsciicast(&dump))
+	_, events = readCast(t, dump.Bytes())
+	assert.Len(events, 2)
+}
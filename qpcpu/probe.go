@@ -0,0 +1,213 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpcpu probes which CPU models and features QEMU can provide
+// on the host, to pin a CPU model that behaves the same on every host
+// and to check a host can run it before launching a VM.
+package qpcpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+// ErrUnavailable is returned by Report.Err when the host cannot
+// provide all features of a model.
+var ErrUnavailable = errors.New("qpcpu: CPU features unavailable")
+
+// quitTimeout limits how long to wait for the probing QEMU to exit
+// before killing it.
+const quitTimeout = 5 * time.Second
+
+// Report is what Probe learned about a CPU on the host.
+type Report struct {
+	// CPU is the probed CPU.
+	CPU qpdevices.CPU
+
+	// Expansion is the static expansion of CPU, a base model that
+	// is the same in all QEMU versions plus the properties that
+	// differ from it.
+	Expansion *qpqmp.CPUModelInfo
+
+	// Unavailable lists the features of CPU the host cannot
+	// provide with the accelerator.
+	Unavailable []string
+}
+
+// Err returns an error wrapping ErrUnavailable if the host cannot
+// provide all features of the CPU.
+func (r *Report) Err() error {
+	if len(r.Unavailable) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w for %s: %s", ErrUnavailable, r.CPU.Model, strings.Join(r.Unavailable, ", "))
+}
+
+// Baseline returns a CPU pinning the static expansion, which has the
+// same features on every host that can provide it, whatever the host
+// CPU is.
+func (r *Report) Baseline() qpdevices.CPU {
+	cpu := qpdevices.CPU{Model: r.Expansion.Name}
+	for k, v := range r.Expansion.Props {
+		switch v {
+		case true:
+			cpu.Enable = append(cpu.Enable, k)
+		case false:
+			cpu.Disable = append(cpu.Disable, k)
+		default:
+			if cpu.Properties == nil {
+				cpu.Properties = map[string]any{}
+			}
+			cpu.Properties[k] = v
+		}
+	}
+	sort.Strings(cpu.Enable)
+	sort.Strings(cpu.Disable)
+	return cpu
+}
+
+type probeOpts struct {
+	accels   []string
+	yeetOpts []libqatapult.YeetOption
+}
+
+type Option func(o *probeOpts)
+
+// WithAccelerators sets the accelerators QEMU probes with, the first
+// available one is used.  The default is kvm, as CPU features depend
+// on the accelerator.
+func WithAccelerators(accels ...string) Option {
+	return func(o *probeOpts) { o.accels = accels }
+}
+
+// WithYeetOptions passes options to the Yeet starting QEMU.
+func WithYeetOptions(opts ...libqatapult.YeetOption) Option {
+	return func(o *probeOpts) { o.yeetOpts = append(o.yeetOpts, opts...) }
+}
+
+// modelInfo returns the properties of cpu as QMP expects them.
+func modelInfo(cpu qpdevices.CPU) qpqmp.CPUModelInfo {
+	info := qpqmp.CPUModelInfo{Name: cpu.Model, Props: map[string]any{}}
+	for k, v := range cpu.Properties {
+		info.Props[k] = v
+	}
+	for _, f := range cpu.Enable {
+		info.Props[f] = true
+	}
+	for _, f := range cpu.Disable {
+		info.Props[f] = false
+	}
+	if cpu.Migratable.IsSome() {
+		info.Props["migratable"] = cpu.Migratable.Yank()
+	}
+	return info
+}
+
+// Probe starts QEMU as described by conf without a guest, using the
+// emulator only, and checks which features of cpu the host provides.
+// The error is nil even if features are unavailable, see Report.Err.
+func Probe(ctx context.Context, conf *libqatapult.Config, cpu qpdevices.CPU, opts ...Option) (*Report, error) {
+	cfg := probeOpts{accels: []string{"kvm"}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	c := *conf
+	c.Devices = libqatapult.NewDeviceGroup(qpdevices.Machine{Type: "none", Accelerators: cfg.accels})
+	yeetOpts := append([]libqatapult.YeetOption{libqatapult.YeetWithMonitor()}, cfg.yeetOpts...)
+	vm, err := libqatapult.Yeet(ctx, &c, yeetOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer quit(vm)
+
+	mon := vm.Monitor()
+	if mon == nil {
+		return nil, errors.New("qpcpu: probing needs the QMP monitor")
+	}
+	return probe(ctx, mon, cpu)
+}
+
+// quit asks QEMU to exit and kills it if it has no monitor or does
+// not exit within quitTimeout.
+func quit(vm *libqatapult.VM) {
+	ctx, cancel := context.WithTimeout(context.Background(), quitTimeout)
+	defer cancel()
+
+	if mon := vm.Monitor(); mon != nil {
+		_ = mon.Execute(ctx, "quit", nil, nil)
+	} else {
+		_ = vm.Kill()
+	}
+	select {
+	case <-vm.Done():
+	case <-ctx.Done():
+		_ = vm.Kill()
+		<-vm.Done()
+	}
+}
+
+func probe(ctx context.Context, mon *qpqmp.Client, cpu qpdevices.CPU) (*Report, error) {
+	r := &Report{CPU: cpu}
+
+	if cpu.Model != qpdevices.CPUHost && cpu.Model != qpdevices.CPUMax {
+		defs, err := mon.QueryCPUDefinitions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var def *qpqmp.CPUDefinition
+		for i := range defs {
+			if defs[i].Name == cpu.Model {
+				def = &defs[i]
+			}
+		}
+		if def == nil {
+			return nil, fmt.Errorf("qpcpu: unknown CPU model %s", cpu.Model)
+		}
+		r.Unavailable = append(r.Unavailable, def.UnavailableFeatures...)
+	}
+
+	// The max model has every feature the accelerator provides.
+	max, err := mon.QueryCPUModelExpansion(ctx, qpqmp.CPUModelExpansionFull, qpqmp.CPUModelInfo{Name: qpdevices.CPUMax})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range cpu.Enable {
+		if max.Props[f] != true && !contains(r.Unavailable, f) {
+			r.Unavailable = append(r.Unavailable, f)
+		}
+	}
+
+	if r.Expansion, err = mon.QueryCPUModelExpansion(ctx, qpqmp.CPUModelExpansionStatic, modelInfo(cpu)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
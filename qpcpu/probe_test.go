@@ -0,0 +1,169 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpcpu_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpcpu"
+	"github.com/qatapult/libqatapult/qpdevices"
+)
+
+const fakeQEMUEnv = "QATAPULT_FAKE_QEMU"
+
+// fakeConfig returns a Config that runs the test binary as a QEMU
+// knowing two x86 CPU models.
+func fakeConfig() *libqatapult.Config {
+	return &libqatapult.Config{
+		Emulator:    []string{os.Args[0], "-test.run=^TestHelperQEMU$", "--"},
+		Environment: append(os.Environ(), fakeQEMUEnv+"=1"),
+		DontUseEnv:  true,
+	}
+}
+
+func TestHelperQEMU(t *testing.T) {
+	if os.Getenv(fakeQEMUEnv) == "" {
+		return
+	}
+	// Never outlive a broken test for long.
+	time.AfterFunc(30*time.Second, func() { os.Exit(2) })
+
+	var fd int
+	for i, arg := range os.Args {
+		if arg == "-machine" && os.Args[i+1] != "type=none,accel=kvm" {
+			fmt.Fprintln(os.Stderr, "qemu-system-x86_64: unexpected -machine "+os.Args[i+1])
+			os.Exit(1)
+		}
+		if _, rest, found := strings.Cut(arg, "id="+libqatapult.MonitorName+",fd="); found {
+			fd, _ = strconv.Atoi(rest)
+		}
+	}
+	conn, err := net.FileConn(os.NewFile(uintptr(fd), "monitor"))
+	if err != nil {
+		os.Exit(1)
+	}
+	fakeServe(conn)
+	os.Exit(0)
+}
+
+var fakeReplies = map[string]string{
+	"query-cpu-definitions": `[
+		{"name": "Skylake-Client", "typename": "Skylake-Client-x86_64-cpu", "static": false,
+		 "migration-safe": true, "unavailable-features": [], "deprecated": false},
+		{"name": "Icelake-Server", "typename": "Icelake-Server-x86_64-cpu", "static": false,
+		 "migration-safe": true, "unavailable-features": ["avx512vbmi"], "deprecated": false}]`,
+	"max": `{"model": {"name": "max", "props": {"vmx": true, "avx2": true, "avx512f": false}}}`,
+	"static": `{"model": {"name": "base", "props": {"avx2": true, "vmx": true, "svm": false, "family": 6,
+		"model-id": "Intel Core Processor (Skylake)"}}}`,
+}
+
+func fakeServe(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprintln(conn, `{"QMP": {"version": {"qemu": {"micro": 0, "minor": 2, "major": 8}, "package": ""}, "capabilities": []}}`)
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		var req struct {
+			Execute   string `json:"execute"`
+			Arguments struct {
+				Type  string `json:"type"`
+				Model struct {
+					Name string `json:"name"`
+				} `json:"model"`
+			} `json:"arguments"`
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(s.Bytes(), &req) != nil {
+			continue
+		}
+
+		reply := "{}"
+		switch req.Execute {
+		case "query-cpu-definitions":
+			reply = fakeReplies[req.Execute]
+		case "query-cpu-model-expansion":
+			if req.Arguments.Type == "full" {
+				reply = fakeReplies[req.Arguments.Model.Name]
+			} else {
+				reply = fakeReplies[req.Arguments.Type]
+			}
+		}
+		fmt.Fprintf(conn, `{"return": %s, "id": %q}`+"\n", reply, req.ID)
+		if req.Execute == "quit" {
+			return
+		}
+	}
+}
+
+func probe(t *testing.T, cpu qpdevices.CPU) (*qpcpu.Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return qpcpu.Probe(ctx, fakeConfig(), cpu,
+		qpcpu.WithYeetOptions(libqatapult.YeetWithRuntimeRoot(t.TempDir())))
+}
+
+func TestProbe(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	r, err := probe(t, qpdevices.CPU{Model: "Skylake-Client", Enable: []string{"vmx"}})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(r.Err())
+	assert.Equal("base", r.Expansion.Name)
+	assert.Equal(qpdevices.CPU{
+		Model:   "base",
+		Enable:  []string{"avx2", "vmx"},
+		Disable: []string{"svm"},
+		Properties: map[string]any{
+			"family":   float64(6),
+			"model-id": "Intel Core Processor (Skylake)",
+		},
+	}, r.Baseline())
+
+	args, err := r.Baseline().GetCliArgs()
+	if assert.NoError(err) {
+		assert.Equal([]string{"-cpu", "base,avx2=on,vmx=on,svm=off,family=6,model-id=Intel Core Processor (Skylake)"}, args)
+	}
+}
+
+func TestProbe_Unavailable(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	r, err := probe(t, qpdevices.CPU{Model: "Icelake-Server", Enable: []string{"avx512f", "avx2"}})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"avx512vbmi", "avx512f"}, r.Unavailable)
+	assert.ErrorIs(r.Err(), qpcpu.ErrUnavailable)
+	assert.ErrorContains(r.Err(), "Icelake-Server: avx512vbmi, avx512f")
+}
+
+func TestProbe_UnknownModel(t *testing.T) {
+	_, err := probe(t, qpdevices.CPU{Model: "Pentium-Pro-Max"})
+	assertpkg.ErrorContains(t, err, "unknown CPU model Pentium-Pro-Max")
+}
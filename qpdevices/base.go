@@ -18,6 +18,11 @@ type DeviceType struct{ slug string }
 
 func (t DeviceType) String() string { return t.slug }
 
+// NewDeviceType returns the DeviceType of the driver named slug.
+func NewDeviceType(slug string) DeviceType {
+	return DeviceType{slug: slug}
+}
+
 func NewStorageDeviceType(slug string) DeviceType {
 	return DeviceType{slug: slug}
 }
@@ -25,10 +25,48 @@ type (
 		Type string                `qp:"~unnamed"`
 		Name string                `qp:"name='id'"`
 		Mux  qpoption.Option[bool] `qp:""`
+
+		// LogFile is a file all data received from the backend is
+		// logged to, LogAppend appends to it instead of truncating.
+		LogFile   string                `qp:"name=logfile"`
+		LogAppend qpoption.Option[bool] `qp:"name=logappend"`
 	}
 
 	NullCharDevice struct{ CharDevice }
 
+	// PtyCharDevice allocates a pseudo terminal on the host, its path
+	// is reported by the query-chardev QMP command.
+	PtyCharDevice struct{ CharDevice }
+
+	// StdioCharDevice connects to the standard input and output of
+	// QEMU.  Signal controls whether ^C on stdin terminates QEMU.
+	StdioCharDevice struct {
+		CharDevice
+		Signal qpoption.Option[bool]
+	}
+
+	// RingbufCharDevice buffers data sent by the guest in memory, to
+	// be read with the ringbuf-read QMP command.
+	RingbufCharDevice struct {
+		CharDevice
+
+		// Size is the size of the ring buffer in bytes, which has to
+		// be a power of two.
+		Size qpoption.Option[uint64]
+	}
+
+	// MemoryCharDevice is the legacy name of RingbufCharDevice.
+	MemoryCharDevice struct{ RingbufCharDevice }
+
+	// VCCharDevice is a virtual console of the QEMU user interface.
+	VCCharDevice struct {
+		CharDevice
+		Width  qpoption.Option[uint32]
+		Height qpoption.Option[uint32]
+		Cols   qpoption.Option[uint32]
+		Rows   qpoption.Option[uint32]
+	}
+
 	PathCharDevice struct {
 		CharDevice
 		Path string
@@ -46,6 +84,31 @@ func (d NullCharDevice) GetCliArgs() ([]string, error) {
 	return serializer.GetCliArgs(d)
 }
 
+func (d PtyCharDevice) GetCliArgs() ([]string, error) {
+	d.Type = "pty"
+	return serializer.GetCliArgs(d)
+}
+
+func (d StdioCharDevice) GetCliArgs() ([]string, error) {
+	d.Type = "stdio"
+	return serializer.GetCliArgs(d)
+}
+
+func (d RingbufCharDevice) GetCliArgs() ([]string, error) {
+	d.Type = "ringbuf"
+	return serializer.GetCliArgs(d)
+}
+
+func (d MemoryCharDevice) GetCliArgs() ([]string, error) {
+	d.Type = "memory"
+	return serializer.GetCliArgs(d)
+}
+
+func (d VCCharDevice) GetCliArgs() ([]string, error) {
+	d.Type = "vc"
+	return serializer.GetCliArgs(d)
+}
+
 func (d FileCharDevice) GetCliArgs() ([]string, error) {
 	d.Type = "file"
 	return serializer.GetCliArgs(d)
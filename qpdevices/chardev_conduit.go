@@ -59,3 +59,46 @@ func NewConduit(name string) (c *Conduit, err error) {
 
 	return
 }
+
+// PortConduit is a Conduit exposed to the guest as a virtio-serial
+// port, for host services talking to an agent in the guest.
+type PortConduit struct {
+	*Conduit
+	bus *VirtIOSerialBus // nil if the bus is shared
+}
+
+func (p *PortConduit) GetCliArgs() ([]string, error) {
+	args, err := p.Conduit.GetCliArgs()
+	if err != nil || p.bus == nil {
+		return args, err
+	}
+
+	busArgs, err := p.bus.GetCliArgs()
+	if err != nil {
+		return nil, err
+	}
+	return append(args, busArgs...), nil
+}
+
+// NewPortConduit creates a Conduit called name with a virtio-serial
+// port called portName on a controller of its own.
+func NewPortConduit(name, portName string) (*PortConduit, error) {
+	bus := NewVirtIOSerialBus(name + "-serial")
+	p, err := NewPortConduitOn(bus, name, portName)
+	if err != nil {
+		return nil, err
+	}
+	p.bus = bus
+	return p, nil
+}
+
+// NewPortConduitOn creates a Conduit called name with a port called
+// portName on bus.  The bus has to be added to the VM separately.
+func NewPortConduitOn(bus *VirtIOSerialBus, name, portName string) (*PortConduit, error) {
+	c, err := NewConduit(name)
+	if err != nil {
+		return nil, err
+	}
+	bus.AddPort(Ref(c), portName)
+	return &PortConduit{Conduit: c}, nil
+}
@@ -37,3 +37,32 @@ func TestConduit_GetCliArgs(t *testing.T) {
 	}
 	assert.Equal([]string{"-chardev", "socket,id=cond0,fd=3"}, got)
 }
+
+func TestPortConduit_GetCliArgs(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	p, err := qpdevices.NewPortConduit("cond0", "org.example.port")
+	if !assert.NoError(err) {
+		return
+	}
+	got, err := qptest.DeviceCliArgs(p)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{
+		"-chardev", "socket,id=cond0,fd=3",
+		"-device", "virtio-serial-pci,id=cond0-serial0",
+		"-device", "virtserialport,bus=cond0-serial0.0,nr=1,chardev=cond0,name=org.example.port",
+	}, got)
+
+	bus := qpdevices.NewVirtIOSerialBus("vser")
+	p, err = qpdevices.NewPortConduitOn(bus, "cond1", "org.example.port")
+	if !assert.NoError(err) {
+		return
+	}
+	got, err = qptest.DeviceCliArgs(p)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"-chardev", "socket,id=cond1,fd=3"}, got)
+}
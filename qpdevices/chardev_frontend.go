@@ -0,0 +1,230 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"fmt"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+var (
+	VirtIOSerialPCIType = DeviceType{"virtio-serial-pci"}
+	VirtSerialPortType  = DeviceType{"virtserialport"}
+	VirtConsoleType     = DeviceType{"virtconsole"}
+	ISASerialType       = DeviceType{"isa-serial"}
+)
+
+// VirtIOSerialDevice is a virtio-serial controller, which provides
+// the bus for VirtSerialPortDevice and VirtConsoleDevice ports.
+type VirtIOSerialDevice struct {
+	BaseDevice
+
+	// MaxPorts is the number of ports on the bus, 31 by default.
+	MaxPorts qpoption.Option[uint32] `qp:"name=max_ports"`
+}
+
+func (d VirtIOSerialDevice) GetName() string { return d.Name }
+
+// BusName returns the name of the bus provided by the controller.
+func (d VirtIOSerialDevice) BusName() string { return d.Name + ".0" }
+
+func (d VirtIOSerialDevice) GetCliArgs() ([]string, error) {
+	d.Type = VirtIOSerialPCIType
+	return serializer.GetCliArgs(d)
+}
+
+// VirtSerialPortDevice is a port on a virtio-serial bus, connected
+// to a CharDevice on the host.
+type VirtSerialPortDevice struct {
+	BaseDevice
+
+	// Bus is the virtio-serial bus of the port.
+	Bus string
+
+	// Nr is the number of the port on the bus.
+	Nr qpoption.Option[uint32]
+
+	CharDev Reference
+
+	// PortName is the name the guest finds the port under, e.g. in
+	// /dev/virtio-ports.
+	PortName string `qp:"name=name"`
+}
+
+func (d VirtSerialPortDevice) GetName() string { return d.Name }
+
+func (d VirtSerialPortDevice) GetCliArgs() ([]string, error) {
+	d.Type = VirtSerialPortType
+	return serializer.GetCliArgs(d)
+}
+
+// VirtConsoleDevice is a port on a virtio-serial bus the guest uses
+// as a console, e.g. /dev/hvc0.
+type VirtConsoleDevice struct {
+	VirtSerialPortDevice
+}
+
+func (d VirtConsoleDevice) GetCliArgs() ([]string, error) {
+	d.Type = VirtConsoleType
+	return serializer.GetCliArgs(d)
+}
+
+// ISASerialDevice is a 16550A UART on the ISA bus, the classic
+// serial port, e.g. ttyS0.
+type ISASerialDevice struct {
+	BaseDevice
+
+	// Index selects the I/O port and IRQ of the UART from the
+	// standard COM1 to COM4 assignments.
+	Index qpoption.Option[uint32]
+
+	CharDev Reference
+}
+
+func (d ISASerialDevice) GetName() string { return d.Name }
+
+func (d ISASerialDevice) GetCliArgs() ([]string, error) {
+	d.Type = ISASerialType
+	return serializer.GetCliArgs(d)
+}
+
+// virtioSerialPorts is the default number of ports of a controller.
+const virtioSerialPorts = 31
+
+// VirtIOSerialBus allocates ports on virtio-serial controllers, which
+// are created on demand.  Port number 0 of every controller is
+// reserved for a console.
+type VirtIOSerialBus struct {
+	name        string
+	controllers []*virtioSerialController
+	ports       []libqatapult.Device
+}
+
+type virtioSerialController struct {
+	VirtIOSerialDevice
+	used [virtioSerialPorts]bool
+}
+
+// take allocates a port number, preferring 0 for consoles.
+func (c *virtioSerialController) take(console bool) (uint32, bool) {
+	if console && !c.used[0] {
+		c.used[0] = true
+		return 0, true
+	}
+	for nr := 1; nr < len(c.used); nr++ {
+		if !c.used[nr] {
+			c.used[nr] = true
+			return uint32(nr), true
+		}
+	}
+	return 0, false
+}
+
+func (b *VirtIOSerialBus) allocate(console bool) (bus string, nr uint32) {
+	for _, c := range b.controllers {
+		if nr, ok := c.take(console); ok {
+			return c.BusName(), nr
+		}
+	}
+
+	c := &virtioSerialController{VirtIOSerialDevice: VirtIOSerialDevice{
+		BaseDevice: BaseDevice{Name: fmt.Sprintf("%s%d", b.name, len(b.controllers))},
+	}}
+	b.controllers = append(b.controllers, c)
+	nr, _ = c.take(console)
+	return c.BusName(), nr
+}
+
+// AddPort adds a port named portName connected to chardev.
+func (b *VirtIOSerialBus) AddPort(chardev Reference, portName string) *VirtSerialPortDevice {
+	bus, nr := b.allocate(false)
+	d := &VirtSerialPortDevice{
+		Bus:      bus,
+		Nr:       qpoption.Value(nr),
+		CharDev:  chardev,
+		PortName: portName,
+	}
+	b.ports = append(b.ports, d)
+	return d
+}
+
+// AddConsole adds a console connected to chardev.
+func (b *VirtIOSerialBus) AddConsole(chardev Reference) *VirtConsoleDevice {
+	bus, nr := b.allocate(true)
+	d := &VirtConsoleDevice{VirtSerialPortDevice{
+		Bus:     bus,
+		Nr:      qpoption.Value(nr),
+		CharDev: chardev,
+	}}
+	b.ports = append(b.ports, d)
+	return d
+}
+
+func (b *VirtIOSerialBus) GetCliArgs() (out []string, err error) {
+	for _, c := range b.controllers {
+		args, err := c.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	for _, p := range b.ports {
+		args, err := p.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	return out, nil
+}
+
+// NewVirtIOSerialBus creates a VirtIOSerialBus whose controllers are
+// named after name.
+func NewVirtIOSerialBus(name string) *VirtIOSerialBus {
+	return &VirtIOSerialBus{name: name}
+}
+
+// isaSerialPorts is the number of standard ISA serial ports.
+const isaSerialPorts = 4
+
+// ISASerialBus numbers ISA serial ports in the order they are added.
+type ISASerialBus struct {
+	ports []*ISASerialDevice
+}
+
+// AddPort adds a serial port connected to chardev.
+func (b *ISASerialBus) AddPort(chardev Reference) (*ISASerialDevice, error) {
+	if len(b.ports) == isaSerialPorts {
+		return nil, fmt.Errorf("qpdevices.ISASerialBus: all %d ports in use", isaSerialPorts)
+	}
+
+	d := &ISASerialDevice{Index: qpoption.Value(uint32(len(b.ports))), CharDev: chardev}
+	b.ports = append(b.ports, d)
+	return d, nil
+}
+
+func (b *ISASerialBus) GetCliArgs() (out []string, err error) {
+	for _, p := range b.ports {
+		args, err := p.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestFrontendDevices(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  libqatapult.Device
+		want []string
+	}{
+		{"virtio-serial", qpdevices.VirtIOSerialDevice{
+			BaseDevice: qpdevices.BaseDevice{Name: "ser0"},
+			MaxPorts:   qpoption.Value[uint32](4),
+		}, []string{"-device", "virtio-serial-pci,id=ser0,max_ports=4"}},
+
+		{"virtserialport", qpdevices.VirtSerialPortDevice{
+			Bus:      "ser0.0",
+			Nr:       qpoption.Value[uint32](1),
+			CharDev:  "qga0",
+			PortName: "org.qemu.guest_agent.0",
+		}, []string{"-device", "virtserialport,bus=ser0.0,nr=1,chardev=qga0,name=org.qemu.guest_agent.0"}},
+
+		{"virtconsole", qpdevices.VirtConsoleDevice{qpdevices.VirtSerialPortDevice{
+			CharDev: "con0",
+		}}, []string{"-device", "virtconsole,chardev=con0"}},
+
+		{"isa-serial", qpdevices.ISASerialDevice{
+			Index:   qpoption.Value[uint32](0),
+			CharDev: "ttyS0",
+		}, []string{"-device", "isa-serial,index=0,chardev=ttyS0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qptest.DeviceCliArgs(tt.dev)
+			if assertpkg.NoError(t, err) {
+				assertpkg.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVirtIOSerialBus(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	b := qpdevices.NewVirtIOSerialBus("ser")
+	b.AddPort("qga0", "org.qemu.guest_agent.0")
+	b.AddConsole("con0")
+	b.AddConsole("con1")
+	for i := 0; i < 28; i++ {
+		b.AddPort("p", "")
+	}
+	last := b.AddPort("last", "last")
+	assert.Equal("ser1.0", last.Bus)
+	assert.Equal(uint32(1), last.Nr.Yank())
+
+	got, err := qptest.DeviceCliArgs(b)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"-device", "virtio-serial-pci,id=ser0", "-device", "virtio-serial-pci,id=ser1"}, got[:4])
+	assert.Equal([]string{
+		"-device", "virtserialport,bus=ser0.0,nr=1,chardev=qga0,name=org.qemu.guest_agent.0",
+		"-device", "virtconsole,bus=ser0.0,nr=0,chardev=con0",
+		"-device", "virtconsole,bus=ser0.0,nr=2,chardev=con1",
+	}, got[4:10])
+}
+
+func TestISASerialBus(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	var b qpdevices.ISASerialBus
+	for i := 0; i < 4; i++ {
+		_, err := b.AddPort("tty")
+		assert.NoError(err)
+	}
+	_, err := b.AddPort("tty")
+	assert.Error(err)
+
+	got, err := qptest.DeviceCliArgs(&b)
+	if assert.NoError(err) {
+		assert.Equal("isa-serial,index=3,chardev=tty", got[7])
+	}
+}
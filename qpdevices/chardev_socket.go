@@ -16,12 +16,20 @@ package qpdevices
 
 import (
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/qatapult/libqatapult/internal/serializer"
 	"github.com/qatapult/libqatapult/qpoption"
 )
 
+// Seconds is a duration QEMU takes in whole seconds.
+type Seconds time.Duration
+
+func (s Seconds) String() string {
+	return strconv.FormatInt(int64(time.Duration(s)/time.Second), 10)
+}
+
 type (
 	InetSocket struct {
 		CharDevice
@@ -33,13 +41,17 @@ type (
 	}
 
 	SocketCharDevice struct {
-		Server       qpoption.Option[bool]          `qp:""`
-		Wait         qpoption.Option[bool]          `qp:""`
-		UseTelnet    qpoption.Option[bool]          `qp:"name=telnet"`
-		UseWebsocket qpoption.Option[bool]          `qp:"name=websocket"`
-		Reconnect    qpoption.Option[time.Duration] `qp:""`
-
-		// TODO 2022-05-20 @ags: Add TLS options.
+		Server       qpoption.Option[bool]    `qp:""`
+		Wait         qpoption.Option[bool]    `qp:""`
+		UseTelnet    qpoption.Option[bool]    `qp:"name=telnet"`
+		UseWebsocket qpoption.Option[bool]    `qp:"name=websocket"`
+		Reconnect    qpoption.Option[Seconds] `qp:""`
+
+		// TLSCreds names a TLSCredsX509Object or TLSCredsPSKObject
+		// to encrypt the connection with, TLSAuthz an object
+		// authorizing the clients of a server.
+		TLSCreds Reference `qp:"name=tls-creds"`
+		TLSAuthz Reference `qp:"name=tls-authz"`
 	}
 
 	FDSocketCharDevice struct {
@@ -54,7 +66,7 @@ type (
 		SocketCharDevice
 
 		ToPort  qpoption.Option[uint16] `qp:"name=to"`
-		NoDelay qpoption.Option[bool]   `qp:"name=ipv6"`
+		NoDelay qpoption.Option[bool]   `qp:""`
 	}
 
 	UnixSocketCharDevice struct {
@@ -62,8 +74,8 @@ type (
 		SocketCharDevice
 
 		Path     string                `qp:""`
-		Abstract qpoption.Option[bool] `qp:"name=ipv4"`
-		Tight    qpoption.Option[bool] `qp:"name=ipv6"`
+		Abstract qpoption.Option[bool] `qp:""`
+		Tight    qpoption.Option[bool] `qp:""`
 	}
 
 	UDPSocketCharDevice struct {
@@ -85,7 +97,7 @@ func (d TCPSocketCharDevice) GetCliArgs() ([]string, error) {
 }
 
 func (d UDPSocketCharDevice) GetCliArgs() ([]string, error) {
-	d.Type = "socket"
+	d.Type = "udp"
 	return serializer.GetCliArgs(d)
 }
 
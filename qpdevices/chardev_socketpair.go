@@ -15,6 +15,7 @@
 package qpdevices
 
 import (
+	"net"
 	"os"
 
 	"go.uber.org/multierr"
@@ -40,6 +41,10 @@ func (d *SocketPairDevice) Close() error {
 func (d *SocketPairDevice) LocalFile() *os.File { return d.myFile }
 func (d *SocketPairDevice) GetName() string     { return d.name }
 
+// Conn returns a net.Conn on a duplicate of the local side of the
+// socket pair, e.g. to record traffic with a qpconsole.Recorder.
+func (d *SocketPairDevice) Conn() (net.Conn, error) { return net.FileConn(d.myFile) }
+
 func (d *SocketPairDevice) GetCliArgs() ([]string, error) {
 	return FDSocketCharDevice{
 		CharDevice: CharDevice{Name: d.name},
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestCharDevices(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  libqatapult.Device
+		want []string
+	}{
+		{"pty", qpdevices.PtyCharDevice{qpdevices.CharDevice{
+			Name: "pty0",
+		}}, []string{"-chardev", "pty,id=pty0"}},
+
+		{"stdio", qpdevices.StdioCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "con", Mux: qpoption.Value(true)},
+			Signal:     qpoption.Value(false),
+		}, []string{"-chardev", "stdio,id=con,mux=on,signal=off"}},
+
+		{"ringbuf", qpdevices.RingbufCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "rb0"},
+			Size:       qpoption.Value[uint64](65536),
+		}, []string{"-chardev", "ringbuf,id=rb0,size=65536"}},
+
+		{"memory", qpdevices.MemoryCharDevice{qpdevices.RingbufCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "mem0"},
+		}}, []string{"-chardev", "memory,id=mem0"}},
+
+		{"vc", qpdevices.VCCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "vc0"},
+			Cols:       qpoption.Value[uint32](80),
+			Rows:       qpoption.Value[uint32](25),
+		}, []string{"-chardev", "vc,id=vc0,cols=80,rows=25"}},
+
+		{"logfile", qpdevices.NullCharDevice{qpdevices.CharDevice{
+			Name:      "null0",
+			LogFile:   "/tmp/null0.log",
+			LogAppend: qpoption.Value(true),
+		}}, []string{"-chardev", "null,id=null0,logfile=/tmp/null0.log,logappend=on"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qptest.DeviceCliArgs(tt.dev)
+			if assertpkg.NoError(t, err) {
+				assertpkg.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
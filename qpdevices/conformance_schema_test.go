@@ -0,0 +1,437 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+)
+
+// qmpEntry is an entry of the query-qmp-schema output.  QEMU masks
+// the names of all types but builtins, so types are only reached
+// through commands.
+type qmpEntry struct {
+	Name     string      `json:"name"`
+	MetaType string      `json:"meta-type"`
+	ArgType  string      `json:"arg-type"`
+	Members  []qmpMember `json:"members"`
+	Tag      string      `json:"tag"`
+	Variants []struct {
+		Case string `json:"case"`
+		Type string `json:"type"`
+	} `json:"variants"`
+	Values []string `json:"values"`
+}
+
+type qmpMember struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type qmpSchema map[string]*qmpEntry
+
+func (s qmpSchema) lookup(name string) (*qmpEntry, error) {
+	e, found := s[name]
+	if !found {
+		return nil, fmt.Errorf("schema: unknown type %q", name)
+	}
+	return e, nil
+}
+
+// unwrap returns the type wrapped by the single data member of the
+// wrapper objects QEMU generates for simple union branches.
+func (s qmpSchema) unwrap(e *qmpEntry) (*qmpEntry, error) {
+	if len(e.Members) == 1 && e.Members[0].Name == "data" && e.Tag == "" {
+		return s.lookup(e.Members[0].Type)
+	}
+	return e, nil
+}
+
+// qmpCommands maps option groups to the QMP command taking the same
+// properties, and the member of its arguments holding the union of
+// types if it is not the arguments themselves.
+var qmpCommands = map[string]struct{ command, member string }{
+	"chardev":  {"chardev-add", "backend"},
+	"object":   {"object-add", ""},
+	"blockdev": {"blockdev-add", ""},
+	"netdev":   {"netdev_add", ""},
+	"numa":     {"set-numa-node", ""},
+}
+
+// qmpLifted lists the SocketAddressLegacy members of chardev backends
+// whose members -chardev takes at the top level.  Members of other
+// nested unions keep their name as prefix.
+var qmpLifted = map[string]bool{"addr": true, "remote": true}
+
+// qmpRenames maps flattened schema members to the properties of the
+// command line, where -chardev parses them by hand.
+var qmpRenames = map[string]map[string]string{
+	"chardev": {
+		"out":        "path",
+		"device":     "path",
+		"str":        "fd",
+		"local.host": "localaddr",
+		"local.port": "localport",
+	},
+}
+
+// qmpBuiltins maps builtin schema types to property types.
+var qmpBuiltins = map[string]string{
+	"str": "str", "bool": "bool", "size": "size",
+	"int": "int", "int8": "int", "int16": "int", "int32": "int", "int64": "int",
+	"uint8": "uint", "uint16": "uint", "uint32": "uint", "uint64": "uint",
+}
+
+// scalar returns the property type of a schema type that is given as
+// a single value, false for objects and unions.
+func (s qmpSchema) scalar(e *qmpEntry) (propType, bool, error) {
+	switch e.MetaType {
+	case "builtin":
+		basic, found := qmpBuiltins[e.Name]
+		return propType{basic: basic}, found, nil
+	case "enum":
+		return propType{enum: e.Values}, true, nil
+	case "array":
+		return propType{basic: "list"}, true, nil
+	case "alternate":
+		var t propType
+		for _, m := range e.Members {
+			alt, err := s.lookup(m.Type)
+			if err != nil {
+				return t, false, err
+			}
+			if at, ok, err := s.scalar(alt); err != nil {
+				return t, false, err
+			} else if ok {
+				t.alts = append(t.alts, at)
+			}
+		}
+		return t, len(t.alts) > 0, nil
+	}
+	return propType{}, false, nil
+}
+
+// flatten adds the members of an object to props, nested objects as
+// dotted properties.  Nested unions take the members of variant.
+func (s qmpSchema) flatten(props map[string]propType, members []qmpMember, prefix, variant string) error {
+	for _, m := range members {
+		e, err := s.lookup(m.Type)
+		if err != nil {
+			return err
+		}
+
+		t, ok, err := s.scalar(e)
+		switch {
+		case err != nil:
+			return err
+		case ok:
+			props[prefix+m.Name] = t
+			continue
+		case e.MetaType != "object":
+			continue
+		}
+
+		nested := prefix + m.Name + "."
+		if qmpLifted[m.Name] {
+			nested = prefix
+		}
+		if e.Tag == "" {
+			if err := s.flatten(props, e.Members, nested, variant); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, v := range e.Variants {
+			if v.Case != variant {
+				continue
+			}
+			ve, err := s.lookup(v.Type)
+			if err != nil {
+				return err
+			}
+			if ve, err = s.unwrap(ve); err != nil {
+				return err
+			}
+			if err := s.flatten(props, ve.Members, nested, variant); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nestedVariants returns the variants of the unions nested in e, or
+// a single empty variant if there are none.
+func (s qmpSchema) nestedVariants(e *qmpEntry) ([]string, error) {
+	for _, m := range e.Members {
+		me, err := s.lookup(m.Type)
+		if err != nil {
+			return nil, err
+		}
+		if me.MetaType == "object" && me.Tag != "" {
+			var cases []string
+			for _, v := range me.Variants {
+				cases = append(cases, v.Case)
+			}
+			return cases, nil
+		}
+	}
+	return []string{""}, nil
+}
+
+// options returns the properties of the types of an option group.
+func (s qmpSchema) options(opt, command, member string) (map[string]map[string]propType, error) {
+	cmd, err := s.lookup(command)
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.lookup(cmd.ArgType)
+	if err != nil {
+		return nil, err
+	}
+
+	u, common := args, args.Members[:0:0]
+	if member != "" {
+		for _, m := range args.Members {
+			if m.Name != member {
+				common = append(common, m)
+			} else if u, err = s.lookup(m.Type); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if u.Tag == "" {
+		return nil, fmt.Errorf("%s: not a union", command)
+	}
+
+	types := map[string]map[string]propType{}
+	for _, v := range u.Variants {
+		e, err := s.lookup(v.Type)
+		if err != nil {
+			return nil, err
+		}
+		if e, err = s.unwrap(e); err != nil {
+			return nil, err
+		}
+
+		variants, err := s.nestedVariants(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, variant := range variants {
+			props := map[string]propType{}
+			for _, members := range [][]qmpMember{common, u.Members, e.Members} {
+				if err := s.flatten(props, members, "", variant); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", command, v.Case, err)
+				}
+			}
+			for from, to := range qmpRenames[opt] {
+				if t, found := props[from]; found {
+					delete(props, from)
+					props[to] = t
+				}
+			}
+
+			name := v.Case
+			if variant != "" {
+				name += "/" + variant
+			}
+			types[name] = props
+		}
+	}
+	return types, nil
+}
+
+// qomProperty is an entry of the device-list-properties output.
+type qomProperty struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+var (
+	// enumDescRe matches the list of values QEMU puts at the end
+	// of the description of enum properties, e.g. "on/off/auto".
+	enumDescRe = regexp.MustCompile(`(?:^|, +)([a-z0-9_-]+(?:/[a-z0-9_-]+)+)$`)
+
+	// pciAddrDesc starts the description of PCI addresses, which
+	// are reported as int32 but given as "slot.function".
+	pciAddrDesc = "Slot and optional function number"
+)
+
+// qomType maps a device property to a property type, false for
+// properties that cannot be set.
+func qomType(p qomProperty) (propType, bool) {
+	if m := enumDescRe.FindStringSubmatch(p.Description); m != nil {
+		return propType{enum: strings.Split(m[1], "/")}, true
+	}
+	switch {
+	case strings.HasPrefix(p.Type, "child<"), p.Type == "any":
+		return propType{}, false
+	case strings.HasPrefix(p.Description, pciAddrDesc), strings.HasPrefix(p.Type, "link<"):
+		return propType{basic: "str"}, true
+	}
+	if basic, found := qmpBuiltins[p.Type]; found {
+		return propType{basic: basic}, true
+	}
+	return propType{basic: "str"}, true
+}
+
+// clOption is an entry of the query-command-line-options output.
+type clOption struct {
+	Option     string      `json:"option"`
+	Parameters []qmpMember `json:"parameters"`
+}
+
+// clOptions maps the option groups without types to their name in the
+// query-command-line-options output.
+var clOptions = map[string]string{
+	"machine": "machine",
+	"m":       "memory",
+	"smp":     "smp-opts",
+	"boot":    "boot-opts",
+}
+
+// clTypes maps the parameter types of query-command-line-options to
+// property types.  Numbers are unsigned.
+var clTypes = map[string]string{"string": "str", "boolean": "bool", "number": "uint", "size": "size"}
+
+// cliProperties lists properties options take besides those in the
+// introspection data, e.g. the id and bus of -device, which QEMU
+// handles before looking at the driver.
+var cliProperties = map[string]map[string]propType{
+	"chardev": {"mux": {basic: "bool"}},
+	"device":  {"id": {basic: "str"}, "bus": {basic: "str"}},
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
+
+// loadSchema derives the properties of every option group and type
+// from the introspection snapshots in testdata/schema.
+func loadSchema(t *testing.T) schema {
+	t.Helper()
+	s := schema{}
+
+	var entries []*qmpEntry
+	readJSON(t, "testdata/schema/qmp-schema.json", &entries)
+	qmp := qmpSchema{}
+	for _, e := range entries {
+		qmp[e.Name] = e
+	}
+	for opt, c := range qmpCommands {
+		types, err := qmp.options(opt, c.command, c.member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s[opt] = types
+	}
+
+	var devices map[string][]qomProperty
+	readJSON(t, "testdata/schema/devices.json", &devices)
+	s["device"] = map[string]map[string]propType{}
+	for name, props := range devices {
+		types := map[string]propType{}
+		for _, p := range props {
+			if pt, ok := qomType(p); ok {
+				types[p.Name] = pt
+			}
+		}
+		s["device"][name] = types
+	}
+
+	var options []clOption
+	readJSON(t, "testdata/schema/command-line-options.json", &options)
+	for opt, name := range clOptions {
+		for _, o := range options {
+			if o.Option != name {
+				continue
+			}
+			props := map[string]propType{}
+			for _, p := range o.Parameters {
+				props[p.Name] = propType{basic: clTypes[p.Type]}
+			}
+			s[opt] = map[string]map[string]propType{"": props}
+		}
+	}
+
+	for opt, props := range cliProperties {
+		for _, types := range s[opt] {
+			for name, pt := range props {
+				types[name] = pt
+			}
+		}
+	}
+	return s
+}
+
+func TestLoadSchema(t *testing.T) {
+	s := loadSchema(t)
+
+	for _, tt := range []struct {
+		opt, typ, key string
+		want          string
+	}{
+		{"chardev", "socket/unix", "path", "str"},
+		{"chardev", "socket/inet", "to", "uint"},
+		{"chardev", "socket/fd", "fd", "str"},
+		{"chardev", "udp/inet", "localaddr", "str"},
+		{"chardev", "file", "path", "str"},
+		{"chardev", "null", "mux", "bool"},
+		{"object", "throttle-group", "limits.iops-total", "int"},
+		{"object", "memory-backend-ram", "policy", "default|preferred|bind|interleave"},
+		{"blockdev", "qcow2", "file", "str"},
+		{"blockdev", "qcow2", "overlap-check", "none|constant|cached|all"},
+		{"blockdev", "file", "cache.direct", "bool"},
+		{"netdev", "user", "hostfwd", "list"},
+		{"numa", "cpu", "socket-id", "int"},
+		{"device", "virtio-blk-pci", "addr", "str"},
+		{"device", "pc-dimm", "addr", "uint"},
+		{"device", "floppy", "drive-type", "144|288|120|none|auto"},
+		{"device", "nvme-ns", "bus", "str"},
+		{"smp", "", "cores", "uint"},
+	} {
+		pt, found := s[tt.opt][tt.typ][tt.key]
+		if assertpkg.True(t, found, "-%s %s: %s missing", tt.opt, tt.typ, tt.key) {
+			assertpkg.Equal(t, tt.want, pt.String(), "-%s %s: %s", tt.opt, tt.typ, tt.key)
+		}
+	}
+
+	for _, tt := range []struct{ opt, typ, key string }{
+		{"chardev", "socket/unix", "host"},
+		{"chardev", "socket/inet", "path"},
+		{"chardev", "file", "out"},
+		{"chardev", "udp/inet", "local.host"},
+		{"device", "virtio-blk-pci", "virtio-backend"},
+	} {
+		_, found := s[tt.opt][tt.typ][tt.key]
+		assertpkg.False(t, found, "-%s %s: %s", tt.opt, tt.typ, tt.key)
+	}
+}
@@ -0,0 +1,547 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+// conformanceDevices lists an instance of every device type checked
+// against the QEMU introspection snapshots in testdata/schema.  Fields the
+// harness cannot fill on its own, e.g. enums, are preset.
+var conformanceDevices = []libqatapult.Device{
+	qpdevices.Boot{},
+	qpdevices.RAM{},
+	qpdevices.SMP{},
+	qpdevices.Machine{},
+
+	qpdevices.NullCharDevice{},
+	qpdevices.PtyCharDevice{},
+	qpdevices.StdioCharDevice{},
+	qpdevices.RingbufCharDevice{},
+	qpdevices.MemoryCharDevice{},
+	qpdevices.VCCharDevice{},
+	qpdevices.FileCharDevice{},
+	qpdevices.PipeCharDevice{},
+	qpdevices.SerialCharDevice{},
+	qpdevices.FDSocketCharDevice{FD: 3},
+	qpdevices.TCPSocketCharDevice{InetSocket: qpdevices.InetSocket{Host: "localhost"}},
+	qpdevices.UnixSocketCharDevice{Path: "/run/qemu.sock"},
+	qpdevices.UDPSocketCharDevice{},
+
+	qpdevices.VirtIOSerialDevice{},
+	qpdevices.VirtSerialPortDevice{},
+	qpdevices.VirtConsoleDevice{},
+	qpdevices.ISASerialDevice{},
+
+	qpdevices.SecretObject{Format: qpdevices.SecretRaw},
+	qpdevices.TLSCredsX509Object{TLSCredsObject: qpdevices.TLSCredsObject{Endpoint: qpdevices.TLSEndpointServer}},
+	qpdevices.TLSCredsPSKObject{TLSCredsObject: qpdevices.TLSCredsObject{Endpoint: qpdevices.TLSEndpointClient}},
+	qpdevices.AuthzSimpleObject{},
+	qpdevices.MemoryBackendRAMObject{MemoryBackend: qpdevices.MemoryBackend{Policy: qpdevices.HostMemPolicyBind}},
+	qpdevices.MemoryBackendFileObject{MemoryBackend: qpdevices.MemoryBackend{Policy: qpdevices.HostMemPolicyPreferred}},
+	qpdevices.MemoryBackendMemfdObject{MemoryBackend: qpdevices.MemoryBackend{Policy: qpdevices.HostMemPolicyInterleave}},
+	qpdevices.RNGRandomObject{},
+	qpdevices.RNGBuiltinObject{},
+	qpdevices.IOThreadObject{},
+	qpdevices.ThrottleGroupObject{},
+
+	qpdevices.PCDIMMDevice{},
+	qpdevices.NVDIMMDevice{},
+	qpdevices.VirtIOMemPCIDevice{},
+	qpdevices.VirtIOBalloonPCIDevice{},
+
+	qpdevices.NUMANode{},
+	qpdevices.NUMACPU{},
+	qpdevices.NUMADist{},
+	qpdevices.HMATLB{Hierarchy: qpdevices.HMATMemory, DataType: qpdevices.HMATAccessLatency},
+	qpdevices.HMATCache{Associativity: qpdevices.HMATCacheAssociativityDirect, Policy: qpdevices.HMATCachePolicyWriteBack},
+
+	qpdevices.IDECDStorageDevice{},
+	qpdevices.IDEHDStorageDevice{},
+	qpdevices.SCSICDStorageDevice{},
+	qpdevices.SCSIHDStorageDevice{},
+	qpdevices.NvmeStorageDevice{},
+	qpdevices.NvmeNsStorageDevice{},
+	qpdevices.NvmeSubsysDevice{},
+	qpdevices.VirtIOBlkStorageDevice{},
+	qpdevices.USBStorageDevice{},
+	qpdevices.FloppyStorageDevice{},
+	qpdevices.VirtIOSCSIPCIDevice{},
+	qpdevices.ICH9AHCIDevice{},
+
+	qpdevices.FileBlockDevice{BlockDevice: qpdevices.BlockDevice{Discard: qpdevices.DiscardUnmap}},
+	qpdevices.RawFileBlockDevice{BlockDevice: qpdevices.BlockDevice{Discard: qpdevices.DiscardIgnore}},
+	qpdevices.QCOW2FileBlockDevice{BlockDevice: qpdevices.BlockDevice{Discard: qpdevices.DiscardUnmap}},
+
+	qpdevices.NetworkUserPeerDevice{},
+	&qpdevices.NetworkTAPPeerDevice{Queues: []libqatapult.File{qptest.NewMockFile()}},
+	qpdevices.NetworkDevice{Model: "virtio-net-pci"},
+}
+
+// conformanceExempt lists the device types the harness does not
+// check, with the reason why.
+var conformanceExempt = map[string]string{
+	"KVM":                "renders a flag only",
+	"CPU":                "features are up to the caller",
+	"Identifiers":        "renders positional values only",
+	"LinuxKernel":        "renders positional values only",
+	"GenericDevice":      "properties are up to the caller",
+	"GenericBlockDevice": "properties are up to the caller",
+	"StorageDevice":      "base of the typed storage devices",
+	"Conduit":            "renders an FDSocketCharDevice",
+	"PortConduit":        "renders a Conduit and VirtIOSerialBus",
+	"SocketPairDevice":   "renders an FDSocketCharDevice",
+	"VirtIOSerialBus":    "renders VirtIOSerialDevice and its ports",
+	"ISASerialBus":       "renders ISASerialDevice ports",
+	"NUMA":               "renders NUMANode, NUMACPU, NUMADist and HMAT entries",
+	"NVMe":               "renders NVMe subsystems, controllers and namespaces",
+	"SCSIAllocator":      "renders VirtIOSCSIPCIDevice controllers",
+}
+
+// conformanceFixedFields lists fields GetCliArgs overwrites.
+var conformanceFixedFields = map[string]bool{
+	"BlockDevice.Driver": true,
+}
+
+// typedOptions maps option groups to the property naming the type
+// within the group, the empty string if it is the leading value.
+// Options not listed have no types.
+var typedOptions = map[string]string{
+	"chardev":  "",
+	"object":   "",
+	"netdev":   "",
+	"device":   "",
+	"blockdev": "driver",
+	"numa":     "",
+}
+
+// typeVariants maps types whose properties depend on the variant of a
+// nested union to the properties selecting the variant, e.g. the
+// address family of socket chardevs.  The empty property names the
+// variant used if none of them is present.
+var typeVariants = map[string]map[string]string{
+	"chardev:socket": {"path": "unix", "host": "inet", "port": "inet", "fd": "fd"},
+	"chardev:udp":    {"": "inet"},
+}
+
+type (
+	// propType is either a basic type name, an enum or the
+	// alternatives a value may be any of.
+	propType struct {
+		basic string
+		enum  []string
+		alts  []propType
+	}
+
+	schema map[string]map[string]map[string]propType
+)
+
+var (
+	intRe  = regexp.MustCompile(`^-?[0-9]+$`)
+	uintRe = regexp.MustCompile(`^[0-9]+$`)
+)
+
+func (t propType) check(v string) error {
+	ok := true
+	switch {
+	case t.alts != nil:
+		ok = false
+		for _, alt := range t.alts {
+			if alt.check(v) == nil {
+				ok = true
+			}
+		}
+	case t.enum != nil:
+		ok = contains(t.enum, v)
+	case t.basic == "bool":
+		ok = v == "on" || v == "off"
+	case t.basic == "int":
+		ok = intRe.MatchString(v)
+	case t.basic == "uint", t.basic == "size":
+		ok = uintRe.MatchString(v)
+	case t.basic == "str", t.basic == "list":
+	default:
+		return fmt.Errorf("unknown schema type %q", t.basic)
+	}
+	if !ok {
+		return fmt.Errorf("value %q is not a valid %s", v, t)
+	}
+	return nil
+}
+
+func (t propType) String() string {
+	switch {
+	case t.alts != nil:
+		alts := make([]string, len(t.alts))
+		for i, alt := range t.alts {
+			alts[i] = alt.String()
+		}
+		return strings.Join(alts, " or ")
+	case t.enum != nil:
+		return strings.Join(t.enum, "|")
+	}
+	return t.basic
+}
+
+// hasEnum reports whether t is an enum or has one as alternative.
+func (t propType) hasEnum() bool {
+	for _, alt := range t.alts {
+		if alt.hasEnum() {
+			return true
+		}
+	}
+	return t.enum != nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// property is a single property as rendered on the command line.
+type property struct {
+	opt, typ, key, value string
+}
+
+func (p property) String() string { return fmt.Sprintf("-%s %s: %s=%s", p.opt, p.typ, p.key, p.value) }
+
+// parseArgs splits rendered command line arguments into properties.
+func parseArgs(args []string) ([]property, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("odd number of arguments: %q", args)
+	}
+
+	var props []property
+	for i := 0; i < len(args); i += 2 {
+		opt, ok := strings.CutPrefix(args[i], "-")
+		if !ok {
+			return nil, fmt.Errorf("expected an option, got %q", args[i])
+		}
+
+		typeKey, typed := typedOptions[opt]
+		parts := strings.Split(args[i+1], ",")
+
+		var typ string
+		if typed && typeKey == "" {
+			typ, parts = parts[0], parts[1:]
+		}
+
+		start := len(props)
+		for _, part := range parts {
+			key, value, found := strings.Cut(part, "=")
+			if !found {
+				return nil, fmt.Errorf("-%s: positional value %q", opt, part)
+			}
+			if typed && key == typeKey {
+				typ = value
+			}
+			props = append(props, property{opt: opt, key: key, value: value})
+		}
+		if variants, found := typeVariants[opt+":"+typ]; found {
+			variant := variants[""]
+			for _, p := range props[start:] {
+				if v, found := variants[p.key]; found {
+					variant = v
+					break
+				}
+			}
+			if variant != "" {
+				typ += "/" + variant
+			}
+		}
+		for j := start; j < len(props); j++ {
+			props[j].typ = typ
+		}
+	}
+	return props, nil
+}
+
+func (s schema) check(p property) error {
+	types, found := s[p.opt]
+	if !found {
+		return fmt.Errorf("%s: no schema for option", p)
+	}
+	props, found := types[p.typ]
+	if !found {
+		return fmt.Errorf("%s: no schema for type", p)
+	}
+	pt, found := props[p.key]
+	if !found {
+		return fmt.Errorf("%s: unknown property", p)
+	}
+	if pt.hasEnum() && p.value == fillString {
+		// Plain string fields are filled with a placeholder
+		// rather than a valid enum value.
+		return nil
+	}
+	if err := pt.check(p.value); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+func render(dev reflect.Value) ([]property, error) {
+	args, err := qptest.DeviceCliArgs(dev.Interface().(libqatapult.Device))
+	if err != nil {
+		return nil, err
+	}
+	return parseArgs(args)
+}
+
+var (
+	ipType      = reflect.TypeOf(net.IP{})
+	ipNetType   = reflect.TypeOf(&net.IPNet{})
+	macType     = reflect.TypeOf(net.HardwareAddr{})
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+	fileType    = reflect.TypeOf((*libqatapult.File)(nil)).Elem()
+	stringerTyp = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	optionType  = reflect.TypeOf((*interface{ IsSome() bool })(nil)).Elem()
+)
+
+// fillString is the value string fields are filled with.
+const fillString = "x"
+
+// fill sets v to a non-zero value.  It reports false for types it
+// does not know how to fill.
+func fill(v reflect.Value) bool {
+	vt := v.Type()
+
+	switch {
+	case vt == ipType:
+		v.Set(reflect.ValueOf(net.IPv4(192, 0, 2, 1)))
+		return true
+	case vt == ipNetType:
+		v.Set(reflect.ValueOf(&net.IPNet{IP: net.IPv4(192, 0, 2, 0), Mask: net.CIDRMask(24, 32)}))
+		return true
+	case vt == macType:
+		v.Set(reflect.ValueOf(net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}))
+		return true
+	case vt == uuidType:
+		v.Set(reflect.ValueOf(uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")))
+		return true
+	case vt == fileType:
+		v.Set(reflect.ValueOf(qptest.NewMockFile(qptest.MockFileWithIndex(libqatapult.FdOffset))))
+		return true
+	case vt.Implements(optionType):
+		elem := reflect.New(v.Addr().MethodByName("Set").Type().In(0)).Elem()
+		if !fill(elem) {
+			return false
+		}
+		v.Addr().MethodByName("Set").Call([]reflect.Value{elem})
+		return true
+	}
+
+	switch vt.Kind() {
+	case reflect.String:
+		v.SetString(fillString)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Slice:
+		elem := reflect.New(vt.Elem()).Elem()
+		if !fill(elem) {
+			return false
+		}
+		v.Set(reflect.Append(reflect.MakeSlice(vt, 0, 1), elem))
+	default:
+		return false
+	}
+	return true
+}
+
+// leafField is a field of a device, possibly promoted from an
+// embedded struct.
+type leafField struct {
+	name  string
+	index []int
+}
+
+// leafFields lists the fields of vt the harness fills one by one.
+func leafFields(vt reflect.Type, prefix string, index []int) (out []leafField) {
+	for i := 0; i < vt.NumField(); i++ {
+		f := vt.Field(i)
+		idx := append(append([]int(nil), index...), i)
+
+		if !f.IsExported() || conformanceFixedFields[prefix+f.Name] {
+			continue
+		}
+		if tag := f.Tag.Get("qp"); strings.Contains(tag, "~skip") || strings.Contains(tag, "~unnamed") {
+			continue
+		}
+
+		switch {
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			out = append(out, leafFields(f.Type, prefix+f.Name+".", idx)...)
+		case f.Type.Kind() == reflect.Struct && f.Type.Implements(stringerTyp) && f.Type.NumField() > 0 && !f.Type.Field(0).IsExported():
+			// Enums can only be preset in conformanceDevices.
+		default:
+			out = append(out, leafField{name: prefix + f.Name, index: idx})
+		}
+	}
+	return out
+}
+
+func typeName(dev any) string { return reflect.Indirect(reflect.ValueOf(dev)).Type().Name() }
+
+func TestConformance(t *testing.T) {
+	s := loadSchema(t)
+
+	for _, dev := range conformanceDevices {
+		dev := dev
+		t.Run(typeName(dev), func(t *testing.T) {
+			base := reflect.ValueOf(dev)
+			isPtr := base.Kind() == reflect.Pointer
+			base = reflect.Indirect(base)
+
+			instance := func() (reflect.Value, reflect.Value) {
+				p := reflect.New(base.Type())
+				p.Elem().Set(base)
+				if isPtr {
+					return p, p.Elem()
+				}
+				return p.Elem(), p.Elem()
+			}
+
+			d, _ := instance()
+			baseline, err := render(d)
+			if err != nil {
+				t.Fatalf("baseline: %v", err)
+			}
+			for _, p := range baseline {
+				assertpkg.NoError(t, s.check(p), "baseline")
+			}
+
+			rendered := map[string]string{}
+			for _, f := range leafFields(base.Type(), "", nil) {
+				d, elem := instance()
+				if !fill(elem.FieldByIndex(f.index)) {
+					t.Errorf("%s: cannot fill %s", f.name, elem.FieldByIndex(f.index).Type())
+					continue
+				}
+
+				props, err := render(d)
+				if err != nil {
+					t.Errorf("%s: %v", f.name, err)
+					continue
+				}
+
+				added := diffProps(baseline, props)
+				if len(added) == 0 {
+					t.Errorf("%s: renders no property", f.name)
+					continue
+				}
+				for _, p := range added {
+					assertpkg.NoError(t, s.check(p), f.name)
+
+					key := p.opt + ":" + p.key
+					if other, seen := rendered[key]; seen && other != f.name {
+						t.Errorf("%s and %s both render %s", other, f.name, p)
+					}
+					rendered[key] = f.name
+				}
+			}
+		})
+	}
+}
+
+// diffProps returns the properties of b that are not in a.
+func diffProps(a, b []property) (out []property) {
+	seen := map[property]int{}
+	for _, p := range a {
+		seen[p]++
+	}
+	for _, p := range b {
+		if seen[p] > 0 {
+			seen[p]--
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// TestConformance_Coverage makes sure every device type of the
+// package is either checked by TestConformance or exempt from it.
+func TestConformance_Coverage(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	paths, err := filepath.Glob("*.go")
+	if !assert.NoError(err) {
+		return
+	}
+
+	var types []string
+	fset := token.NewFileSet()
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, p, nil, 0)
+		if !assert.NoError(err) {
+			return
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "GetCliArgs" {
+				continue
+			}
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			types = append(types, recv.(*ast.Ident).Name)
+		}
+	}
+	sort.Strings(types)
+
+	checked := map[string]bool{}
+	for _, dev := range conformanceDevices {
+		checked[typeName(dev)] = true
+	}
+
+	for _, name := range types {
+		_, exempt := conformanceExempt[name]
+		assert.True(checked[name] || exempt, "%s is neither checked nor exempt", name)
+		assert.False(checked[name] && exempt, "%s is both checked and exempt", name)
+	}
+	for name := range conformanceExempt {
+		assert.Contains(types, name, "exempt type %s does not exist", name)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"github.com/qatapult/libqatapult/internal/serializer"
+)
+
+// deviceAdd returns the device_add command adding device d, which
+// has its Type set.
+func deviceAdd(d any) (string, map[string]any, error) {
+	args, err := serializer.GetProperties(d, "driver")
+	return "device_add", args, err
+}
+
+// objectAdd returns the object-add command adding object o, which
+// has its Type set.
+func objectAdd(o any) (string, map[string]any, error) {
+	args, err := serializer.GetProperties(o, "qom-type")
+	return "object-add", args, err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+var (
+	PCDIMMType       = DeviceType{"pc-dimm"}
+	NVDIMMType       = DeviceType{"nvdimm"}
+	VirtIOMemPCIType = DeviceType{"virtio-mem-pci"}
+
+	VirtIOBalloonPCIType = DeviceType{"virtio-balloon-pci"}
+)
+
+// PCDIMMDevice is a DIMM providing the memory of a memory backend
+// object.  DIMMs beyond the initial RAM need RAM.Slots and RAM.MaxMem
+// and can be hot-plugged with VM.PlugDIMM.
+type PCDIMMDevice struct {
+	BaseDevice
+
+	MemDev Reference `qp:"name=memdev"`
+
+	// Node is the NUMA node the memory belongs to.
+	Node qpoption.Option[uint32] `qp:""`
+
+	// Slot and Addr are allocated by QEMU unless set.
+	Slot qpoption.Option[int32]  `qp:""`
+	Addr qpoption.Option[uint64] `qp:""`
+}
+
+func (d PCDIMMDevice) GetName() string { return d.Name }
+
+func (d PCDIMMDevice) GetCliArgs() ([]string, error) {
+	d.Type = PCDIMMType
+	return serializer.GetCliArgs(d)
+}
+
+func (d PCDIMMDevice) GetHotplugCommand() (string, map[string]any, error) {
+	d.Type = PCDIMMType
+	return deviceAdd(d)
+}
+
+// NVDIMMDevice is a persistent memory DIMM, usually backed by a
+// MemoryBackendFileObject.  Machine.NVDIMM has to be on.
+//
+// A MemoryBackendFileObject passes its file to QEMU, which only works
+// when the VM starts, so an NVDIMM backed by one can only be
+// cold-plugged.  VM.Hotplug only adds NVDIMMs backed by RAM or memfd.
+type NVDIMMDevice struct {
+	PCDIMMDevice
+
+	// LabelSize reserves the end of the memory for namespace
+	// labels, at least 128 KiB if set.
+	LabelSize qpoption.Option[uint64] `qp:"~kebab"`
+
+	// Unarmed tells the guest the memory does not persist writes.
+	Unarmed qpoption.Option[bool] `qp:""`
+}
+
+func (d NVDIMMDevice) GetCliArgs() ([]string, error) {
+	d.Type = NVDIMMType
+	return serializer.GetCliArgs(d)
+}
+
+func (d NVDIMMDevice) GetHotplugCommand() (string, map[string]any, error) {
+	d.Type = NVDIMMType
+	return deviceAdd(d)
+}
+
+// VirtIOMemPCIDevice provides memory of a memory backend object in
+// blocks, which the guest plugs and unplugs to reach RequestedSize.
+// Resize it at runtime with VM.ResizeVirtIOMem.
+type VirtIOMemPCIDevice struct {
+	BaseDevice
+
+	MemDev Reference               `qp:"name=memdev"`
+	Node   qpoption.Option[uint32] `qp:""`
+
+	// RequestedSize is the amount of memory in bytes the guest
+	// should plug, at most the size of MemDev.
+	RequestedSize uint64 `qp:"~kebab"`
+
+	// BlockSize is the granularity in bytes memory is plugged in.
+	BlockSize qpoption.Option[uint64] `qp:"~kebab"`
+
+	Prealloc        qpoption.Option[bool] `qp:""`
+	DynamicMemslots qpoption.Option[bool] `qp:"~kebab"`
+}
+
+func (d VirtIOMemPCIDevice) GetName() string { return d.Name }
+
+func (d VirtIOMemPCIDevice) GetCliArgs() ([]string, error) {
+	d.Type = VirtIOMemPCIType
+	return serializer.GetCliArgs(d)
+}
+
+func (d VirtIOMemPCIDevice) GetHotplugCommand() (string, map[string]any, error) {
+	d.Type = VirtIOMemPCIType
+	return deviceAdd(d)
+}
+
+// VirtIOBalloonPCIDevice lets the host take memory from the guest
+// with VM.SetBalloon and read its memory statistics with
+// VM.BalloonStats.  A VM has at most one balloon.
+type VirtIOBalloonPCIDevice struct {
+	BaseDevice
+
+	// DeflateOnOOM lets the guest take memory back from the balloon
+	// before it runs out of memory.
+	DeflateOnOOM qpoption.Option[bool] `qp:"~kebab"`
+
+	// FreePageReporting makes the guest report memory it does not
+	// use, which the host then discards.
+	FreePageReporting qpoption.Option[bool] `qp:"~kebab"`
+
+	// FreePageHint makes the guest hint memory it does not use
+	// during migration, which then skips it.
+	FreePageHint qpoption.Option[bool] `qp:"~kebab"`
+
+	// IOThread handles the free page hints if set.
+	IOThread Reference `qp:"name=iothread"`
+}
+
+func (d VirtIOBalloonPCIDevice) GetName() string { return d.Name }
+
+func (d VirtIOBalloonPCIDevice) GetCliArgs() ([]string, error) {
+	d.Type = VirtIOBalloonPCIType
+	return serializer.GetCliArgs(d)
+}
+
+func (d VirtIOBalloonPCIDevice) GetHotplugCommand() (string, map[string]any, error) {
+	d.Type = VirtIOBalloonPCIType
+	return deviceAdd(d)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+func TestMemoryDevice_GetHotplugCommand(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	mem := qpdevices.MemoryBackendRAMObject{}
+	mem.Name = "mem1"
+	mem.Size = 1 << 30
+	mem.Share = qpoption.Value(true)
+
+	cmd, args, err := mem.GetHotplugCommand()
+	if assert.NoError(err) {
+		assert.Equal("object-add", cmd)
+		assert.Equal(map[string]any{
+			"qom-type": "memory-backend-ram", "id": "mem1",
+			"size": uint64(1 << 30), "share": true,
+		}, args)
+	}
+
+	dimm := qpdevices.PCDIMMDevice{MemDev: qpdevices.Ref(mem), Node: qpoption.Value(uint32(1))}
+	dimm.Name = "dimm1"
+
+	cmd, args, err = dimm.GetHotplugCommand()
+	if assert.NoError(err) {
+		assert.Equal("device_add", cmd)
+		assert.Equal(map[string]any{
+			"driver": "pc-dimm", "id": "dimm1", "memdev": "mem1", "node": uint64(1),
+		}, args)
+	}
+
+	vmem := qpdevices.VirtIOMemPCIDevice{MemDev: qpdevices.Ref(mem), RequestedSize: 512 << 20}
+	vmem.Name = "vmem"
+
+	_, args, err = vmem.GetHotplugCommand()
+	if assert.NoError(err) {
+		assert.Equal(map[string]any{
+			"driver": "virtio-mem-pci", "id": "vmem", "memdev": "mem1",
+			"requested-size": uint64(512 << 20),
+		}, args)
+	}
+}
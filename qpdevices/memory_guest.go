@@ -0,0 +1,233 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+// MemoryRegion maps Size bytes of guest physical addresses starting
+// at GPA to the memory backend, starting at Offset.
+type MemoryRegion struct {
+	GPA, Offset, Size uint64
+}
+
+// X86Layout returns the memory layout of x86 machines with size
+// bytes of RAM, of which at most below4G bytes are mapped below the
+// PCI hole and the rest from 4 GiB upwards.
+func X86Layout(size, below4G uint64) []MemoryRegion {
+	if size <= below4G {
+		return []MemoryRegion{{Size: size}}
+	}
+	return []MemoryRegion{
+		{Size: below4G},
+		{GPA: 1 << 32, Offset: below4G, Size: size - below4G},
+	}
+}
+
+// Q35Layout returns the memory layout QEMU uses for q35 machines with
+// size bytes of RAM.
+func Q35Layout(size uint64) []MemoryRegion {
+	if size >= 0xb0000000 {
+		return X86Layout(size, 0x80000000)
+	}
+	return X86Layout(size, 0xb0000000)
+}
+
+// PCLayout returns the memory layout QEMU uses for i440fx machines
+// with size bytes of RAM.
+func PCLayout(size uint64) []MemoryRegion {
+	if size >= 0xe0000000 {
+		return X86Layout(size, 0xc0000000)
+	}
+	return X86Layout(size, 0xe0000000)
+}
+
+// GuestMemory is guest RAM backed by a memory file owned by the host,
+// which maps it as well to read and write guest memory by guest
+// physical address, e.g. to inspect the guest or plant data in it.
+//
+// The memory file is a memfd, yet GuestMemory is a file backend:
+// memory-backend-memfd always creates a memfd of its own and cannot
+// take an existing one, so the memfd is passed to QEMU and used as a
+// /dev/fd/N mem-path instead.  Passing it requires the command line,
+// hence GuestMemory can only be added at startup, VM.Hotplug rejects
+// it.
+//
+// Refer to it from the MemoryBackend of Machine, with RAM set to the
+// same size.
+type GuestMemory struct {
+	MemoryBackendFileObject
+
+	mu      sync.RWMutex // guards mem against Close
+	mem     []byte       // nil once closed
+	regions []MemoryRegion
+}
+
+var (
+	_ io.ReaderAt = &GuestMemory{}
+	_ io.WriterAt = &GuestMemory{}
+)
+
+// ErrNotRAM is returned when accessing guest physical addresses not
+// backed by GuestMemory.
+var ErrNotRAM = errors.New("qpdevices.GuestMemory: address not backed by RAM")
+
+type guestMemoryOpts struct {
+	regions []MemoryRegion
+}
+
+type GuestMemoryOpt func(o *guestMemoryOpts)
+
+// WithMemoryLayout sets where the memory appears in the guest
+// physical address space, e.g. Q35Layout.  By default it starts at
+// address 0 without holes.
+func WithMemoryLayout(regions []MemoryRegion) GuestMemoryOpt {
+	return func(o *guestMemoryOpts) { o.regions = regions }
+}
+
+// NewGuestMemory creates size bytes of guest RAM in a memory file and
+// maps it into the host.  Close unmaps it.
+func NewGuestMemory(name string, size uint64, opts ...GuestMemoryOpt) (_ *GuestMemory, err error) {
+	cfg := guestMemoryOpts{regions: []MemoryRegion{{Size: size}}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if len(cfg.regions) == 0 {
+		return nil, errors.New("qpdevices.GuestMemory: no memory regions")
+	}
+	for _, r := range cfg.regions {
+		if r.Offset+r.Size > size || r.Offset+r.Size < r.Offset || r.GPA+r.Size < r.GPA {
+			return nil, fmt.Errorf("qpdevices.GuestMemory: region %#x+%#x exceeds the memory", r.GPA, r.Size)
+		}
+	}
+	regions := append([]MemoryRegion(nil), cfg.regions...)
+	sort.Slice(regions, func(i, j int) bool { return regions[i].GPA < regions[j].GPA })
+	for i := 1; i < len(regions); i++ {
+		if prev := regions[i-1]; prev.GPA+prev.Size > regions[i].GPA {
+			return nil, fmt.Errorf("qpdevices.GuestMemory: region %#x+%#x overlaps region %#x+%#x",
+				regions[i].GPA, regions[i].Size, prev.GPA, prev.Size)
+		}
+	}
+
+	f, err := libqatapult.NewMemoryFile("guest-memory:" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, f.Close())
+		}
+	}()
+
+	if err := f.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	mem, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("qpdevices.GuestMemory: mmap: %w", err)
+	}
+
+	return &GuestMemory{
+		MemoryBackendFileObject: MemoryBackendFileObject{
+			MemoryBackend: MemoryBackend{
+				BaseObject: BaseObject{Name: name},
+				Size:       size,
+				Share:      qpoption.Value(true),
+			},
+			MemPath: f,
+		},
+		mem:     mem,
+		regions: regions,
+	}, nil
+}
+
+// translate returns the memory at gpa up to the end of its region.
+func (m *GuestMemory) translate(gpa uint64) ([]byte, error) {
+	for _, r := range m.regions {
+		if gpa >= r.GPA && gpa-r.GPA < r.Size {
+			off := r.Offset + gpa - r.GPA
+			return m.mem[off : r.Offset+r.Size], nil
+		}
+	}
+
+	last := m.regions[len(m.regions)-1]
+	if gpa >= last.GPA+last.Size {
+		return nil, io.EOF
+	}
+	return nil, fmt.Errorf("%w: %#x", ErrNotRAM, gpa)
+}
+
+func (m *GuestMemory) access(p []byte, gpa int64, copyFn func(mem, p []byte) int) (n int, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.mem == nil {
+		return 0, os.ErrClosed
+	}
+	if gpa < 0 {
+		return 0, fmt.Errorf("%w: negative address", ErrNotRAM)
+	}
+
+	for n < len(p) {
+		mem, err := m.translate(uint64(gpa) + uint64(n))
+		if err != nil {
+			return n, err
+		}
+		n += copyFn(mem, p[n:])
+	}
+	return n, nil
+}
+
+// ReadAt reads guest memory at guest physical address gpa.
+func (m *GuestMemory) ReadAt(p []byte, gpa int64) (int, error) {
+	return m.access(p, gpa, func(mem, p []byte) int { return copy(p, mem) })
+}
+
+// WriteAt writes guest memory at guest physical address gpa.
+func (m *GuestMemory) WriteAt(p []byte, gpa int64) (int, error) {
+	n, err := m.access(p, gpa, func(mem, p []byte) int { return copy(mem, p) })
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %#x", ErrNotRAM, gpa+int64(n))
+	}
+	return n, err
+}
+
+// Close unmaps the memory and closes the memory file.  The guest keeps
+// its memory if QEMU runs already.  It waits for reads and writes in
+// progress, later ones return os.ErrClosed.
+func (m *GuestMemory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mem == nil {
+		return nil
+	}
+	err := unix.Munmap(m.mem)
+	m.mem = nil
+	return multierr.Append(err, m.MemPath.GetHandle().Close())
+}
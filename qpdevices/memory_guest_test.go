@@ -0,0 +1,153 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestGuestMemory(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	m, err := qpdevices.NewGuestMemory("ram0", 4<<20)
+	if !assert.NoError(err) {
+		return
+	}
+	defer m.Close()
+
+	if args, err := qptest.DeviceCliArgs(m); assert.NoError(err) {
+		assert.Equal([]string{"-object", "memory-backend-file,id=ram0,size=4194304,share=on,mem-path=/dev/fd/3"}, args)
+	}
+
+	n, err := m.WriteAt([]byte("planted"), 0x1000)
+	assert.NoError(err)
+	assert.Equal(7, n)
+
+	// The memory file QEMU maps sees the data.
+	buf := make([]byte, 7)
+	_, err = m.MemPath.GetHandle().ReadAt(buf, 0x1000)
+	assert.NoError(err)
+	assert.Equal("planted", string(buf))
+
+	_, err = m.ReadAt(buf, 0x1000)
+	assert.NoError(err)
+	assert.Equal("planted", string(buf))
+
+	n, err = m.ReadAt(buf, 4<<20-3)
+	assert.ErrorIs(err, io.EOF)
+	assert.Equal(3, n)
+	_, err = m.WriteAt(buf, 4<<20-3)
+	assert.ErrorIs(err, qpdevices.ErrNotRAM)
+
+	assert.NoError(m.Close())
+	_, err = m.ReadAt(buf, 0)
+	assert.ErrorIs(err, os.ErrClosed)
+	_, err = m.WriteAt(buf, 0)
+	assert.ErrorIs(err, os.ErrClosed)
+	assert.NoError(m.Close())
+}
+
+func TestGuestMemory_ConcurrentClose(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	m, err := qpdevices.NewGuestMemory("ram0", 1<<20)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 64<<10)
+			for {
+				if _, err := m.WriteAt(buf, 0); err != nil {
+					assert.ErrorIs(err, os.ErrClosed)
+					return
+				}
+			}
+		}()
+	}
+	assert.NoError(m.Close())
+	wg.Wait()
+}
+
+func TestGuestMemory_BadLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions []qpdevices.MemoryRegion
+	}{
+		{"empty", nil},
+		{"overlap", []qpdevices.MemoryRegion{
+			{Size: 0x80000},
+			{GPA: 0x40000, Offset: 0x80000, Size: 0x80000},
+		}},
+		{"wraps", []qpdevices.MemoryRegion{{GPA: 1<<64 - 0x1000, Size: 0x2000}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := qpdevices.NewGuestMemory("ram0", 1<<20, qpdevices.WithMemoryLayout(tt.regions))
+			assertpkg.Error(t, err)
+		})
+	}
+}
+
+func TestGuestMemory_Layout(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	const size = 3 << 30
+	m, err := qpdevices.NewGuestMemory("ram0", size, qpdevices.WithMemoryLayout(qpdevices.Q35Layout(size)))
+	if !assert.NoError(err) {
+		return
+	}
+	defer m.Close()
+
+	// Above 4 GiB continues after the 2 GiB below the PCI hole.
+	_, err = m.WriteAt([]byte("high"), 1<<32)
+	assert.NoError(err)
+	buf := make([]byte, 4)
+	_, err = m.MemPath.GetHandle().ReadAt(buf, 2<<30)
+	assert.NoError(err)
+	assert.Equal("high", string(buf))
+
+	_, err = m.ReadAt(buf, 3<<30)
+	assert.ErrorIs(err, qpdevices.ErrNotRAM)
+	_, err = m.ReadAt(buf, 2<<30-2)
+	assert.ErrorIs(err, qpdevices.ErrNotRAM)
+	_, err = m.ReadAt(buf, 5<<30)
+	assert.ErrorIs(err, io.EOF)
+
+	_, err = qpdevices.NewGuestMemory("ram1", 1<<20, qpdevices.WithMemoryLayout(qpdevices.Q35Layout(2<<20)))
+	assert.Error(err)
+}
+
+func TestX86Layout(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	assert.Equal([]qpdevices.MemoryRegion{{Size: 1 << 30}}, qpdevices.Q35Layout(1<<30))
+	assert.Equal([]qpdevices.MemoryRegion{
+		{Size: 0xc0000000},
+		{GPA: 1 << 32, Offset: 0xc0000000, Size: 1 << 30},
+	}, qpdevices.PCLayout(4<<30))
+}
@@ -0,0 +1,512 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"fmt"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+// NUMANode is a NUMA node of the guest.
+//
+// <https://man.archlinux.org/man/qemu.1.en#numa>
+type NUMANode struct {
+	_    any    `qp:"opt=numa"`
+	Type string `qp:"~unnamed"`
+
+	NodeID qpoption.Option[uint32] `qp:"name=nodeid"`
+
+	// MemDev names the memory backend object providing the memory
+	// of the node, none for a node without memory.
+	MemDev Reference `qp:"name=memdev"`
+
+	// Initiator is the node whose CPUs or devices access the memory
+	// of a node without CPUs of its own, reported in the HMAT.
+	Initiator qpoption.Option[uint32] `qp:""`
+}
+
+func (d NUMANode) GetCliArgs() ([]string, error) {
+	d.Type = "node"
+	return serializer.GetCliArgs(d)
+}
+
+// NUMACPU assigns the CPUs matching the given topology IDs to a node,
+// e.g. all CPUs of a socket if only SocketID is set.
+type NUMACPU struct {
+	_    any    `qp:"opt=numa"`
+	Type string `qp:"~unnamed"`
+
+	NodeID    qpoption.Option[uint32] `qp:"name=node-id"`
+	SocketID  qpoption.Option[uint32] `qp:"~kebab"`
+	DieID     qpoption.Option[uint32] `qp:"~kebab"`
+	ClusterID qpoption.Option[uint32] `qp:"~kebab"`
+	CoreID    qpoption.Option[uint32] `qp:"~kebab"`
+	ThreadID  qpoption.Option[uint32] `qp:"~kebab"`
+}
+
+func (d NUMACPU) GetCliArgs() ([]string, error) {
+	d.Type = "cpu"
+	return serializer.GetCliArgs(d)
+}
+
+// NUMADist sets the distance between two nodes as reported in the
+// SLIT, where 10 is the distance of a node to itself.
+type NUMADist struct {
+	_    any    `qp:"opt=numa"`
+	Type string `qp:"~unnamed"`
+
+	Src qpoption.Option[uint32] `qp:""`
+	Dst qpoption.Option[uint32] `qp:""`
+	Val uint32                  `qp:""`
+}
+
+func (d NUMADist) GetCliArgs() ([]string, error) {
+	d.Type = "dist"
+	return serializer.GetCliArgs(d)
+}
+
+type HMATHierarchy struct{ slug string }
+
+func (h HMATHierarchy) String() string { return h.slug }
+
+var (
+	HMATMemory      = HMATHierarchy{"memory"}
+	HMATFirstLevel  = HMATHierarchy{"first-level"}
+	HMATSecondLevel = HMATHierarchy{"second-level"}
+	HMATThirdLevel  = HMATHierarchy{"third-level"}
+)
+
+type HMATDataType struct {
+	slug      string
+	bandwidth bool
+}
+
+func (t HMATDataType) String() string { return t.slug }
+
+var (
+	HMATAccessLatency   = HMATDataType{"access-latency", false}
+	HMATReadLatency     = HMATDataType{"read-latency", false}
+	HMATWriteLatency    = HMATDataType{"write-latency", false}
+	HMATAccessBandwidth = HMATDataType{"access-bandwidth", true}
+	HMATReadBandwidth   = HMATDataType{"read-bandwidth", true}
+	HMATWriteBandwidth  = HMATDataType{"write-bandwidth", true}
+)
+
+// HMATLB reports the latency or bandwidth between an initiator and a
+// target node in the HMAT.  Machine.HMAT has to be on.
+type HMATLB struct {
+	_    any    `qp:"opt=numa"`
+	Type string `qp:"~unnamed"`
+
+	Initiator qpoption.Option[uint32] `qp:""`
+	Target    qpoption.Option[uint32] `qp:""`
+	Hierarchy HMATHierarchy           `qp:""`
+	DataType  HMATDataType            `qp:"~kebab"`
+
+	// Latency is in nanoseconds, for latency data types.
+	Latency uint64 `qp:""`
+
+	// Bandwidth is in bytes per second, for bandwidth data types.
+	Bandwidth uint64 `qp:""`
+}
+
+func (d HMATLB) GetCliArgs() ([]string, error) {
+	d.Type = "hmat-lb"
+	return serializer.GetCliArgs(d)
+}
+
+type HMATCacheAssociativity struct{ slug string }
+
+func (a HMATCacheAssociativity) String() string { return a.slug }
+
+var (
+	HMATCacheAssociativityNone    = HMATCacheAssociativity{"none"}
+	HMATCacheAssociativityDirect  = HMATCacheAssociativity{"direct"}
+	HMATCacheAssociativityComplex = HMATCacheAssociativity{"complex"}
+)
+
+type HMATCachePolicy struct{ slug string }
+
+func (p HMATCachePolicy) String() string { return p.slug }
+
+var (
+	HMATCachePolicyNone         = HMATCachePolicy{"none"}
+	HMATCachePolicyWriteBack    = HMATCachePolicy{"write-back"}
+	HMATCachePolicyWriteThrough = HMATCachePolicy{"write-through"}
+)
+
+// HMATCache reports a memory side cache of a node in the HMAT.
+type HMATCache struct {
+	_    any    `qp:"opt=numa"`
+	Type string `qp:"~unnamed"`
+
+	NodeID qpoption.Option[uint32] `qp:"name=node-id"`
+
+	// Size is the size of the cache in bytes.
+	Size uint64 `qp:""`
+
+	// Level is the cache level from 1 to 3.
+	Level         uint32                 `qp:""`
+	Associativity HMATCacheAssociativity `qp:""`
+	Policy        HMATCachePolicy        `qp:""`
+
+	// Line is the cache line size in bytes.
+	Line uint32 `qp:""`
+}
+
+func (d HMATCache) GetCliArgs() ([]string, error) {
+	d.Type = "hmat-cache"
+	return serializer.GetCliArgs(d)
+}
+
+// MemoryBackendObject is a memory backend object, e.g. a
+// MemoryBackendRAMObject or GuestMemory.
+type MemoryBackendObject interface {
+	NamedDevice
+	GetSize() uint64
+}
+
+// NUMA is the NUMA topology of the guest, dividing the CPUs of SMP
+// and the memory of RAM among its nodes.  Both have to match the SMP
+// and RAM devices of the VM, which are not rendered by NUMA.
+//
+// GetCliArgs fails unless every CPU belongs to exactly one node, the
+// memory of the nodes adds up to RAM and the distances between the
+// nodes are complete.
+type NUMA struct {
+	SMP SMP
+	RAM RAM
+
+	nodes     []*NUMANode
+	sizes     []uint64
+	cpus      []NUMACPU
+	distances []NUMADist
+	lbs       []HMATLB
+	caches    []HMATCache
+}
+
+// NewNUMA creates a NUMA topology for the given SMP and RAM devices.
+func NewNUMA(smp SMP, ram RAM) *NUMA {
+	return &NUMA{SMP: smp, RAM: ram}
+}
+
+// AddNode adds a node with the memory of memdev, which can be nil for
+// a node without memory.  Nodes are numbered in the order they are
+// added.
+func (n *NUMA) AddNode(memdev MemoryBackendObject) *NUMANode {
+	node := &NUMANode{NodeID: qpoption.Value(uint32(len(n.nodes)))}
+	var size uint64
+	if memdev != nil {
+		node.MemDev = Ref(memdev)
+		size = memdev.GetSize()
+	}
+	n.nodes = append(n.nodes, node)
+	n.sizes = append(n.sizes, size)
+	return node
+}
+
+// AddCPUs assigns the CPUs matching the IDs set in cpu to node.
+func (n *NUMA) AddCPUs(node uint32, cpu NUMACPU) {
+	cpu.NodeID = qpoption.Value(node)
+	n.cpus = append(n.cpus, cpu)
+}
+
+// AddSocket assigns all CPUs of socket to node.
+func (n *NUMA) AddSocket(node, socket uint32) {
+	n.AddCPUs(node, NUMACPU{SocketID: qpoption.Value(socket)})
+}
+
+// SetDistance sets the distance from node src to node dst.  Unless
+// some distance differs between the directions, the distance from dst
+// to src is the same.
+func (n *NUMA) SetDistance(src, dst, val uint32) {
+	n.distances = append(n.distances, NUMADist{
+		Src: qpoption.Value(src),
+		Dst: qpoption.Value(dst),
+		Val: val,
+	})
+}
+
+// AddHMATLB adds latency or bandwidth information to the HMAT.
+func (n *NUMA) AddHMATLB(lb HMATLB) { n.lbs = append(n.lbs, lb) }
+
+// AddHMATCache adds memory side cache information to the HMAT.
+func (n *NUMA) AddHMATCache(c HMATCache) { n.caches = append(n.caches, c) }
+
+// topology returns the number of sockets, dies, clusters, cores and
+// threads per core, completed like QEMU 6.2 and later do for unset
+// values: missing cores are preferred over missing sockets, and
+// threads are derived last.
+func (n *NUMA) topology() ([5]int, error) {
+	s := n.SMP
+	sockets, cores, threads := s.Sockets.OrElse(0), s.Cores.OrElse(0), s.Threads.OrElse(0)
+	dies, clusters := s.Dies.OrElse(0), s.Clusters.OrElse(0)
+	cpus, maxCPUs := s.CPUs.OrElse(0), s.MaxCPUs.OrElse(0)
+	for _, v := range []int{sockets, dies, clusters, cores, threads, cpus, maxCPUs} {
+		if v < 0 {
+			return [5]int{}, fmt.Errorf("qpdevices.NUMA: invalid SMP topology")
+		}
+	}
+
+	orOne := func(v int) int {
+		if v == 0 {
+			return 1
+		}
+		return v
+	}
+	dies, clusters = orOne(dies), orOne(clusters)
+	if cpus == 0 && maxCPUs == 0 {
+		sockets, cores, threads = orOne(sockets), orOne(cores), orOne(threads)
+	} else {
+		if maxCPUs == 0 {
+			maxCPUs = cpus
+		}
+		switch {
+		case cores == 0:
+			sockets, threads = orOne(sockets), orOne(threads)
+			cores = maxCPUs / (sockets * dies * clusters * threads)
+		case sockets == 0:
+			threads = orOne(threads)
+			sockets = maxCPUs / (dies * clusters * cores * threads)
+		}
+		if threads == 0 {
+			threads = maxCPUs / (sockets * dies * clusters * cores)
+		}
+	}
+
+	t := [5]int{sockets, dies, clusters, cores, threads}
+	total := sockets * dies * clusters * cores * threads
+	if maxCPUs == 0 {
+		maxCPUs = total
+	}
+	if total == 0 || total != maxCPUs {
+		return t, fmt.Errorf("qpdevices.NUMA: SMP topology does not add up to %d CPUs", maxCPUs)
+	}
+	if cpus > maxCPUs {
+		return t, fmt.Errorf("qpdevices.NUMA: SMP has %d CPUs, more than the maximum of %d", cpus, maxCPUs)
+	}
+	return t, nil
+}
+
+func (c NUMACPU) ids() [5]qpoption.Option[uint32] {
+	return [5]qpoption.Option[uint32]{c.SocketID, c.DieID, c.ClusterID, c.CoreID, c.ThreadID}
+}
+
+func cpuName(ids [5]int) string {
+	return fmt.Sprintf("socket %d die %d cluster %d core %d thread %d", ids[0], ids[1], ids[2], ids[3], ids[4])
+}
+
+func (n *NUMA) validateNode(id qpoption.Option[uint32], what string) error {
+	if !id.IsSome() || int(id.Yank()) >= len(n.nodes) {
+		return fmt.Errorf("qpdevices.NUMA: %s refers to a missing node", what)
+	}
+	return nil
+}
+
+func (n *NUMA) validateCPUs() error {
+	t, err := n.topology()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range n.cpus {
+		if err := n.validateNode(c.NodeID, "CPU assignment"); err != nil {
+			return err
+		}
+	}
+
+	matched := make([]int, len(n.cpus))
+	var cpu [5]int
+	var visit func(level int) error
+	visit = func(level int) error {
+		if level < len(cpu) {
+			for cpu[level] = 0; cpu[level] < t[level]; cpu[level]++ {
+				if err := visit(level + 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		owner := -1
+		for i, c := range n.cpus {
+			match := true
+			for j, id := range c.ids() {
+				if id.IsSome() && int(id.Yank()) != cpu[j] {
+					match = false
+				}
+			}
+			if !match {
+				continue
+			}
+			if owner >= 0 && n.cpus[owner].NodeID.Yank() != c.NodeID.Yank() {
+				return fmt.Errorf("qpdevices.NUMA: CPU %s is assigned to nodes %d and %d",
+					cpuName(cpu), n.cpus[owner].NodeID.Yank(), c.NodeID.Yank())
+			}
+			owner = i
+			matched[i]++
+		}
+		if owner < 0 {
+			return fmt.Errorf("qpdevices.NUMA: CPU %s is not assigned to a node", cpuName(cpu))
+		}
+		return nil
+	}
+	if err := visit(0); err != nil {
+		return err
+	}
+
+	for i, c := range n.cpus {
+		if matched[i] == 0 {
+			args, _ := c.GetCliArgs()
+			return fmt.Errorf("qpdevices.NUMA: %s matches no CPU", args[1])
+		}
+	}
+	return nil
+}
+
+func (n *NUMA) validateMemory() error {
+	var total uint64
+	for _, size := range n.sizes {
+		total += size
+	}
+	if want := uint64(n.RAM.Size) << 20; total != want {
+		return fmt.Errorf("qpdevices.NUMA: nodes have %d bytes of memory, RAM is %d bytes", total, want)
+	}
+	return nil
+}
+
+// validateDistances checks the distances like QEMU, which completes
+// them with the opposite direction unless they are asymmetrical.
+func (n *NUMA) validateDistances() error {
+	if len(n.distances) == 0 {
+		return nil
+	}
+
+	type pair struct{ src, dst uint32 }
+	dist := map[pair]uint32{}
+	for _, d := range n.distances {
+		if err := n.validateNode(d.Src, "distance"); err != nil {
+			return err
+		}
+		if err := n.validateNode(d.Dst, "distance"); err != nil {
+			return err
+		}
+		src, dst := d.Src.Yank(), d.Dst.Yank()
+		switch {
+		case src == dst && d.Val != 10:
+			return fmt.Errorf("qpdevices.NUMA: distance of node %d to itself has to be 10", src)
+		case src != dst && (d.Val <= 10 || d.Val > 255):
+			return fmt.Errorf("qpdevices.NUMA: distance %d from node %d to %d is not between 11 and 255", d.Val, src, dst)
+		}
+		dist[pair{src, dst}] = d.Val
+	}
+
+	asymmetrical := false
+	for p, val := range dist {
+		if other, found := dist[pair{p.dst, p.src}]; found && other != val {
+			asymmetrical = true
+		}
+	}
+
+	for src := range n.nodes {
+		for dst := range n.nodes {
+			p := pair{uint32(src), uint32(dst)}
+			if src == dst {
+				continue
+			}
+			if _, found := dist[p]; found {
+				continue
+			}
+			if _, found := dist[pair{p.dst, p.src}]; !found || asymmetrical {
+				return fmt.Errorf("qpdevices.NUMA: distance from node %d to %d is missing", src, dst)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *NUMA) validateHMAT() error {
+	for _, lb := range n.lbs {
+		if err := n.validateNode(lb.Initiator, "HMAT initiator"); err != nil {
+			return err
+		}
+		if err := n.validateNode(lb.Target, "HMAT target"); err != nil {
+			return err
+		}
+		if lb.DataType.bandwidth && (lb.Bandwidth == 0 || lb.Latency != 0) ||
+			!lb.DataType.bandwidth && (lb.Latency == 0 || lb.Bandwidth != 0) {
+			return fmt.Errorf("qpdevices.NUMA: HMAT %s needs a value of its type only", lb.DataType)
+		}
+	}
+	for _, c := range n.caches {
+		if err := n.validateNode(c.NodeID, "HMAT cache"); err != nil {
+			return err
+		}
+		if c.Level < 1 || c.Level > 3 {
+			return fmt.Errorf("qpdevices.NUMA: HMAT cache level %d is not between 1 and 3", c.Level)
+		}
+	}
+	return nil
+}
+
+// Validate checks the topology is complete and consistent.
+func (n *NUMA) Validate() error {
+	if len(n.nodes) == 0 {
+		return fmt.Errorf("qpdevices.NUMA: no nodes")
+	}
+	for _, validate := range []func() error{
+		n.validateCPUs, n.validateMemory, n.validateDistances, n.validateHMAT,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *NUMA) GetCliArgs() (out []string, err error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	var devices []libqatapult.Device
+	for _, d := range n.nodes {
+		devices = append(devices, d)
+	}
+	for _, d := range n.cpus {
+		devices = append(devices, d)
+	}
+	for _, d := range n.distances {
+		devices = append(devices, d)
+	}
+	for _, d := range n.lbs {
+		devices = append(devices, d)
+	}
+	for _, d := range n.caches {
+		devices = append(devices, d)
+	}
+
+	for _, d := range devices {
+		args, err := d.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	return out, nil
+}
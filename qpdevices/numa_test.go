@@ -0,0 +1,205 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func newRAMBackend(name string, size uint64) qpdevices.MemoryBackendRAMObject {
+	return qpdevices.MemoryBackendRAMObject{MemoryBackend: qpdevices.MemoryBackend{
+		BaseObject: qpdevices.BaseObject{Name: name},
+		Size:       size,
+	}}
+}
+
+// newNUMA returns two nodes with a socket of two cores and 512 MiB
+// each.
+func newNUMA() *qpdevices.NUMA {
+	n := qpdevices.NewNUMA(qpdevices.SMP{
+		CPUs:    qpoption.Value(4),
+		Sockets: qpoption.Value(2),
+		Cores:   qpoption.Value(2),
+	}, qpdevices.RAM{Size: 1024})
+	n.AddNode(newRAMBackend("mem0", 512<<20))
+	n.AddNode(newRAMBackend("mem1", 512<<20))
+	n.AddSocket(0, 0)
+	n.AddSocket(1, 1)
+	return n
+}
+
+func TestNUMA(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	n := newNUMA()
+	n.SetDistance(0, 1, 20)
+	n.AddHMATLB(qpdevices.HMATLB{
+		Initiator: qpoption.Value[uint32](0),
+		Target:    qpoption.Value[uint32](1),
+		Hierarchy: qpdevices.HMATMemory,
+		DataType:  qpdevices.HMATAccessLatency,
+		Latency:   10,
+	})
+	n.AddHMATCache(qpdevices.HMATCache{
+		NodeID:        qpoption.Value[uint32](1),
+		Size:          10 << 10,
+		Level:         1,
+		Associativity: qpdevices.HMATCacheAssociativityDirect,
+		Policy:        qpdevices.HMATCachePolicyWriteBack,
+		Line:          8,
+	})
+
+	got, err := qptest.DeviceCliArgs(n)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{
+		"-numa", "node,nodeid=0,memdev=mem0",
+		"-numa", "node,nodeid=1,memdev=mem1",
+		"-numa", "cpu,node-id=0,socket-id=0",
+		"-numa", "cpu,node-id=1,socket-id=1",
+		"-numa", "dist,src=0,dst=1,val=20",
+		"-numa", "hmat-lb,initiator=0,target=1,hierarchy=memory,data-type=access-latency,latency=10",
+		"-numa", "hmat-cache,node-id=1,size=10240,level=1,associativity=direct,policy=write-back,line=8",
+	}, got)
+}
+
+func TestNUMA_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(n *qpdevices.NUMA)
+		wantErr string
+	}{
+		{"valid", func(n *qpdevices.NUMA) {}, ""},
+
+		{"memoryless node", func(n *qpdevices.NUMA) {
+			node := n.AddNode(nil)
+			node.Initiator = qpoption.Value[uint32](0)
+		}, ""},
+
+		{"unassigned cpu", func(n *qpdevices.NUMA) {
+			n.SMP.Sockets = qpoption.Value(3)
+			n.SMP.CPUs = qpoption.Value(6)
+		}, "CPU socket 2 die 0 cluster 0 core 0 thread 0 is not assigned to a node"},
+
+		{"cpu in two nodes", func(n *qpdevices.NUMA) {
+			n.AddCPUs(1, qpdevices.NUMACPU{SocketID: qpoption.Value[uint32](0), CoreID: qpoption.Value[uint32](1)})
+		}, "assigned to nodes 0 and 1"},
+
+		{"cpu of no socket", func(n *qpdevices.NUMA) {
+			n.AddSocket(1, 7)
+		}, "cpu,node-id=1,socket-id=7 matches no CPU"},
+
+		{"missing node", func(n *qpdevices.NUMA) {
+			n.AddSocket(2, 0)
+		}, "CPU assignment refers to a missing node"},
+
+		{"bad topology", func(n *qpdevices.NUMA) {
+			n.SMP.Cores = qpoption.Value(3)
+		}, "SMP topology does not add up to 4 CPUs"},
+
+		{"cores derived", func(n *qpdevices.NUMA) {
+			n.SMP = qpdevices.SMP{CPUs: qpoption.Value(8), Sockets: qpoption.Value(2)}
+		}, ""},
+
+		{"sockets derived", func(n *qpdevices.NUMA) {
+			n.SMP = qpdevices.SMP{CPUs: qpoption.Value(4), Cores: qpoption.Value(2)}
+		}, ""},
+
+		{"cores preferred", func(n *qpdevices.NUMA) {
+			*n = *qpdevices.NewNUMA(qpdevices.SMP{CPUs: qpoption.Value(4)}, qpdevices.RAM{Size: 1024})
+			n.AddNode(newRAMBackend("mem0", 1024<<20))
+			n.AddSocket(0, 0)
+		}, ""},
+
+		{"cores preferred over sockets", func(n *qpdevices.NUMA) {
+			n.SMP = qpdevices.SMP{CPUs: qpoption.Value(4)}
+		}, "cpu,node-id=1,socket-id=1 matches no CPU"},
+
+		{"threads derived", func(n *qpdevices.NUMA) {
+			n.SMP.Threads = qpoption.Value(0)
+			n.SMP.Cores = qpoption.Value(1)
+			n.AddCPUs(1, qpdevices.NUMACPU{SocketID: qpoption.Value[uint32](1), ThreadID: qpoption.Value[uint32](1)})
+		}, ""},
+
+		{"fewer cpus than topology", func(n *qpdevices.NUMA) {
+			n.SMP.MaxCPUs = qpoption.Value(8)
+			n.SMP.Cores = qpoption.Value(4)
+		}, ""},
+
+		{"more cpus than maxcpus", func(n *qpdevices.NUMA) {
+			n.SMP.CPUs = qpoption.Value(8)
+			n.SMP.MaxCPUs = qpoption.Value(4)
+		}, "SMP has 8 CPUs, more than the maximum of 4"},
+
+		{"memory short", func(n *qpdevices.NUMA) {
+			n.RAM.Size = 2048
+		}, "nodes have 1073741824 bytes of memory, RAM is 2147483648 bytes"},
+
+		{"distance missing", func(n *qpdevices.NUMA) {
+			n.AddNode(nil)
+			n.SetDistance(0, 1, 20)
+			n.SetDistance(0, 2, 30)
+		}, "distance from node 1 to 2 is missing"},
+
+		{"distance asymmetrical", func(n *qpdevices.NUMA) {
+			n.AddNode(nil)
+			n.SetDistance(0, 1, 20)
+			n.SetDistance(1, 0, 21)
+			n.SetDistance(0, 2, 30)
+			n.SetDistance(1, 2, 30)
+		}, "distance from node 2 to 0 is missing"},
+
+		{"distance to itself", func(n *qpdevices.NUMA) {
+			n.SetDistance(0, 0, 20)
+		}, "distance of node 0 to itself has to be 10"},
+
+		{"distance too short", func(n *qpdevices.NUMA) {
+			n.SetDistance(0, 1, 10)
+		}, "distance 10 from node 0 to 1 is not between 11 and 255"},
+
+		{"hmat bandwidth as latency", func(n *qpdevices.NUMA) {
+			n.AddHMATLB(qpdevices.HMATLB{
+				Initiator: qpoption.Value[uint32](0),
+				Target:    qpoption.Value[uint32](0),
+				DataType:  qpdevices.HMATReadBandwidth,
+				Latency:   5,
+			})
+		}, "HMAT read-bandwidth needs a value of its type only"},
+
+		{"hmat cache level", func(n *qpdevices.NUMA) {
+			n.AddHMATCache(qpdevices.HMATCache{NodeID: qpoption.Value[uint32](0), Level: 4})
+		}, "HMAT cache level 4 is not between 1 and 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNUMA()
+			tt.setup(n)
+
+			err := n.Validate()
+			if tt.wantErr == "" {
+				assertpkg.NoError(t, err)
+			} else {
+				assertpkg.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
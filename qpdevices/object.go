@@ -0,0 +1,102 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"go.uber.org/multierr"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/internal/serializer"
+)
+
+// BaseObject is the common part of all user creatable objects.
+//
+// <https://man.archlinux.org/man/qemu.1.en#object>
+type BaseObject struct {
+	_ any `qp:"opt=object"`
+
+	Type string `qp:"~unnamed"`
+
+	// Name specifies a unique identifier for the given object.
+	Name string `qp:"name=id"`
+}
+
+func (o BaseObject) GetName() string { return o.Name }
+
+type SecretFormat struct{ slug string }
+
+func (f SecretFormat) String() string { return f.slug }
+
+var (
+	SecretRaw    = SecretFormat{"raw"}
+	SecretBase64 = SecretFormat{"base64"}
+)
+
+// SecretObject provides a password, key or other sensitive data to
+// other objects, which refer to it by its Name.
+//
+// Prefer File over Data, as the latter shows up in the process list
+// of the host.
+type SecretObject struct {
+	BaseObject
+
+	Data   string           `qp:""`
+	File   libqatapult.File `qp:""`
+	Format SecretFormat     `qp:""`
+
+	// KeyID names another SecretObject that decrypts this secret
+	// with AES-256-CBC using IV.
+	KeyID Reference `qp:"name=keyid"`
+	IV    string    `qp:"name=iv"`
+}
+
+func (o SecretObject) GetFiles() []libqatapult.File {
+	if o.File == nil {
+		return nil
+	}
+	return []libqatapult.File{o.File}
+}
+
+func (o SecretObject) GetCliArgs() ([]string, error) {
+	o.Type = "secret"
+	return serializer.GetCliArgs(o)
+}
+
+// NewSecret creates a SecretObject holding data in a memory file, so
+// it neither touches the disk nor appears on the command line.
+func NewSecret(name string, data []byte) (_ *SecretObject, err error) {
+	f, err := libqatapult.NewMemoryFile("secret:" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, f.Close())
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return &SecretObject{
+		BaseObject: BaseObject{Name: name},
+		File:       f,
+		Format:     SecretRaw,
+	}, nil
+}
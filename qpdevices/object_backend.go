@@ -0,0 +1,108 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+// RNGRandomObject is an entropy source reading from a host device,
+// /dev/urandom by default, for devices such as virtio-rng.
+type RNGRandomObject struct {
+	BaseObject
+
+	Filename string `qp:""`
+}
+
+func (o RNGRandomObject) GetCliArgs() ([]string, error) {
+	o.Type = "rng-random"
+	return serializer.GetCliArgs(o)
+}
+
+// RNGBuiltinObject is an entropy source using the random number
+// generator of QEMU itself.
+type RNGBuiltinObject struct {
+	BaseObject
+}
+
+func (o RNGBuiltinObject) GetCliArgs() ([]string, error) {
+	o.Type = "rng-builtin"
+	return serializer.GetCliArgs(o)
+}
+
+// IOThreadObject is an event loop thread devices and block jobs can
+// run their I/O in, instead of the main loop.
+//
+// <https://man.archlinux.org/man/qemu.1.en#iothread>
+type IOThreadObject struct {
+	BaseObject
+
+	// PollMaxNS is the longest time in nanoseconds the thread
+	// busy-waits for events before sleeping, 0 disables polling.
+	PollMaxNS     qpoption.Option[int64] `qp:"name=poll-max-ns"`
+	PollGrow      qpoption.Option[int64] `qp:"~kebab"`
+	PollShrink    qpoption.Option[int64] `qp:"~kebab"`
+	AIOMaxBatch   qpoption.Option[int64] `qp:"name=aio-max-batch"`
+	ThreadPoolMin qpoption.Option[int64] `qp:"~kebab"`
+	ThreadPoolMax qpoption.Option[int64] `qp:"~kebab"`
+}
+
+func (o IOThreadObject) GetCliArgs() ([]string, error) {
+	o.Type = "iothread"
+	return serializer.GetCliArgs(o)
+}
+
+// ThrottleLimits are the I/O limits of a throttle group.  IOPS are
+// operations and BPS bytes per second, the Max variants allow bursts
+// for up to MaxLength seconds.
+type ThrottleLimits struct {
+	IOPSTotal          qpoption.Option[uint64] `qp:"name='limits.iops-total'"`
+	IOPSTotalMax       qpoption.Option[uint64] `qp:"name='limits.iops-total-max'"`
+	IOPSTotalMaxLength qpoption.Option[uint64] `qp:"name='limits.iops-total-max-length'"`
+	IOPSRead           qpoption.Option[uint64] `qp:"name='limits.iops-read'"`
+	IOPSReadMax        qpoption.Option[uint64] `qp:"name='limits.iops-read-max'"`
+	IOPSReadMaxLength  qpoption.Option[uint64] `qp:"name='limits.iops-read-max-length'"`
+	IOPSWrite          qpoption.Option[uint64] `qp:"name='limits.iops-write'"`
+	IOPSWriteMax       qpoption.Option[uint64] `qp:"name='limits.iops-write-max'"`
+	IOPSWriteMaxLength qpoption.Option[uint64] `qp:"name='limits.iops-write-max-length'"`
+	BPSTotal           qpoption.Option[uint64] `qp:"name='limits.bps-total'"`
+	BPSTotalMax        qpoption.Option[uint64] `qp:"name='limits.bps-total-max'"`
+	BPSTotalMaxLength  qpoption.Option[uint64] `qp:"name='limits.bps-total-max-length'"`
+	BPSRead            qpoption.Option[uint64] `qp:"name='limits.bps-read'"`
+	BPSReadMax         qpoption.Option[uint64] `qp:"name='limits.bps-read-max'"`
+	BPSReadMaxLength   qpoption.Option[uint64] `qp:"name='limits.bps-read-max-length'"`
+	BPSWrite           qpoption.Option[uint64] `qp:"name='limits.bps-write'"`
+	BPSWriteMax        qpoption.Option[uint64] `qp:"name='limits.bps-write-max'"`
+	BPSWriteMaxLength  qpoption.Option[uint64] `qp:"name='limits.bps-write-max-length'"`
+
+	// IOPSSize is the size of a request counted as one operation,
+	// larger requests count as several.
+	IOPSSize qpoption.Option[uint64] `qp:"name='limits.iops-size'"`
+}
+
+// ThrottleGroupObject shares I/O limits between the throttle block
+// nodes referring to it.
+//
+// <https://man.archlinux.org/man/qemu.1.en#throttle-group>
+type ThrottleGroupObject struct {
+	BaseObject
+	ThrottleLimits
+}
+
+func (o ThrottleGroupObject) GetCliArgs() ([]string, error) {
+	o.Type = "throttle-group"
+	return serializer.GetCliArgs(o)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+type HostMemPolicy struct{ slug string }
+
+func (p HostMemPolicy) String() string { return p.slug }
+
+var (
+	HostMemPolicyDefault    = HostMemPolicy{"default"}
+	HostMemPolicyPreferred  = HostMemPolicy{"preferred"}
+	HostMemPolicyBind       = HostMemPolicy{"bind"}
+	HostMemPolicyInterleave = HostMemPolicy{"interleave"}
+)
+
+// MemoryBackend is the common part of the memory backends, which
+// provide the RAM of the guest, e.g. to a NUMA node or through the
+// memory-backend property of Machine.
+//
+// <https://man.archlinux.org/man/qemu.1.en#memory-backend-file>
+type MemoryBackend struct {
+	BaseObject
+
+	// Size is the size of the memory region in bytes.
+	Size uint64 `qp:""`
+
+	// Share makes the memory visible to other processes mapping the
+	// same backing, e.g. vhost-user daemons.
+	Share qpoption.Option[bool] `qp:""`
+
+	Merge           qpoption.Option[bool]   `qp:""`
+	Dump            qpoption.Option[bool]   `qp:""`
+	Prealloc        qpoption.Option[bool]   `qp:""`
+	PreallocThreads qpoption.Option[uint32] `qp:"~kebab"`
+	Reserve         qpoption.Option[bool]   `qp:""`
+	Policy          HostMemPolicy           `qp:""`
+}
+
+// GetSize returns the size of the memory in bytes.
+func (b MemoryBackend) GetSize() uint64 { return b.Size }
+
+// MemoryBackendRAMObject is guest RAM allocated by QEMU with
+// anonymous memory.
+type MemoryBackendRAMObject struct {
+	MemoryBackend
+}
+
+func (o MemoryBackendRAMObject) GetCliArgs() ([]string, error) {
+	o.Type = "memory-backend-ram"
+	return serializer.GetCliArgs(o)
+}
+
+func (o MemoryBackendRAMObject) GetHotplugCommand() (string, map[string]any, error) {
+	o.Type = "memory-backend-ram"
+	return objectAdd(o)
+}
+
+// MemoryBackendFileObject is guest RAM mapped from a file, e.g. on
+// hugetlbfs or a memory file the host maps as well.  VM.Hotplug
+// rejects it, as MemPath cannot be passed to a running QEMU.
+type MemoryBackendFileObject struct {
+	MemoryBackend
+
+	MemPath libqatapult.File `qp:"name=mem-path"`
+
+	// Offset is where the memory starts within MemPath.
+	Offset qpoption.Option[uint64] `qp:""`
+	Align  qpoption.Option[uint64] `qp:""`
+
+	// DiscardData drops the contents when QEMU exits instead of
+	// writing them back to MemPath.
+	DiscardData qpoption.Option[bool] `qp:"~kebab"`
+	PMem        qpoption.Option[bool] `qp:"name=pmem"`
+	ReadOnly    qpoption.Option[bool] `qp:"name=readonly"`
+}
+
+func (o MemoryBackendFileObject) GetFiles() []libqatapult.File {
+	if o.MemPath == nil {
+		return nil
+	}
+	return []libqatapult.File{o.MemPath}
+}
+
+func (o MemoryBackendFileObject) GetCliArgs() ([]string, error) {
+	o.Type = "memory-backend-file"
+	return serializer.GetCliArgs(o)
+}
+
+func (o MemoryBackendFileObject) GetHotplugCommand() (string, map[string]any, error) {
+	o.Type = "memory-backend-file"
+	return objectAdd(o)
+}
+
+// MemoryBackendMemfdObject is guest RAM QEMU allocates with an
+// anonymous memory file, which can be shared with vhost-user daemons.
+type MemoryBackendMemfdObject struct {
+	MemoryBackend
+
+	HugeTLB qpoption.Option[bool] `qp:"name=hugetlb"`
+
+	// HugeTLBSize selects the huge page size in bytes.
+	HugeTLBSize qpoption.Option[uint64] `qp:"name=hugetlbsize"`
+
+	// Seal prevents the memory from being grown or shrunk.
+	Seal qpoption.Option[bool] `qp:""`
+}
+
+func (o MemoryBackendMemfdObject) GetCliArgs() ([]string, error) {
+	o.Type = "memory-backend-memfd"
+	return serializer.GetCliArgs(o)
+}
+
+func (o MemoryBackendMemfdObject) GetHotplugCommand() (string, map[string]any, error) {
+	o.Type = "memory-backend-memfd"
+	return objectAdd(o)
+}
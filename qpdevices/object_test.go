@@ -0,0 +1,161 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"io"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestObjects(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  libqatapult.Device
+		want []string
+	}{
+		{"secret", qpdevices.SecretObject{
+			BaseObject: qpdevices.BaseObject{Name: "sec0"},
+			Data:       "c2VjcmV0",
+			Format:     qpdevices.SecretBase64,
+		}, []string{"-object", "secret,id=sec0,data=c2VjcmV0,format=base64"}},
+
+		{"tls-creds-x509", qpdevices.TLSCredsX509Object{
+			TLSCredsObject: qpdevices.TLSCredsObject{
+				BaseObject: qpdevices.BaseObject{Name: "tls0"},
+				Endpoint:   qpdevices.TLSEndpointServer,
+				Dir:        "/etc/pki/qemu",
+			},
+			VerifyPeer: qpoption.Value(true),
+			PasswordID: "sec0",
+		}, []string{"-object", "tls-creds-x509,id=tls0,endpoint=server,dir=/etc/pki/qemu,verify-peer=on,passwordid=sec0"}},
+
+		{"tls-creds-psk", qpdevices.TLSCredsPSKObject{
+			TLSCredsObject: qpdevices.TLSCredsObject{
+				BaseObject: qpdevices.BaseObject{Name: "psk0"},
+				Endpoint:   qpdevices.TLSEndpointClient,
+				Dir:        "/etc/pki/qemu-psk",
+			},
+			Username: "lab",
+		}, []string{"-object", "tls-creds-psk,id=psk0,endpoint=client,dir=/etc/pki/qemu-psk,username=lab"}},
+
+		{"authz-simple", qpdevices.AuthzSimpleObject{
+			BaseObject: qpdevices.BaseObject{Name: "auth0"},
+			Identity:   "CN=lab",
+		}, []string{"-object", "authz-simple,id=auth0,identity=CN=lab"}},
+
+		{"memory-backend-ram", qpdevices.MemoryBackendRAMObject{MemoryBackend: qpdevices.MemoryBackend{
+			BaseObject: qpdevices.BaseObject{Name: "ram0"},
+			Size:       1 << 30,
+			Prealloc:   qpoption.Value(true),
+			Policy:     qpdevices.HostMemPolicyBind,
+		}}, []string{"-object", "memory-backend-ram,id=ram0,size=1073741824,prealloc=on,policy=bind"}},
+
+		{"memory-backend-file", qpdevices.MemoryBackendFileObject{
+			MemoryBackend: qpdevices.MemoryBackend{
+				BaseObject: qpdevices.BaseObject{Name: "ram0"},
+				Size:       1 << 30,
+				Share:      qpoption.Value(true),
+			},
+			MemPath:     qptest.NewMockFile(qptest.MockFileWithIndex(3)),
+			DiscardData: qpoption.Value(true),
+		}, []string{"-object", "memory-backend-file,id=ram0,size=1073741824,share=on,mem-path=/dev/fd/3,discard-data=on"}},
+
+		{"memory-backend-memfd", qpdevices.MemoryBackendMemfdObject{
+			MemoryBackend: qpdevices.MemoryBackend{
+				BaseObject: qpdevices.BaseObject{Name: "ram0"},
+				Size:       1 << 30,
+			},
+			HugeTLB:     qpoption.Value(true),
+			HugeTLBSize: qpoption.Value[uint64](2 << 20),
+		}, []string{"-object", "memory-backend-memfd,id=ram0,size=1073741824,hugetlb=on,hugetlbsize=2097152"}},
+
+		{"rng-random", qpdevices.RNGRandomObject{
+			BaseObject: qpdevices.BaseObject{Name: "rng0"},
+			Filename:   "/dev/urandom",
+		}, []string{"-object", "rng-random,id=rng0,filename=/dev/urandom"}},
+
+		{"rng-builtin", qpdevices.RNGBuiltinObject{
+			BaseObject: qpdevices.BaseObject{Name: "rng0"},
+		}, []string{"-object", "rng-builtin,id=rng0"}},
+
+		{"iothread", qpdevices.IOThreadObject{
+			BaseObject: qpdevices.BaseObject{Name: "io0"},
+			PollMaxNS:  qpoption.Value[int64](32768),
+		}, []string{"-object", "iothread,id=io0,poll-max-ns=32768"}},
+
+		{"throttle-group", qpdevices.ThrottleGroupObject{
+			BaseObject: qpdevices.BaseObject{Name: "limits0"},
+			ThrottleLimits: qpdevices.ThrottleLimits{
+				IOPSTotal:    qpoption.Value[uint64](200),
+				IOPSTotalMax: qpoption.Value[uint64](2000),
+				BPSWrite:     qpoption.Value[uint64](10 << 20),
+			},
+		}, []string{"-object", "throttle-group,id=limits0,limits.iops-total=200,limits.iops-total-max=2000,limits.bps-write=10485760"}},
+
+		{"iothread reference", qpdevices.VirtIOSCSIPCIDevice{
+			BaseDevice: qpdevices.BaseDevice{Name: "scsi0"},
+			IOThread:   qpdevices.Ref(qpdevices.IOThreadObject{BaseObject: qpdevices.BaseObject{Name: "io0"}}),
+		}, []string{"-device", "virtio-scsi-pci,id=scsi0,iothread=io0"}},
+
+		{"memory-backend reference", qpdevices.Machine{
+			Type:          "q35",
+			MemoryBackend: "ram0",
+		}, []string{"-machine", "type=q35,memory-backend=ram0"}},
+
+		{"tls socket", qpdevices.UnixSocketCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "serial0"},
+			SocketCharDevice: qpdevices.SocketCharDevice{
+				Server:   qpoption.Value(true),
+				TLSCreds: "tls0",
+				TLSAuthz: "auth0",
+			},
+			Path: "/run/serial0.sock",
+		}, []string{"-chardev", "socket,id=serial0,server=on,tls-creds=tls0,tls-authz=auth0,path=/run/serial0.sock"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qptest.DeviceCliArgs(tt.dev)
+			if assertpkg.NoError(t, err) {
+				assertpkg.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	sec, err := qpdevices.NewSecret("sec0", []byte("hunter2"))
+	if !assert.NoError(err) {
+		return
+	}
+	defer sec.File.GetHandle().Close()
+
+	if args, err := qptest.DeviceCliArgs(sec); assert.NoError(err) {
+		assert.Equal([]string{"-object", "secret,id=sec0,file=/dev/fd/3,format=raw"}, args)
+	}
+
+	data, err := io.ReadAll(sec.File.GetHandle())
+	if assert.NoError(err) {
+		assert.Equal("hunter2", string(data))
+	}
+}
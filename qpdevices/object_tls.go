@@ -0,0 +1,94 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"github.com/qatapult/libqatapult/internal/serializer"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+type TLSEndpoint struct{ slug string }
+
+func (e TLSEndpoint) String() string { return e.slug }
+
+var (
+	TLSEndpointServer = TLSEndpoint{"server"}
+	TLSEndpointClient = TLSEndpoint{"client"}
+)
+
+// TLSCredsObject holds the properties shared by all TLS credentials.
+type TLSCredsObject struct {
+	BaseObject
+
+	Endpoint TLSEndpoint `qp:""`
+
+	// Dir is the directory QEMU loads the credential files from.
+	Dir string `qp:""`
+
+	// Priority overrides the GnuTLS priority string.
+	Priority string `qp:""`
+}
+
+// TLSCredsX509Object loads x509 certificates from Dir, which has to
+// contain ca-cert.pem and, depending on Endpoint, server-cert.pem and
+// server-key.pem or client-cert.pem and client-key.pem.
+//
+// QEMU only reads certificates and keys from files on disk, so they
+// cannot be passed in memory.  A SecretObject can only provide the
+// password of an encrypted private key through PasswordID.
+//
+// <https://www.qemu.org/docs/master/system/tls.html>
+type TLSCredsX509Object struct {
+	TLSCredsObject
+
+	VerifyPeer  qpoption.Option[bool] `qp:"name=verify-peer"`
+	SanityCheck qpoption.Option[bool] `qp:"name=sanity-check"`
+
+	// PasswordID names the SecretObject decrypting the private key.
+	PasswordID Reference `qp:"name=passwordid"`
+}
+
+func (o TLSCredsX509Object) GetCliArgs() ([]string, error) {
+	o.Type = "tls-creds-x509"
+	return serializer.GetCliArgs(o)
+}
+
+// TLSCredsPSKObject loads pre-shared keys from keys.psk in Dir.
+// Clients use the key of Username.  Like certificates, the keys have
+// to be in a file on disk.
+type TLSCredsPSKObject struct {
+	TLSCredsObject
+
+	Username string `qp:""`
+}
+
+func (o TLSCredsPSKObject) GetCliArgs() ([]string, error) {
+	o.Type = "tls-creds-psk"
+	return serializer.GetCliArgs(o)
+}
+
+// AuthzSimpleObject authorizes TLS clients by matching the
+// distinguished name of their certificate or their PSK username
+// against Identity.
+type AuthzSimpleObject struct {
+	BaseObject
+
+	Identity string `qp:""`
+}
+
+func (o AuthzSimpleObject) GetCliArgs() ([]string, error) {
+	o.Type = "authz-simple"
+	return serializer.GetCliArgs(o)
+}
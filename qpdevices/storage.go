@@ -28,6 +28,10 @@ var (
 	NVMENSType = DeviceType{"nvme-ns"}
 	SCSICDType = DeviceType{"scsi-cd"}
 	SCSIHDType = DeviceType{"scsi-hd"}
+
+	VirtIOBlkPCIType = DeviceType{"virtio-blk-pci"}
+	USBStorageType   = DeviceType{"usb-storage"}
+	FloppyType       = DeviceType{"floppy"}
 )
 
 // StorageDevice represents a generic storage device.
@@ -108,6 +112,14 @@ type NvmeStorageDevice struct {
 	StorageDevice
 
 	Serial string
+
+	// Subsys is the NvmeSubsysDevice the controller belongs to,
+	// which then owns the namespaces of the controller.
+	Subsys Reference `qp:"name=subsys"`
+
+	// ZASL limits the size of zone append commands to 2^ZASL times
+	// the minimum memory page size, by default to MDTS.
+	ZASL qpoption.Option[uint8] `qp:"name='zoned.zasl'"`
 }
 
 func (d NvmeStorageDevice) GetCliArgs() ([]string, error) {
@@ -124,9 +136,91 @@ type NvmeNsStorageDevice struct {
 
 	// UUID is the UUID of the namespace.
 	UUID uuid.UUID
+
+	// NSID is the namespace identifier, 1 to 256, by default the
+	// lowest free one.
+	NSID qpoption.Option[uint32] `qp:""`
+
+	// Shared attaches the namespace to all controllers of the
+	// subsystem, which the guest then reaches through multiple
+	// paths.  Detached leaves it to the guest to attach it.
+	Shared   qpoption.Option[bool] `qp:""`
+	Detached qpoption.Option[bool] `qp:""`
+
+	// Zoned makes the namespace a zoned namespace of zones of
+	// ZoneSize bytes, 128 MiB by default, of which ZoneCapacity
+	// bytes can be written.
+	Zoned        qpoption.Option[bool]   `qp:""`
+	ZoneSize     qpoption.Option[uint64] `qp:"name='zoned.zone_size'"`
+	ZoneCapacity qpoption.Option[uint64] `qp:"name='zoned.zone_capacity'"`
+
+	// MaxOpen and MaxActive limit the number of open and active
+	// zones, where 0 means no limit.
+	MaxOpen   qpoption.Option[uint32] `qp:"name='zoned.max_open'"`
+	MaxActive qpoption.Option[uint32] `qp:"name='zoned.max_active'"`
+
+	// CrossRead allows reads across zone boundaries.
+	CrossRead qpoption.Option[bool] `qp:"name='zoned.cross_read'"`
 }
 
 func (d NvmeNsStorageDevice) GetCliArgs() ([]string, error) {
 	d.StorageDevice.Type = NVMENSType
 	return serializer.GetCliArgs(d)
 }
+
+// VirtIOBlkStorageDevice represents a virtio-blk StorageDevice node.
+type VirtIOBlkStorageDevice struct {
+	StorageDevice
+
+	Serial string
+
+	// NumQueues is the number of request queues, by default one per
+	// vCPU.
+	NumQueues qpoption.Option[uint16] `qp:"~kebab"`
+
+	// QueueSize is the number of requests in flight per queue.
+	QueueSize qpoption.Option[uint16] `qp:"~kebab"`
+
+	// IOThread names the IOThreadObject handling the requests of
+	// the device.
+	IOThread Reference `qp:"name=iothread"`
+
+	// Discard and WriteZeroes tell the guest the device supports the
+	// respective requests.
+	Discard     qpoption.Option[bool] `qp:""`
+	WriteZeroes qpoption.Option[bool] `qp:"~kebab"`
+}
+
+func (d VirtIOBlkStorageDevice) GetCliArgs() ([]string, error) {
+	d.StorageDevice.Type = VirtIOBlkPCIType
+	return serializer.GetCliArgs(d)
+}
+
+// USBStorageDevice represents a USB mass storage StorageDevice node.
+type USBStorageDevice struct {
+	StorageDevice
+
+	Serial string
+
+	// Removable tells the guest the medium can be removed.
+	Removable qpoption.Option[bool] `qp:""`
+}
+
+func (d USBStorageDevice) GetCliArgs() ([]string, error) {
+	d.StorageDevice.Type = USBStorageType
+	return serializer.GetCliArgs(d)
+}
+
+// FloppyStorageDevice represents a floppy disk drive StorageDevice
+// node on the bus of a floppy controller.
+type FloppyStorageDevice struct {
+	StorageDevice
+
+	// Unit is the number of the drive on the controller, 0 or 1.
+	Unit qpoption.Option[uint32]
+}
+
+func (d FloppyStorageDevice) GetCliArgs() ([]string, error) {
+	d.StorageDevice.Type = FloppyType
+	return serializer.GetCliArgs(d)
+}
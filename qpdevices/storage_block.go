@@ -36,9 +36,9 @@ type BlockDevice struct {
 	_            any                   `qp:"opt=blockdev"`
 	Driver       string                ``
 	Name         string                `qp:"name=node-name"`
-	ReadOnly     qpoption.Option[bool] ``
-	AutoReadOnly qpoption.Option[bool] ``
-	ForceShare   qpoption.Option[bool] ``
+	ReadOnly     qpoption.Option[bool] `qp:"~kebab"`
+	AutoReadOnly qpoption.Option[bool] `qp:"~kebab"`
+	ForceShare   qpoption.Option[bool] `qp:"~kebab"`
 	CacheDirect  qpoption.Option[bool] `qp:"name='cache.direct'"`
 	CacheNoFlush qpoption.Option[bool] `qp:"name='cache.no-flush'"`
 	Discard      DiscardOption         `qp:""`
@@ -15,16 +15,47 @@
 package qpdevices
 
 import (
+	"fmt"
+
 	"github.com/qatapult/libqatapult/internal/serializer"
 )
 
-var VirtIOSCSIPCIType = DeviceType{"virtio-scsi-pci"}
+var (
+	VirtIOSCSIPCIType = DeviceType{"virtio-scsi-pci"}
+	ICH9AHCIType      = DeviceType{"ich9-ahci"}
+)
 
 type VirtIOSCSIPCIDevice struct {
 	BaseDevice
+
+	// IOThread names the IOThreadObject handling the requests of
+	// the controller.
+	IOThread Reference `qp:"name=iothread"`
 }
 
+func (d VirtIOSCSIPCIDevice) GetName() string { return d.Name }
+
+// BusName returns the name of the SCSI bus provided by the
+// controller.
+func (d VirtIOSCSIPCIDevice) BusName() string { return d.Name + ".0" }
+
 func (d VirtIOSCSIPCIDevice) GetCliArgs() ([]string, error) {
 	d.Type = VirtIOSCSIPCIType
 	return serializer.GetCliArgs(d)
 }
+
+// ICH9AHCIDevice is a SATA controller with six ports, each taking an
+// IDECDStorageDevice or IDEHDStorageDevice.
+type ICH9AHCIDevice struct {
+	BaseDevice
+}
+
+func (d ICH9AHCIDevice) GetName() string { return d.Name }
+
+// PortBus returns the name of the bus of the given port, 0 to 5.
+func (d ICH9AHCIDevice) PortBus(port int) string { return fmt.Sprintf("%s.%d", d.Name, port) }
+
+func (d ICH9AHCIDevice) GetCliArgs() ([]string, error) {
+	d.Type = ICH9AHCIType
+	return serializer.GetCliArgs(d)
+}
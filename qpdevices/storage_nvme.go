@@ -0,0 +1,243 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"fmt"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/internal/serializer"
+)
+
+var NVMESubsysType = DeviceType{"nvme-subsys"}
+
+const (
+	// nvmeMaxNamespaces is the number of namespaces QEMU supports per
+	// controller or subsystem.
+	nvmeMaxNamespaces = 256
+
+	// nvmeDefaultZoneSize is the zone size of zoned namespaces
+	// unless set.
+	nvmeDefaultZoneSize = 128 << 20
+)
+
+// NvmeSubsysDevice is an NVMe subsystem, which shares its namespaces
+// among its controllers.
+type NvmeSubsysDevice struct {
+	BaseDevice
+
+	// NQN is the NVMe Qualified Name of the subsystem, by default
+	// derived from its name.
+	NQN string `qp:"name=nqn"`
+}
+
+func (d NvmeSubsysDevice) GetName() string { return d.Name }
+
+func (d NvmeSubsysDevice) GetCliArgs() ([]string, error) {
+	d.Type = NVMESubsysType
+	return serializer.GetCliArgs(d)
+}
+
+// NVMe wires NVMe subsystems, controllers and namespaces, e.g. a
+// namespace shared by two controllers of a subsystem for multipath.
+//
+// GetCliArgs fails unless every controller has a serial and belongs to
+// a subsystem of NVMe if any, every namespace is on a controller of
+// NVMe, namespace IDs are unique and the zone options are consistent.
+type NVMe struct {
+	subsystems  []*NvmeSubsysDevice
+	controllers []*NvmeStorageDevice
+	namespaces  []*NvmeNsStorageDevice
+}
+
+// NewNVMe creates an empty NVMe topology.
+func NewNVMe() *NVMe { return &NVMe{} }
+
+// AddSubsystem adds a subsystem with the given NQN, which can be
+// empty for the default.
+func (n *NVMe) AddSubsystem(name, nqn string) *NvmeSubsysDevice {
+	d := &NvmeSubsysDevice{NQN: nqn}
+	d.Name = name
+	n.subsystems = append(n.subsystems, d)
+	return d
+}
+
+// AddController adds a controller, which belongs to subsys unless it
+// is nil.
+func (n *NVMe) AddController(name, serial string, subsys *NvmeSubsysDevice) *NvmeStorageDevice {
+	d := &NvmeStorageDevice{Serial: serial}
+	d.Name = name
+	if subsys != nil {
+		d.Subsys = Ref(subsys)
+	}
+	n.controllers = append(n.controllers, d)
+	return d
+}
+
+// AddNamespace adds a namespace of the drive on the controller ctrl.
+// In a subsystem, the namespace is shared with the other controllers
+// of the subsystem unless Shared is off.
+func (n *NVMe) AddNamespace(name string, drive Reference, ctrl *NvmeStorageDevice) *NvmeNsStorageDevice {
+	d := &NvmeNsStorageDevice{}
+	d.Name = name
+	d.Drive = drive
+	d.Bus = ctrl.Name
+	n.namespaces = append(n.namespaces, d)
+	return d
+}
+
+func (n *NVMe) validateControllers() error {
+	subsystems := make(map[string]bool, len(n.subsystems))
+	for _, s := range n.subsystems {
+		if s.Name == "" || subsystems[s.Name] {
+			return fmt.Errorf("qpdevices.NVMe: subsystem name %q missing or not unique", s.Name)
+		}
+		subsystems[s.Name] = true
+	}
+
+	controllers := make(map[string]bool, len(n.controllers))
+	for _, c := range n.controllers {
+		if c.Name == "" || controllers[c.Name] {
+			return fmt.Errorf("qpdevices.NVMe: controller name %q missing or not unique", c.Name)
+		}
+		controllers[c.Name] = true
+
+		if c.Serial == "" {
+			return fmt.Errorf("qpdevices.NVMe: controller %s has no serial", c.Name)
+		}
+		if c.Subsys != "" && !subsystems[string(c.Subsys)] {
+			return fmt.Errorf("qpdevices.NVMe: controller %s belongs to unknown subsystem %s", c.Name, c.Subsys)
+		}
+	}
+	return nil
+}
+
+func (n *NVMe) validateNamespaces() error {
+	subsys := make(map[string]string, len(n.controllers))
+	for _, c := range n.controllers {
+		subsys[c.Name] = string(c.Subsys)
+	}
+
+	// Namespace IDs are unique within the subsystem, or the
+	// controller if it has none.
+	type nsidScope struct {
+		name string
+		nsid uint32
+	}
+	nsids := make(map[nsidScope]string)
+	names := make(map[string]bool, len(n.namespaces))
+
+	for _, ns := range n.namespaces {
+		if ns.Name == "" || names[ns.Name] {
+			return fmt.Errorf("qpdevices.NVMe: namespace name %q missing or not unique", ns.Name)
+		}
+		names[ns.Name] = true
+
+		s, ok := subsys[ns.Bus]
+		if !ok {
+			return fmt.Errorf("qpdevices.NVMe: namespace %s is on unknown controller %q", ns.Name, ns.Bus)
+		}
+		if ns.Drive == "" {
+			return fmt.Errorf("qpdevices.NVMe: namespace %s has no drive", ns.Name)
+		}
+		if s == "" && (ns.Shared.OrElse(false) || ns.Detached.OrElse(false)) {
+			return fmt.Errorf("qpdevices.NVMe: namespace %s is shared or detached, but controller %s has no subsystem", ns.Name, ns.Bus)
+		}
+
+		if ns.NSID.IsSome() {
+			nsid := ns.NSID.Yank()
+			if nsid == 0 || nsid > nvmeMaxNamespaces {
+				return fmt.Errorf("qpdevices.NVMe: namespace %s has NSID %d out of range 1 to %d", ns.Name, nsid, nvmeMaxNamespaces)
+			}
+
+			scope := nsidScope{s, nsid}
+			if s == "" {
+				scope.name = "controller " + ns.Bus
+			}
+			if other, ok := nsids[scope]; ok {
+				return fmt.Errorf("qpdevices.NVMe: namespaces %s and %s have the same NSID %d", other, ns.Name, nsid)
+			}
+			nsids[scope] = ns.Name
+		}
+
+		if err := validateZoned(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateZoned(ns *NvmeNsStorageDevice) error {
+	if !ns.Zoned.OrElse(false) {
+		if ns.ZoneSize.IsSome() || ns.ZoneCapacity.IsSome() || ns.MaxOpen.IsSome() ||
+			ns.MaxActive.IsSome() || ns.CrossRead.IsSome() {
+			return fmt.Errorf("qpdevices.NVMe: namespace %s has zone options but is not zoned", ns.Name)
+		}
+		return nil
+	}
+
+	size := ns.ZoneSize.OrElse(nvmeDefaultZoneSize)
+	if size == 0 {
+		return fmt.Errorf("qpdevices.NVMe: namespace %s has zones of 0 bytes", ns.Name)
+	}
+	if capacity := ns.ZoneCapacity.OrElse(size); capacity == 0 || capacity > size {
+		return fmt.Errorf("qpdevices.NVMe: namespace %s has zone capacity %d out of range 1 to zone size %d", ns.Name, capacity, size)
+	}
+
+	open, active := ns.MaxOpen.OrElse(0), ns.MaxActive.OrElse(0)
+	if active != 0 && open > active {
+		return fmt.Errorf("qpdevices.NVMe: namespace %s allows more open zones than %d active ones", ns.Name, active)
+	}
+	return nil
+}
+
+// Validate checks the wiring of the subsystems, controllers and
+// namespaces.
+func (n *NVMe) Validate() error {
+	for _, validate := range []func() error{n.validateControllers, n.validateNamespaces} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *NVMe) GetCliArgs() (out []string, err error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Subsystems come before their controllers, which come before
+	// their namespaces.
+	var devices []libqatapult.Device
+	for _, d := range n.subsystems {
+		devices = append(devices, d)
+	}
+	for _, d := range n.controllers {
+		devices = append(devices, d)
+	}
+	for _, d := range n.namespaces {
+		devices = append(devices, d)
+	}
+
+	for _, d := range devices {
+		args, err := d.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	return out, nil
+}
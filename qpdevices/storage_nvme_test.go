@@ -0,0 +1,150 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+// newNVMe returns a subsystem with two controllers sharing a zoned
+// namespace, and a controller of its own with a private namespace.
+func newNVMe() *qpdevices.NVMe {
+	n := qpdevices.NewNVMe()
+	subsys := n.AddSubsystem("subsys0", "nqn.2014-08.org.example:zns")
+	ctrl0 := n.AddController("nvme0", "path0", subsys)
+	n.AddController("nvme1", "path1", subsys)
+	ctrl2 := n.AddController("nvme2", "local", nil)
+
+	zns := n.AddNamespace("ns0", "zns", ctrl0)
+	zns.NSID = qpoption.Value[uint32](1)
+	zns.Zoned = qpoption.Value(true)
+	zns.ZoneSize = qpoption.Value[uint64](64 << 20)
+	zns.ZoneCapacity = qpoption.Value[uint64](48 << 20)
+	zns.MaxOpen = qpoption.Value[uint32](8)
+	zns.MaxActive = qpoption.Value[uint32](16)
+
+	local := n.AddNamespace("ns1", "local", ctrl2)
+	local.NSID = qpoption.Value[uint32](1)
+	return n
+}
+
+// nvmeController returns a controller to refer to by name.
+func nvmeController(name string) *qpdevices.NvmeStorageDevice {
+	d := &qpdevices.NvmeStorageDevice{}
+	d.Name = name
+	return d
+}
+
+func TestNVMe(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	got, err := qptest.DeviceCliArgs(newNVMe())
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{
+		"-device", "nvme-subsys,id=subsys0,nqn=nqn.2014-08.org.example:zns",
+		"-device", "nvme,id=nvme0,serial=path0,subsys=subsys0",
+		"-device", "nvme,id=nvme1,serial=path1,subsys=subsys0",
+		"-device", "nvme,id=nvme2,serial=local",
+		"-device", "nvme-ns,id=ns0,drive=zns,bus=nvme0,nsid=1,zoned=on,zoned.zone_size=67108864," +
+			"zoned.zone_capacity=50331648,zoned.max_open=8,zoned.max_active=16",
+		"-device", "nvme-ns,id=ns1,drive=local,bus=nvme2,nsid=1",
+	}, got)
+}
+
+func TestNVMe_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(n *qpdevices.NVMe)
+		wantErr string
+	}{
+		{"valid", func(n *qpdevices.NVMe) {}, ""},
+
+		{"no serial", func(n *qpdevices.NVMe) {
+			n.AddController("nvme3", "", nil)
+		}, "controller nvme3 has no serial"},
+
+		{"duplicate controller", func(n *qpdevices.NVMe) {
+			n.AddController("nvme0", "again", nil)
+		}, `controller name "nvme0" missing or not unique`},
+
+		{"unknown subsystem", func(n *qpdevices.NVMe) {
+			c := n.AddController("nvme3", "lost", nil)
+			c.Subsys = "subsys1"
+		}, "controller nvme3 belongs to unknown subsystem subsys1"},
+
+		{"unknown controller", func(n *qpdevices.NVMe) {
+			n.AddNamespace("ns2", "disk", &qpdevices.NvmeStorageDevice{})
+		}, `namespace ns2 is on unknown controller ""`},
+
+		{"no drive", func(n *qpdevices.NVMe) {
+			ctrl := n.AddController("nvme3", "empty", nil)
+			n.AddNamespace("ns2", "", ctrl)
+		}, "namespace ns2 has no drive"},
+
+		{"nsid taken in subsystem", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme1"))
+			ns.NSID = qpoption.Value[uint32](1)
+		}, "namespaces ns0 and ns2 have the same NSID 1"},
+
+		{"nsid out of range", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme1"))
+			ns.NSID = qpoption.Value[uint32](257)
+		}, "namespace ns2 has NSID 257 out of range 1 to 256"},
+
+		{"shared without subsystem", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme2"))
+			ns.Shared = qpoption.Value(true)
+		}, "namespace ns2 is shared or detached, but controller nvme2 has no subsystem"},
+
+		{"zone options without zoned", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme2"))
+			ns.ZoneSize = qpoption.Value[uint64](1 << 20)
+		}, "namespace ns2 has zone options but is not zoned"},
+
+		{"zone capacity", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme2"))
+			ns.Zoned = qpoption.Value(true)
+			ns.ZoneCapacity = qpoption.Value[uint64](256 << 20)
+		}, "namespace ns2 has zone capacity 268435456 out of range 1 to zone size 134217728"},
+
+		{"open zones", func(n *qpdevices.NVMe) {
+			ns := n.AddNamespace("ns2", "disk", nvmeController("nvme2"))
+			ns.Zoned = qpoption.Value(true)
+			ns.MaxOpen = qpoption.Value[uint32](9)
+			ns.MaxActive = qpoption.Value[uint32](8)
+		}, "namespace ns2 allows more open zones than 8 active ones"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNVMe()
+			tt.setup(n)
+
+			err := n.Validate()
+			if tt.wantErr == "" {
+				assertpkg.NoError(t, err)
+			} else {
+				assertpkg.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
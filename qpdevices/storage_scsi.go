@@ -0,0 +1,137 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qatapult/libqatapult"
+
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+const (
+	// virtio-scsi addresses targets 0 to 255 and LUNs 0 to 16383
+	// on a single channel.
+	virtIOSCSIMaxTargets = 256
+	virtIOSCSIMaxLUNs    = 16384
+)
+
+// ErrSCSIAddressed is returned by SCSIAllocator.Assign for devices
+// that have a bus already.
+var ErrSCSIAddressed = errors.New("qpdevices.SCSIAllocator: device has a bus already")
+
+type scsiAllocatorOpts struct {
+	targets, luns uint32
+	template      VirtIOSCSIPCIDevice
+}
+
+type SCSIAllocatorOpt func(o *scsiAllocatorOpts)
+
+// WithSCSITargets limits the number of targets per controller, at
+// most 256, which is also the default.
+func WithSCSITargets(n uint32) SCSIAllocatorOpt {
+	return func(o *scsiAllocatorOpts) { o.targets = n }
+}
+
+// WithSCSILUNs sets the number of LUNs assigned per target before
+// moving on to the next target, at most 16384.  By default every
+// device gets a target of its own.
+func WithSCSILUNs(n uint32) SCSIAllocatorOpt {
+	return func(o *scsiAllocatorOpts) { o.luns = n }
+}
+
+// WithSCSIController sets the controller the allocator creates copies
+// of, e.g. with an IOThread.  Its name is replaced.
+func WithSCSIController(template VirtIOSCSIPCIDevice) SCSIAllocatorOpt {
+	return func(o *scsiAllocatorOpts) { o.template = template }
+}
+
+// SCSIAllocator assigns unique HBTL addresses to SCSI devices and
+// creates virtio-scsi controllers as their buses fill up.  It is a
+// device itself rendering all controllers it created, so it can be
+// added to the VM before assigning the last device.
+type SCSIAllocator struct {
+	prefix string
+	opts   scsiAllocatorOpts
+
+	controllers []VirtIOSCSIPCIDevice
+
+	// next is the index of the next free address, counting LUNs
+	// first, then targets, then controllers.
+	next uint64
+}
+
+// NewSCSIAllocator returns an allocator naming the controllers it
+// creates prefix0, prefix1 and so on.
+func NewSCSIAllocator(prefix string, opts ...SCSIAllocatorOpt) (*SCSIAllocator, error) {
+	cfg := scsiAllocatorOpts{targets: virtIOSCSIMaxTargets, luns: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.targets == 0 || cfg.targets > virtIOSCSIMaxTargets {
+		return nil, fmt.Errorf("qpdevices.SCSIAllocator: %d targets out of range 1 to %d", cfg.targets, virtIOSCSIMaxTargets)
+	}
+	if cfg.luns == 0 || cfg.luns > virtIOSCSIMaxLUNs {
+		return nil, fmt.Errorf("qpdevices.SCSIAllocator: %d LUNs out of range 1 to %d", cfg.luns, virtIOSCSIMaxLUNs)
+	}
+	return &SCSIAllocator{prefix: prefix, opts: cfg}, nil
+}
+
+// Assign gives the device the next free address, creating a new
+// controller if the last one is full, and sets its Bus, Channel,
+// Target and LUN.
+func (a *SCSIAllocator) Assign(d *SCSIStorageDevice) error {
+	if d.Bus != "" {
+		return ErrSCSIAddressed
+	}
+
+	perController := uint64(a.opts.targets) * uint64(a.opts.luns)
+	index := a.next / perController
+	if index == uint64(len(a.controllers)) {
+		c := a.opts.template
+		c.Name = fmt.Sprintf("%s%d", a.prefix, index)
+		a.controllers = append(a.controllers, c)
+	}
+
+	slot := a.next % perController
+	d.Bus = a.controllers[index].BusName()
+	d.Channel = qpoption.Value(uint32(0))
+	d.Target = qpoption.Value(uint32(slot / uint64(a.opts.luns)))
+	d.LUN = qpoption.Value(uint32(slot % uint64(a.opts.luns)))
+	a.next++
+	return nil
+}
+
+var _ libqatapult.Device = &SCSIAllocator{}
+
+// Controllers returns the controllers created so far.  Later calls to
+// Assign may create more, which the returned slice does not include.
+func (a *SCSIAllocator) Controllers() []VirtIOSCSIPCIDevice {
+	return append([]VirtIOSCSIPCIDevice(nil), a.controllers...)
+}
+
+func (a *SCSIAllocator) GetCliArgs() (out []string, err error) {
+	for _, c := range a.controllers {
+		args, err := c.GetCliArgs()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, args...)
+	}
+	return
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+func TestVirtIOBlkStorageDevice(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	d := qpdevices.VirtIOBlkStorageDevice{
+		StorageDevice: qpdevices.StorageDevice{
+			BootableDevice: qpdevices.NewBootableDevice(1),
+			Drive:          "disk0",
+		},
+		Serial:      "root",
+		NumQueues:   qpoption.Value(uint16(4)),
+		QueueSize:   qpoption.Value(uint16(256)),
+		IOThread:    "io0",
+		Discard:     qpoption.Value(true),
+		WriteZeroes: qpoption.Value(false),
+	}
+	d.Name = "vda"
+
+	got, err := d.GetCliArgs()
+	if assert.NoError(err) {
+		assert.Equal([]string{"-device", "virtio-blk-pci,id=vda,bootindex=1,drive=disk0,serial=root," +
+			"num-queues=4,queue-size=256,iothread=io0,discard=on,write-zeroes=off"}, got)
+	}
+}
+
+func TestICH9AHCIDevice(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	ahci := qpdevices.ICH9AHCIDevice{}
+	ahci.Name = "ahci0"
+
+	disk := qpdevices.IDEHDStorageDevice{StorageDevice: qpdevices.StorageDevice{
+		Drive: "disk0",
+		Bus:   ahci.PortBus(2),
+	}}
+
+	got, err := disk.GetCliArgs()
+	if assert.NoError(err) {
+		assert.Equal([]string{"-device", "ide-hd,drive=disk0,bus=ahci0.2"}, got)
+	}
+}
+
+func TestSCSIAllocator(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	a, err := qpdevices.NewSCSIAllocator("scsi",
+		qpdevices.WithSCSITargets(2),
+		qpdevices.WithSCSILUNs(2),
+		qpdevices.WithSCSIController(qpdevices.VirtIOSCSIPCIDevice{IOThread: "io0"}))
+	if !assert.NoError(err) {
+		return
+	}
+	args, err := a.GetCliArgs()
+	if assert.NoError(err) {
+		assert.Empty(args)
+	}
+
+	type hbtl struct {
+		bus                  string
+		channel, target, lun uint32
+	}
+	var got []hbtl
+	for i := 0; i < 5; i++ {
+		d := qpdevices.SCSIHDStorageDevice{}
+		if !assert.NoError(a.Assign(&d.SCSIStorageDevice)) {
+			return
+		}
+		got = append(got, hbtl{d.Bus, d.Channel.Yank(), d.Target.Yank(), d.LUN.Yank()})
+	}
+	assert.Equal([]hbtl{
+		{"scsi0.0", 0, 0, 0},
+		{"scsi0.0", 0, 0, 1},
+		{"scsi0.0", 0, 1, 0},
+		{"scsi0.0", 0, 1, 1},
+		{"scsi1.0", 0, 0, 0},
+	}, got)
+
+	controllers := a.Controllers()
+	if assert.Len(controllers, 2) {
+		assert.Equal("scsi1", controllers[1].Name)
+		assert.Equal(qpdevices.Reference("io0"), controllers[1].IOThread)
+	}
+	args, err = a.GetCliArgs()
+	if assert.NoError(err) {
+		assert.Equal([]string{
+			"-device", "virtio-scsi-pci,id=scsi0,iothread=io0",
+			"-device", "virtio-scsi-pci,id=scsi1,iothread=io0",
+		}, args)
+	}
+
+	d := qpdevices.SCSIStorageDevice{StorageDevice: qpdevices.StorageDevice{Bus: "scsi0.0"}}
+	assert.ErrorIs(a.Assign(&d), qpdevices.ErrSCSIAddressed)
+
+	_, err = qpdevices.NewSCSIAllocator("scsi", qpdevices.WithSCSITargets(257))
+	assert.Error(err)
+	_, err = qpdevices.NewSCSIAllocator("scsi", qpdevices.WithSCSILUNs(0))
+	assert.Error(err)
+}
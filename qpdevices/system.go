@@ -15,6 +15,10 @@
 package qpdevices
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/google/uuid"
 
 	"github.com/qatapult/libqatapult"
@@ -37,9 +41,72 @@ type KVM struct{}
 
 func (d KVM) GetCliArgs() ([]string, error) { return []string{"-enable-kvm"}, nil }
 
-type CPU struct{ Model string }
+const (
+	// CPUHost passes the CPU of the host through, which needs KVM.
+	CPUHost = "host"
+
+	// CPUMax enables all features the accelerator supports.
+	CPUMax = "max"
+)
+
+// CPU selects the CPU model of the guest and changes its features.
+//
+// <https://www.qemu.org/docs/master/system/qemu-cpu-models.html>
+type CPU struct {
+	Model string
+
+	// Enable and Disable list features added to and removed from
+	// the model.  They are rendered as feature=on and feature=off,
+	// which unlike +feature and -feature works on all targets.
+	Enable, Disable []string
 
-func (d CPU) GetCliArgs() ([]string, error) { return []string{"-cpu", d.Model}, nil }
+	// Migratable restricts CPUHost and CPUMax to features that can
+	// be migrated, which is the default.
+	Migratable qpoption.Option[bool]
+
+	// Properties sets other properties of the model, e.g. the
+	// family or model-id of x86 models.
+	Properties map[string]any
+}
+
+func (d CPU) GetCliArgs() ([]string, error) {
+	if d.Model == "" {
+		return nil, fmt.Errorf("qpdevices.CPU: no model")
+	}
+
+	parts := []string{d.Model}
+	for _, f := range d.Enable {
+		parts = append(parts, f+"=on")
+	}
+	for _, f := range d.Disable {
+		parts = append(parts, f+"=off")
+	}
+	if d.Migratable.IsSome() {
+		parts = append(parts, "migratable="+onOff(d.Migratable.Yank()))
+	}
+
+	keys := make([]string, 0, len(d.Properties))
+	for k := range d.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(d.Properties[k])
+		if b, ok := d.Properties[k].(bool); ok {
+			v = onOff(b)
+		}
+		parts = append(parts, k+"="+strings.ReplaceAll(v, ",", ",,"))
+	}
+
+	return []string{"-cpu", strings.Join(parts, ",")}, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
 
 type SMP struct {
 	CPUs, MaxCPUs, Sockets, Dies, Clusters, Cores, Threads qpoption.Option[int] `qp:"opt=smp"`
@@ -53,6 +120,13 @@ type Machine struct {
 	Accelerators  []string              `qp:"name=accel,join=':'"`
 	DumpGuestCore bool                  `qp:"name=dump-guest-core"`
 	HMAT          qpoption.Option[bool] `qp:""`
+
+	// MemoryBackend names the memory backend object providing the
+	// RAM of the guest, whose size has to match RAM.
+	MemoryBackend Reference `qp:"~kebab"`
+
+	// NVDIMM enables support for NVDIMMDevice.
+	NVDIMM qpoption.Option[bool] `qp:"name=nvdimm"`
 }
 
 func (d Machine) GetCliArgs() ([]string, error) { return serializer.GetCliArgs(d) }
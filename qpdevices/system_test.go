@@ -0,0 +1,65 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpdevices_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+)
+
+func TestCPU(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     qpdevices.CPU
+		want    []string
+		wantErr bool
+	}{
+		{"model", qpdevices.CPU{Model: "Skylake-Client"}, []string{"-cpu", "Skylake-Client"}, false},
+
+		{"features", qpdevices.CPU{
+			Model:   "Skylake-Client",
+			Enable:  []string{"vmx", "pcid"},
+			Disable: []string{"hle"},
+		}, []string{"-cpu", "Skylake-Client,vmx=on,pcid=on,hle=off"}, false},
+
+		{"host", qpdevices.CPU{
+			Model:      qpdevices.CPUHost,
+			Migratable: qpoption.Value(false),
+		}, []string{"-cpu", "host,migratable=off"}, false},
+
+		{"properties", qpdevices.CPU{
+			Model:      qpdevices.CPUMax,
+			Properties: map[string]any{"model-id": "Lab, Inc.", "l3-cache": false, "level": 13},
+		}, []string{"-cpu", "max,l3-cache=off,level=13,model-id=Lab,, Inc."}, false},
+
+		{"no model", qpdevices.CPU{Enable: []string{"vmx"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cpu.GetCliArgs()
+			if tt.wantErr {
+				assertpkg.Error(t, err)
+				return
+			}
+			if assertpkg.NoError(t, err) {
+				assertpkg.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
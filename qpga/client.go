@@ -0,0 +1,223 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpga implements a client for the QEMU guest agent, which
+// runs inside of the guest and is reached through a virtio-serial
+// port.
+//
+// <https://www.qemu.org/docs/master/interop/qemu-ga-ref.html>
+package qpga
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed = errors.New("qpga: connection closed")
+)
+
+// delimiter is sent by the guest agent in front of the response to
+// guest-sync-delimited.  It never occurs in valid JSON, so clients
+// send it as well to reset the parser of the agent.
+const delimiter = 0xff
+
+// Error is an error response returned by the guest agent.
+type Error struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("qga: %s: %s", e.Class, e.Desc) }
+
+type (
+	request struct {
+		Execute   string `json:"execute"`
+		Arguments any    `json:"arguments,omitempty"`
+	}
+
+	response struct {
+		Return json.RawMessage `json:"return"`
+		Error  *Error          `json:"error"`
+	}
+)
+
+// Client is a client of the guest agent of a single guest.
+//
+// The agent handles one command at a time and has no notion of
+// sessions, so responses may be left over from a previous client or
+// from a command that timed out.  The client synchronizes with the
+// agent before the first command and after every failure.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	synced bool
+}
+
+func (c *Client) Close() error { return c.conn.Close() }
+
+// Sync resynchronizes the client with the agent, discarding any
+// responses still in flight.
+func (c *Client) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.synced = false
+	return c.sync(ctx)
+}
+
+func (c *Client) sync(ctx context.Context) (err error) {
+	if c.synced {
+		return nil
+	}
+
+	stop := c.bind(ctx)
+	defer func() { err = stop(err) }()
+
+	id := rand.Int63n(1 << 52)
+	req, err := json.Marshal(request{Execute: "guest-sync-delimited", Arguments: map[string]any{"id": id}})
+	if err != nil {
+		return err
+	}
+	req = append(append([]byte{delimiter}, req...), '\n')
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := c.r.ReadBytes(delimiter); err != nil {
+			return err
+		}
+
+		var got int64
+		if err := c.receive(&got); err != nil {
+			var qgaErr *Error
+			if errors.As(err, &qgaErr) {
+				return err
+			}
+			continue
+		}
+		if got == id {
+			c.synced = true
+			return nil
+		}
+	}
+}
+
+// bind applies ctx to the connection until the returned function is
+// called with the result of the operation.
+func (c *Client) bind(ctx context.Context) func(error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	return func(err error) error {
+		stop()
+		_ = c.conn.SetDeadline(time.Time{})
+
+		// The connection deadline may fire slightly before the
+		// context notices its own.
+		if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+			err = ctxErr
+		} else if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = context.DeadlineExceeded
+		}
+		if errors.Is(err, net.ErrClosed) {
+			err = ErrClosed
+		}
+		return err
+	}
+}
+
+// receive reads the next response and decodes its return value into
+// result, unless result is nil.
+func (c *Client) receive(result any) error {
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	line = bytes.TrimLeft(line, "\xff")
+
+	var resp response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Return, result)
+}
+
+// Execute runs command with the given arguments and decodes the
+// return value into result, unless result is nil.
+func (c *Client) Execute(ctx context.Context, command string, arguments, result any) error {
+	return c.execute(ctx, command, arguments, result, true)
+}
+
+func (c *Client) execute(ctx context.Context, command string, arguments, result any, wait bool) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sync(ctx); err != nil {
+		return fmt.Errorf("qga: sync: %w", err)
+	}
+
+	stop := c.bind(ctx)
+	defer func() {
+		if err = stop(err); err != nil {
+			var qgaErr *Error
+			if !errors.As(err, &qgaErr) {
+				c.synced = false
+				err = fmt.Errorf("qga: %s: %w", command, err)
+			}
+		}
+	}()
+
+	req, err := json.Marshal(request{Execute: command, Arguments: arguments})
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		return err
+	}
+
+	if !wait {
+		// Nothing tells whether the command got through.
+		c.synced = false
+		return nil
+	}
+	return c.receive(result)
+}
+
+// NewClient creates a new Client talking to the guest agent on the
+// other end of conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, r: bufio.NewReader(conn)}
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpga_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/socketpair"
+	"github.com/qatapult/libqatapult/qpga"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+type fakeHandle struct {
+	path string
+	off  int
+}
+
+// fakeAgent is a guest agent with an in-memory file system.  Guest
+// programs are simulated by program, which is called on every status
+// poll and returns the output to append and whether it exited.
+type fakeAgent struct {
+	files   map[string][]byte
+	handles map[int]*fakeHandle
+	polls   int
+	program func(poll int) (stdout, stderr string, exited bool)
+
+	// stdout and stderr are the files the program writes to.
+	stdout, stderr string
+}
+
+var redirect = regexp.MustCompile(`exec >'([^']*)' 2>'([^']*)'`)
+
+func (a *fakeAgent) handle(cmd string, args json.RawMessage) (any, bool) {
+	var arg struct {
+		ID     int64    `json:"id"`
+		Path   string   `json:"path"`
+		Mode   string   `json:"mode"`
+		Handle int      `json:"handle"`
+		Count  int      `json:"count"`
+		Buf    []byte   `json:"buf-b64"`
+		Arg    []string `json:"arg"`
+	}
+	_ = json.Unmarshal(args, &arg)
+
+	switch cmd {
+	case "guest-sync-delimited":
+		return arg.ID, true
+	case "guest-shutdown":
+		return nil, false
+	case "guest-file-open":
+		if strings.HasPrefix(arg.Mode, "w") {
+			a.files[arg.Path] = []byte{}
+		}
+		h := len(a.handles) + 1
+		a.handles[h] = &fakeHandle{path: arg.Path}
+		return h, true
+	case "guest-file-read":
+		h := a.handles[arg.Handle]
+		data := a.files[h.path][h.off:]
+		if len(data) > arg.Count {
+			data = data[:arg.Count]
+		}
+		h.off += len(data)
+		return map[string]any{"count": len(data), "buf-b64": data, "eof": h.off == len(a.files[h.path])}, true
+	case "guest-file-write":
+		h := a.handles[arg.Handle]
+		a.files[h.path] = append(a.files[h.path], arg.Buf...)
+		return map[string]any{"count": len(arg.Buf), "eof": false}, true
+	case "guest-file-seek":
+		return map[string]any{"position": a.handles[arg.Handle].off, "eof": false}, true
+	case "guest-file-close":
+		delete(a.handles, arg.Handle)
+		return map[string]any{}, true
+	case "guest-exec":
+		if m := redirect.FindStringSubmatch(strings.Join(arg.Arg, " ")); m != nil {
+			a.stdout, a.stderr = m[1], m[2]
+		}
+		return map[string]any{"pid": 42}, true
+	case "guest-exec-status":
+		a.polls++
+		out, errOut, exited := a.program(a.polls)
+		a.files[a.stdout] = append(a.files[a.stdout], out...)
+		a.files[a.stderr] = append(a.files[a.stderr], errOut...)
+		return map[string]any{"exited": exited, "exitcode": 3}, true
+	case "guest-fsfreeze-status":
+		return "frozen", true
+	}
+	return map[string]any{}, true
+}
+
+func (a *fakeAgent) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	// A response left over from a previous client.
+	fmt.Fprintln(conn, `{"return": {}}`)
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		line = bytes.TrimLeft(line, "\xff")
+
+		var req struct {
+			Execute   string          `json:"execute"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if !assertpkg.NoError(t, json.Unmarshal(line, &req)) {
+			return
+		}
+
+		ret, respond := a.handle(req.Execute, req.Arguments)
+		if !respond {
+			continue
+		}
+		b, _ := json.Marshal(map[string]any{"return": ret})
+		if req.Execute == "guest-sync-delimited" {
+			b = append([]byte{0xff}, b...)
+		}
+		if _, err := conn.Write(append(b, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+func newClient(t *testing.T, a *fakeAgent) *qpga.Client {
+	if a.files == nil {
+		a.files, a.handles = map[string][]byte{}, map[int]*fakeHandle{}
+	}
+	// Unlike net.Pipe, a socket pair buffers like the real thing.
+	lf, rf, err := socketpair.New("qga", unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	defer rf.Close()
+
+	l, err := net.FileConn(lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := net.FileConn(rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go a.serve(t, r)
+	t.Cleanup(func() { _ = l.Close() })
+	return qpga.NewClient(l)
+}
+
+func TestClient_Sync(t *testing.T) {
+	assert := assertpkg.New(t)
+	c := newClient(t, &fakeAgent{})
+	ctx := context.Background()
+
+	assert.NoError(c.Ping(ctx))
+
+	// The agent does not respond to shutdown, which must not
+	// confuse later commands.
+	assert.NoError(c.Shutdown(ctx, qpga.ShutdownPowerdown))
+	s, err := c.FSFreezeStatus(ctx)
+	assert.NoError(err)
+	assert.Equal(qpga.FSFrozen, s)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	c := qpga.NewClient(l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assertpkg.ErrorIs(t, c.Ping(ctx), context.DeadlineExceeded)
+}
+
+func TestClient_Files(t *testing.T) {
+	assert := assertpkg.New(t)
+	c := newClient(t, &fakeAgent{})
+	ctx := context.Background()
+
+	assert.NoError(c.WriteFile(ctx, "/etc/motd", []byte("hello")))
+	data, err := c.ReadFile(ctx, "/etc/motd")
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+}
+
+func TestClient_Run(t *testing.T) {
+	assert := assertpkg.New(t)
+	c := newClient(t, &fakeAgent{program: func(poll int) (string, string, bool) {
+		switch poll {
+		case 1:
+			return "one\n", "", false
+		case 2:
+			return "two\n", "oops\n", false
+		}
+		return "three\n", "", true
+	}})
+
+	var stdout, stderr bytes.Buffer
+	s, err := c.Run(context.Background(), &qpga.Command{Path: "/bin/true"}, &stdout, &stderr)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(s.Exited)
+	assert.Equal(3, s.ExitCode)
+	assert.False(s.Success())
+	assert.Equal("one\ntwo\nthree\n", stdout.String())
+	assert.Equal("oops\n", stderr.String())
+}
+
+func TestDevice_GetCliArgs(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	d, err := qpga.NewDevice("qga0")
+	if !assert.NoError(err) {
+		return
+	}
+
+	got, err := qptest.DeviceCliArgs(d)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{
+		"-chardev", "socket,id=qga0,fd=3",
+		"-device", "virtio-serial-pci,id=qga0-serial0",
+		"-device", "virtserialport,bus=qga0-serial0.0,nr=1,chardev=qga0,name=org.qemu.guest_agent.0",
+	}, got)
+}
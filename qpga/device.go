@@ -0,0 +1,59 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpga
+
+import (
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+)
+
+// PortName is the name of the virtio-serial port the guest agent
+// listens on by default.
+const PortName = "org.qemu.guest_agent.0"
+
+// Device connects the guest agent to the host through a Conduit
+// exposed to the guest as a virtio-serial port.
+type Device struct {
+	port   *qpdevices.PortConduit
+	client *Client
+}
+
+func (d *Device) GetName() string { return d.port.GetName() }
+
+// Client returns the client talking to the guest agent.
+func (d *Device) Client() *Client { return d.client }
+
+func (d *Device) GetFiles() []libqatapult.File  { return d.port.GetFiles() }
+func (d *Device) GetCliArgs() ([]string, error) { return d.port.GetCliArgs() }
+
+func newDevice(port *qpdevices.PortConduit, err error) (*Device, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Device{port: port, client: NewClient(port.Conn())}, nil
+}
+
+// NewDevice creates a guest agent device whose chardev is called
+// name, together with a virtio-serial controller of its own.
+func NewDevice(name string) (*Device, error) {
+	return newDevice(qpdevices.NewPortConduit(name, PortName))
+}
+
+// NewDeviceOn creates a guest agent device whose chardev is called
+// name, with its port on bus.  The bus has to be added to the VM
+// separately.
+func NewDeviceOn(bus *qpdevices.VirtIOSerialBus, name string) (*Device, error) {
+	return newDevice(qpdevices.NewPortConduitOn(bus, name, PortName))
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpga
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// pollInterval is the interval the state of guest processes is
+// polled in, as the agent does not notify about them.
+const pollInterval = 50 * time.Millisecond
+
+// Command describes a program to run in the guest.
+type Command struct {
+	Path string
+	Args []string
+
+	// Env replaces the environment of the program if set.
+	Env []string
+
+	// Input is passed to the program on stdin.
+	Input []byte
+}
+
+// ExecStatus describes the state of a program started with Exec.
+type ExecStatus struct {
+	Exited   bool `json:"exited"`
+	ExitCode int  `json:"exitcode"`
+	Signal   int  `json:"signal"`
+
+	// Stdout and Stderr hold the captured output of the program,
+	// which the agent limits in size.
+	Stdout          []byte `json:"out-data"`
+	Stderr          []byte `json:"err-data"`
+	StdoutTruncated bool   `json:"out-truncated"`
+	StderrTruncated bool   `json:"err-truncated"`
+}
+
+// Success reports whether the program exited with exit code zero.
+func (s *ExecStatus) Success() bool { return s.Exited && s.ExitCode == 0 && s.Signal == 0 }
+
+// Exec starts cmd in the guest and returns its pid.  If capture is
+// set, the output of the program is returned by ExecStatus once it
+// exited.
+func (c *Client) Exec(ctx context.Context, cmd *Command, capture bool) (int, error) {
+	args := map[string]any{"path": cmd.Path, "capture-output": capture}
+	if len(cmd.Args) > 0 {
+		args["arg"] = cmd.Args
+	}
+	if cmd.Env != nil {
+		args["env"] = cmd.Env
+	}
+	if cmd.Input != nil {
+		args["input-data"] = cmd.Input
+	}
+
+	var res struct {
+		Pid int `json:"pid"`
+	}
+	if err := c.Execute(ctx, "guest-exec", args, &res); err != nil {
+		return 0, err
+	}
+	return res.Pid, nil
+}
+
+// ExecStatus returns the state of the program with the given pid.
+func (c *Client) ExecStatus(ctx context.Context, pid int) (*ExecStatus, error) {
+	var s ExecStatus
+	if err := c.Execute(ctx, "guest-exec-status", map[string]any{"pid": pid}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Wait waits for the program with the given pid to exit.
+func (c *Client) Wait(ctx context.Context, pid int) (*ExecStatus, error) {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		s, err := c.ExecStatus(ctx, pid)
+		if err != nil || s.Exited {
+			return s, err
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Output runs cmd and returns its status including its output.
+func (c *Client) Output(ctx context.Context, cmd *Command) (*ExecStatus, error) {
+	pid, err := c.Exec(ctx, cmd, true)
+	if err != nil {
+		return nil, err
+	}
+	return c.Wait(ctx, pid)
+}
+
+// Run runs cmd and streams its output to stdout and stderr while it
+// is running, unlike Output, which only returns the output once the
+// program exited.
+//
+// The output is passed through temporary files in /tmp of the guest,
+// which requires a POSIX shell at /bin/sh.
+func (c *Client) Run(ctx context.Context, cmd *Command, stdout, stderr io.Writer) (_ *ExecStatus, err error) {
+	prefix := fmt.Sprintf("/tmp/qatapult-exec-%016x", rand.Uint64())
+
+	outF, err := c.OpenFile(ctx, prefix+".out", "w+")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = multierr.Append(err, outF.Close(ctx)) }()
+
+	errF, err := c.OpenFile(ctx, prefix+".err", "w+")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = multierr.Append(err, errF.Close(ctx)) }()
+
+	// The program inherits the files from the shell, which unlinks
+	// them right away.  The agent keeps reading through its handles.
+	script := fmt.Sprintf(`exec >'%[1]s.out' 2>'%[1]s.err' && rm -f '%[1]s.out' '%[1]s.err' && exec "$@"`, prefix)
+	pid, err := c.Exec(ctx, &Command{
+		Path:  "/bin/sh",
+		Args:  append([]string{"-c", script, "sh", cmd.Path}, cmd.Args...),
+		Env:   cmd.Env,
+		Input: cmd.Input,
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		s, err := c.ExecStatus(ctx, pid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Whatever was written before the program exited is read
+		// after it exited, so nothing is lost.
+		if err := outF.copyTo(ctx, stdout); err != nil {
+			return nil, err
+		}
+		if err := errF.copyTo(ctx, stderr); err != nil {
+			return nil, err
+		}
+		if s.Exited {
+			return s, nil
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// copyTo copies the remaining contents of the file to w.
+func (f *File) copyTo(ctx context.Context, w io.Writer) error {
+	for {
+		p, err := f.Read(ctx, maxChunk)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if w != nil {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The end of file indicator of the stream in the agent is
+	// sticky, seeking resets it so the file can grow further.
+	_, err := f.Seek(ctx, 0, io.SeekCurrent)
+	return err
+}
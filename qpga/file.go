@@ -0,0 +1,137 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpga
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"go.uber.org/multierr"
+)
+
+// maxChunk bounds the amount of data moved by a single read or write
+// command, which has to fit into a single message.
+const maxChunk = 1 << 20
+
+// File is a file opened in the guest.
+type File struct {
+	c      *Client
+	handle int
+}
+
+// OpenFile opens the file at path in the guest with the given fopen
+// mode, e.g. "r" or "w+".
+func (c *Client) OpenFile(ctx context.Context, path, mode string) (*File, error) {
+	f := &File{c: c}
+	err := c.Execute(ctx, "guest-file-open", map[string]any{"path": path, "mode": mode}, &f.handle)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Read reads up to n bytes from the file.  It returns io.EOF once
+// the end of the file was reached and no data was read.
+func (f *File) Read(ctx context.Context, n int) ([]byte, error) {
+	var res struct {
+		Count int    `json:"count"`
+		Data  []byte `json:"buf-b64"`
+		EOF   bool   `json:"eof"`
+	}
+	err := f.c.Execute(ctx, "guest-file-read", map[string]any{"handle": f.handle, "count": n}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Count == 0 && res.EOF {
+		return nil, io.EOF
+	}
+	return res.Data, nil
+}
+
+// Write writes p to the file.
+func (f *File) Write(ctx context.Context, p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+
+		var res struct {
+			Count int `json:"count"`
+		}
+		err := f.c.Execute(ctx, "guest-file-write", map[string]any{"handle": f.handle, "buf-b64": chunk}, &res)
+		if err != nil {
+			return n, err
+		}
+		if res.Count == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += res.Count
+		p = p[res.Count:]
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the file like io.Seeker.
+func (f *File) Seek(ctx context.Context, offset int64, whence int) (int64, error) {
+	var res struct {
+		Position int64 `json:"position"`
+	}
+	err := f.c.Execute(ctx, "guest-file-seek", map[string]any{"handle": f.handle, "offset": offset, "whence": whence}, &res)
+	return res.Position, err
+}
+
+// Flush flushes buffered data of the file in the guest.
+func (f *File) Flush(ctx context.Context) error {
+	return f.c.Execute(ctx, "guest-file-flush", map[string]any{"handle": f.handle}, nil)
+}
+
+func (f *File) Close(ctx context.Context) error {
+	return f.c.Execute(ctx, "guest-file-close", map[string]any{"handle": f.handle}, nil)
+}
+
+// ReadFile reads the whole file at path from the guest.
+func (c *Client) ReadFile(ctx context.Context, path string) (_ []byte, err error) {
+	f, err := c.OpenFile(ctx, path, "r")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = multierr.Append(err, f.Close(ctx)) }()
+
+	var buf bytes.Buffer
+	for {
+		p, err := f.Read(ctx, maxChunk)
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		buf.Write(p)
+	}
+}
+
+// WriteFile writes data to the file at path in the guest, creating
+// it if necessary.
+func (c *Client) WriteFile(ctx context.Context, path string, data []byte) (err error) {
+	f, err := c.OpenFile(ctx, path, "w")
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, f.Close(ctx)) }()
+
+	_, err = f.Write(ctx, data)
+	return err
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpga
+
+import (
+	"context"
+)
+
+// Ping checks whether the guest agent is responsive.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Execute(ctx, "guest-ping", nil, nil)
+}
+
+// Info describes the guest agent.
+type Info struct {
+	Version  string `json:"version"`
+	Commands []struct {
+		Name            string `json:"name"`
+		Enabled         bool   `json:"enabled"`
+		SuccessResponse bool   `json:"success-response"`
+	} `json:"supported_commands"`
+}
+
+// Info returns the version and supported commands of the agent.
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	var info Info
+	if err := c.Execute(ctx, "guest-info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ShutdownMode tells the guest how to shut down.
+type ShutdownMode string
+
+const (
+	ShutdownPowerdown ShutdownMode = "powerdown"
+	ShutdownHalt      ShutdownMode = "halt"
+	ShutdownReboot    ShutdownMode = "reboot"
+)
+
+// Shutdown asks the guest to shut down.  The agent does not respond
+// to the command, whether it worked can only be told by watching
+// the VM.
+func (c *Client) Shutdown(ctx context.Context, mode ShutdownMode) error {
+	return c.execute(ctx, "guest-shutdown", map[string]any{"mode": mode}, nil, false)
+}
+
+// FSFreezeStatus is the state of the guest file systems.
+type FSFreezeStatus string
+
+const (
+	FSThawed FSFreezeStatus = "thawed"
+	FSFrozen FSFreezeStatus = "frozen"
+)
+
+// FSFreeze freezes all freezable guest file systems and returns how
+// many were frozen.
+func (c *Client) FSFreeze(ctx context.Context) (n int, err error) {
+	err = c.Execute(ctx, "guest-fsfreeze-freeze", nil, &n)
+	return n, err
+}
+
+// FSThaw thaws all frozen guest file systems and returns how many
+// were thawed.
+func (c *Client) FSThaw(ctx context.Context) (n int, err error) {
+	err = c.Execute(ctx, "guest-fsfreeze-thaw", nil, &n)
+	return n, err
+}
+
+// FSFreezeStatus returns whether the guest file systems are frozen.
+func (c *Client) FSFreezeStatus(ctx context.Context) (s FSFreezeStatus, err error) {
+	err = c.Execute(ctx, "guest-fsfreeze-status", nil, &s)
+	return s, err
+}
+
+// IPAddress is an address assigned to a guest network interface.
+type IPAddress struct {
+	Type    string `json:"ip-address-type"`
+	Address string `json:"ip-address"`
+	Prefix  int    `json:"prefix"`
+}
+
+// NetworkInterface describes a guest network interface.
+type NetworkInterface struct {
+	Name            string      `json:"name"`
+	HardwareAddress string      `json:"hardware-address"`
+	IPAddresses     []IPAddress `json:"ip-addresses"`
+	Statistics      *struct {
+		RxBytes   uint64 `json:"rx-bytes"`
+		RxPackets uint64 `json:"rx-packets"`
+		RxErrs    uint64 `json:"rx-errs"`
+		RxDropped uint64 `json:"rx-dropped"`
+		TxBytes   uint64 `json:"tx-bytes"`
+		TxPackets uint64 `json:"tx-packets"`
+		TxErrs    uint64 `json:"tx-errs"`
+		TxDropped uint64 `json:"tx-dropped"`
+	} `json:"statistics"`
+}
+
+// NetworkInterfaces lists the network interfaces of the guest.
+func (c *Client) NetworkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	var ifaces []NetworkInterface
+	if err := c.Execute(ctx, "guest-network-get-interfaces", nil, &ifaces); err != nil {
+		return nil, err
+	}
+	return ifaces, nil
+}
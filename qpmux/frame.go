@@ -0,0 +1,89 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	protoVersion = 0
+	headerSize   = 12
+
+	// initialWindow is the receive window every stream starts
+	// with, before any window updates.
+	initialWindow = 256 << 10
+
+	// maxFrameSize limits the payload of data frames, so streams
+	// share the connection fairly.
+	maxFrameSize = 16 << 10
+)
+
+type frameType uint8
+
+const (
+	typeData frameType = iota
+	typeWindowUpdate
+	typePing
+	typeGoAway
+)
+
+const (
+	flagSYN uint16 = 1 << iota
+	flagACK
+	flagFIN
+	flagRST
+)
+
+// header precedes every frame.  For data frames length is the size of
+// the payload, for window updates the window increment, for pings an
+// opaque value echoed back and for go away frames an error code.
+type header struct {
+	typ    frameType
+	flags  uint16
+	stream uint32
+	length uint32
+}
+
+func (h header) String() string {
+	return fmt.Sprintf("frame(type=%d flags=%#x stream=%d length=%d)", h.typ, h.flags, h.stream, h.length)
+}
+
+func (h header) encode() []byte {
+	b := make([]byte, headerSize)
+	b[0] = protoVersion
+	b[1] = byte(h.typ)
+	binary.BigEndian.PutUint16(b[2:], h.flags)
+	binary.BigEndian.PutUint32(b[4:], h.stream)
+	binary.BigEndian.PutUint32(b[8:], h.length)
+	return b
+}
+
+func readHeader(r io.Reader, buf []byte) (h header, err error) {
+	if _, err := io.ReadFull(r, buf[:headerSize]); err != nil {
+		return h, err
+	}
+	if buf[0] != protoVersion {
+		return h, fmt.Errorf("%w: version %d", ErrProtocol, buf[0])
+	}
+	return header{
+		typ:    frameType(buf[1]),
+		flags:  binary.BigEndian.Uint16(buf[2:]),
+		stream: binary.BigEndian.Uint32(buf[4:]),
+		length: binary.BigEndian.Uint32(buf[8:]),
+	}, nil
+}
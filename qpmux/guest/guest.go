@@ -0,0 +1,45 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package guest is the guest side of qpmux sessions, for agents
+// running inside a VM set up with the qpmux/host package.
+//
+// It has no dependencies beyond qpmux, to keep agents small.
+package guest
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/qatapult/libqatapult/qpmux"
+)
+
+// PortDir is where Linux creates the named virtio-serial ports.
+const PortDir = "/dev/virtio-ports"
+
+// Listen opens the virtio-serial port called portName and returns
+// the guest side of the session, to accept the streams the host opens
+// or to open streams to the host.
+func Listen(portName string, opts ...qpmux.Option) (*qpmux.Session, error) {
+	return ListenPath(filepath.Join(PortDir, portName), opts...)
+}
+
+// ListenPath is like Listen, but opens the port device at path.
+func ListenPath(path string, opts ...qpmux.Option) (*qpmux.Session, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return qpmux.Server(f, opts...), nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package host connects qpmux sessions to guests through Conduits
+// exposed as virtio-serial ports.
+package host
+
+import (
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpmux"
+)
+
+// Device is the host side of a qpmux session with the guest, whose
+// agent opens the virtio-serial port with the qpmux/guest package.
+type Device struct {
+	port    *qpdevices.PortConduit
+	session *qpmux.Session
+}
+
+func (d *Device) GetName() string { return d.port.GetName() }
+
+// Session returns the session with the guest.  Streams can be opened
+// right away, they are accepted once the guest agent is up.
+func (d *Device) Session() *qpmux.Session { return d.session }
+
+func (d *Device) GetFiles() []libqatapult.File  { return d.port.GetFiles() }
+func (d *Device) GetCliArgs() ([]string, error) { return d.port.GetCliArgs() }
+
+func newDevice(port *qpdevices.PortConduit, err error, opts []qpmux.Option) (*Device, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Device{port: port, session: qpmux.Client(port.Conn(), opts...)}, nil
+}
+
+// NewDevice creates a device whose chardev is called name, with a
+// virtio-serial port called portName on a controller of its own.
+func NewDevice(name, portName string, opts ...qpmux.Option) (*Device, error) {
+	port, err := qpdevices.NewPortConduit(name, portName)
+	return newDevice(port, err, opts)
+}
+
+// NewDeviceOn creates a device whose chardev is called name, with a
+// port called portName on bus.  The bus has to be added to the VM
+// separately.
+func NewDeviceOn(bus *qpdevices.VirtIOSerialBus, name, portName string, opts ...qpmux.Option) (*Device, error) {
+	port, err := qpdevices.NewPortConduitOn(bus, name, portName)
+	return newDevice(port, err, opts)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package host_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpmux"
+	"github.com/qatapult/libqatapult/qpmux/host"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestDevice_GetCliArgs(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	d, err := host.NewDevice("mux0", "org.example.agent")
+	if !assert.NoError(err) {
+		return
+	}
+	defer d.Session().Close()
+
+	got, err := qptest.DeviceCliArgs(d)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{
+		"-chardev", "socket,id=mux0,fd=3",
+		"-device", "virtio-serial-pci,id=mux0-serial0",
+		"-device", "virtserialport,bus=mux0-serial0.0,nr=1,chardev=mux0,name=org.example.agent",
+	}, got)
+}
+
+func TestDevice_Session(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	d, err := host.NewDevice("mux0", "org.example.agent")
+	if !assert.NoError(err) {
+		return
+	}
+	defer d.Session().Close()
+
+	// The end of the conduit QEMU would get stands in for the guest.
+	conn, err := net.FileConn(d.GetFiles()[0].GetHandle())
+	if !assert.NoError(err) {
+		return
+	}
+	guest := qpmux.Server(conn)
+	defer guest.Close()
+
+	go func() {
+		st, err := guest.AcceptStream()
+		if err != nil {
+			return
+		}
+		defer st.Close()
+		_, _ = io.Copy(st, st)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := d.Session().Open(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+	defer st.Close()
+
+	_, err = st.Write([]byte("hello"))
+	assert.NoError(err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(st, buf)
+	assert.NoError(err)
+	assert.Equal("hello", string(buf))
+}
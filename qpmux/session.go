@@ -0,0 +1,514 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpmux multiplexes many reliable, flow controlled streams
+// over a single connection, such as a Conduit exposed to the guest as
+// a virtio-serial port.
+//
+// A Session is a net.Listener for the streams the peer opens, and
+// DialContext opens streams to the peer, so that protocols such as
+// gRPC or net/rpc run on top of it.  The host side of a session is
+// usually created with the qpmux/host package and the guest side with
+// the qpmux/guest package.
+//
+// Sessions do not survive either side reconnecting, e.g. a guest
+// agent restarting, as data in flight is lost.
+package qpmux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrSessionClosed    = errors.New("qpmux: session closed")
+	ErrStreamReset      = errors.New("qpmux: stream reset by peer")
+	ErrStreamClosed     = errors.New("qpmux: stream closed")
+	ErrRefused          = errors.New("qpmux: stream refused by peer")
+	ErrGoAway           = errors.New("qpmux: session closed by peer")
+	ErrKeepAliveTimeout = errors.New("qpmux: keepalive timeout")
+	ErrProtocol         = errors.New("qpmux: protocol error")
+)
+
+type sessionOpts struct {
+	window       uint32
+	backlog      int
+	keepAlive    time.Duration
+	keepAliveTTL time.Duration
+}
+
+type Option func(o *sessionOpts)
+
+// WithWindowSize sets the receive window of every stream, which
+// bounds the data buffered for a stream nobody reads.  It cannot be
+// smaller than the initial window of 256 KiB.
+func WithWindowSize(size uint32) Option {
+	return func(o *sessionOpts) {
+		if size > initialWindow {
+			o.window = size
+		}
+	}
+}
+
+// WithAcceptBacklog sets the number of streams opened by the peer
+// waiting to be accepted, beyond which new streams are refused.
+func WithAcceptBacklog(n int) Option { return func(o *sessionOpts) { o.backlog = n } }
+
+// WithKeepAlive pings the peer every interval and closes the session
+// with ErrKeepAliveTimeout if a ping is not answered within timeout.
+// Pings only start once the peer is seen, so a session may be created
+// before the guest is up.  Keepalives are off unless this option is
+// given; an interval of zero turns them off again.
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(o *sessionOpts) { o.keepAlive, o.keepAliveTTL = interval, timeout }
+}
+
+// Addr is the address of a session or stream.
+type Addr struct {
+	// Stream is the id of the stream, zero for the session.
+	Stream uint32
+}
+
+func (a Addr) Network() string { return "qpmux" }
+
+func (a Addr) String() string {
+	if a.Stream == 0 {
+		return "qpmux"
+	}
+	return fmt.Sprintf("qpmux:%d", a.Stream)
+}
+
+type writeRequest struct {
+	hdr     header
+	payload []byte
+	done    chan error
+}
+
+// Session multiplexes streams over a connection.
+type Session struct {
+	conn io.ReadWriteCloser
+	opts sessionOpts
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	pings   map[uint32]chan struct{}
+	pingID  uint32
+
+	acceptCh chan *Stream
+
+	// Control frames are queued without bound, so the receiving
+	// goroutine never blocks on the connection.
+	ctrlMu     sync.Mutex
+	ctrl       []header
+	ctrlSignal chan struct{}
+	dataCh     chan writeRequest
+
+	seen   chan struct{}
+	doneCh chan struct{}
+	err    atomic.Pointer[error]
+	once   sync.Once
+}
+
+// Client creates the session of the side that initiated the
+// connection, usually the host.
+func Client(conn io.ReadWriteCloser, opts ...Option) *Session { return newSession(conn, 1, opts) }
+
+// Server creates the session of the side that accepted the
+// connection, usually the guest.
+func Server(conn io.ReadWriteCloser, opts ...Option) *Session { return newSession(conn, 2, opts) }
+
+func newSession(conn io.ReadWriteCloser, firstID uint32, opts []Option) *Session {
+	o := sessionOpts{
+		window:  initialWindow,
+		backlog: 64,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := &Session{
+		conn:       conn,
+		opts:       o,
+		streams:    map[uint32]*Stream{},
+		nextID:     firstID,
+		pings:      map[uint32]chan struct{}{},
+		acceptCh:   make(chan *Stream, o.backlog),
+		ctrlSignal: make(chan struct{}, 1),
+		dataCh:     make(chan writeRequest),
+		seen:       make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go s.receive()
+	go s.send()
+	if o.keepAlive > 0 {
+		go s.keepAlive()
+	}
+	return s
+}
+
+// Done is closed once the session terminated.
+func (s *Session) Done() <-chan struct{} { return s.doneCh }
+
+// Err returns the reason the session terminated.
+func (s *Session) Err() error {
+	if err := s.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Addr implements net.Listener.
+func (s *Session) Addr() net.Addr { return Addr{} }
+
+// Close tells the peer the session goes away and closes the
+// connection, which ends all streams.
+func (s *Session) Close() error {
+	// The sending goroutine shuts down after writing the frame,
+	// unless it is stuck on a peer not reading.
+	s.queue(header{typ: typeGoAway})
+	select {
+	case <-s.doneCh:
+	case <-time.After(time.Second):
+		s.shutdown(ErrSessionClosed)
+	}
+	return nil
+}
+
+func (s *Session) shutdown(err error) {
+	s.once.Do(func() {
+		s.err.Store(&err)
+		close(s.doneCh)
+		_ = s.conn.Close()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, st := range s.streams {
+			st.notify()
+		}
+	})
+}
+
+// Accept waits for and returns the next stream opened by the peer.
+func (s *Session) Accept() (net.Conn, error) { return s.AcceptStream() }
+
+// AcceptStream is like Accept, but returns a *Stream.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.acceptCh:
+		return st, nil
+	case <-s.doneCh:
+		return nil, s.Err()
+	}
+}
+
+// DialContext opens a stream to the peer, ignoring addr.  Its
+// signature matches the dialer of grpc.WithContextDialer.
+func (s *Session) DialContext(ctx context.Context, _ string) (net.Conn, error) {
+	return s.Open(ctx)
+}
+
+// Open opens a new stream and waits for the peer to accept it into
+// its backlog.
+func (s *Session) Open(ctx context.Context) (*Stream, error) {
+	s.mu.Lock()
+	select {
+	case <-s.doneCh:
+		s.mu.Unlock()
+		return nil, s.Err()
+	default:
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	s.queue(header{typ: typeWindowUpdate, flags: flagSYN, stream: id, length: s.opts.window - initialWindow})
+
+	select {
+	case <-st.established:
+	case <-s.doneCh:
+		return nil, s.Err()
+	case <-ctx.Done():
+		st.abort()
+		return nil, ctx.Err()
+	}
+
+	st.mu.Lock()
+	refused := st.refused
+	st.mu.Unlock()
+	if refused {
+		s.remove(id)
+		return nil, ErrRefused
+	}
+	return st, nil
+}
+
+// Ping sends a ping to the peer and returns the round trip time.
+func (s *Session) Ping(ctx context.Context) (time.Duration, error) {
+	ch := make(chan struct{})
+
+	s.mu.Lock()
+	s.pingID++
+	id := s.pingID
+	s.pings[id] = ch
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.pings, id)
+	}()
+
+	start := time.Now()
+	s.queue(header{typ: typePing, flags: flagSYN, length: id})
+
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-s.doneCh:
+		return 0, s.Err()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (s *Session) keepAlive() {
+	select {
+	case <-s.seen:
+	case <-s.doneCh:
+		return
+	}
+
+	t := time.NewTicker(s.opts.keepAlive)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.opts.keepAliveTTL)
+			_, err := s.Ping(ctx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) {
+				s.shutdown(ErrKeepAliveTimeout)
+				return
+			}
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// queue sends a control frame without waiting for it to be written.
+func (s *Session) queue(h header) {
+	s.ctrlMu.Lock()
+	s.ctrl = append(s.ctrl, h)
+	s.ctrlMu.Unlock()
+
+	select {
+	case s.ctrlSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) popControl() (header, bool) {
+	s.ctrlMu.Lock()
+	defer s.ctrlMu.Unlock()
+
+	if len(s.ctrl) == 0 {
+		return header{}, false
+	}
+	h := s.ctrl[0]
+	s.ctrl = s.ctrl[1:]
+	return h, true
+}
+
+// writeData sends a data frame and waits for it to be written.
+func (s *Session) writeData(h header, payload []byte, deadline <-chan struct{}) error {
+	req := writeRequest{hdr: h, payload: payload, done: make(chan error, 1)}
+	select {
+	case s.dataCh <- req:
+	case <-s.doneCh:
+		return s.Err()
+	case <-deadline:
+		return errTimeout
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-s.doneCh:
+		return s.Err()
+	}
+}
+
+func (s *Session) send() {
+	for s.flushControl() {
+		select {
+		case <-s.ctrlSignal:
+		case req := <-s.dataCh:
+			// Control frames queued before the data, such as the ACK
+			// of an accepted stream, have to go out first.
+			if !s.flushControl() {
+				req.done <- s.Err()
+				return
+			}
+			_, err := s.conn.Write(append(req.hdr.encode(), req.payload...))
+			req.done <- err
+			if err != nil {
+				s.shutdown(err)
+				return
+			}
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+// flushControl writes the queued control frames and reports whether
+// the session is still up.
+func (s *Session) flushControl() bool {
+	for {
+		h, ok := s.popControl()
+		if !ok {
+			return true
+		}
+		if _, err := s.conn.Write(h.encode()); err != nil {
+			s.shutdown(err)
+			return false
+		}
+		if h.typ == typeGoAway {
+			s.shutdown(ErrSessionClosed)
+			return false
+		}
+	}
+}
+
+func (s *Session) receive() {
+	buf := make([]byte, headerSize)
+	var seen bool
+	for {
+		h, err := readHeader(s.conn, buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				err = ErrSessionClosed
+			}
+			s.shutdown(err)
+			return
+		}
+		if !seen {
+			seen = true
+			close(s.seen)
+		}
+
+		switch h.typ {
+		case typeData, typeWindowUpdate:
+			err = s.handleStream(h)
+		case typePing:
+			s.handlePing(h)
+		case typeGoAway:
+			s.shutdown(ErrGoAway)
+			return
+		default:
+			err = fmt.Errorf("%w: unknown %s", ErrProtocol, h)
+		}
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handlePing(h header) {
+	if h.flags&flagSYN != 0 {
+		s.queue(header{typ: typePing, flags: flagACK, length: h.length})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, found := s.pings[h.length]; found {
+		close(ch)
+		delete(s.pings, h.length)
+	}
+}
+
+// incoming registers a stream opened by the peer.
+func (s *Session) incoming(id uint32) (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id%2 == s.nextID%2 || s.streams[id] != nil {
+		return nil, fmt.Errorf("%w: unexpected stream %d", ErrProtocol, id)
+	}
+
+	// Only the receiving goroutine sends to acceptCh, so a free slot
+	// stays free.  The ACK is queued first so it precedes anything
+	// written to the stream once accepted.
+	if len(s.acceptCh) == cap(s.acceptCh) {
+		s.queue(header{typ: typeWindowUpdate, flags: flagRST, stream: id})
+		return nil, nil
+	}
+
+	st := newStream(s, id)
+	s.streams[id] = st
+	close(st.established)
+	s.queue(header{typ: typeWindowUpdate, flags: flagACK, stream: id, length: s.opts.window - initialWindow})
+	s.acceptCh <- st
+	return st, nil
+}
+
+func (s *Session) handleStream(h header) error {
+	var st *Stream
+	if h.flags&flagSYN != 0 {
+		var err error
+		if st, err = s.incoming(h.stream); err != nil {
+			return err
+		}
+	} else {
+		s.mu.Lock()
+		st = s.streams[h.stream]
+		s.mu.Unlock()
+	}
+
+	var payload []byte
+	if h.typ == typeData && h.length > 0 {
+		if h.length > maxFrameSize {
+			return fmt.Errorf("%w: oversized %s", ErrProtocol, h)
+		}
+		payload = make([]byte, h.length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			return err
+		}
+	}
+
+	if st == nil {
+		// The stream is gone already, or was refused.
+		return nil
+	}
+	return st.handle(h, payload)
+}
@@ -0,0 +1,388 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpmux_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpmux"
+)
+
+func newPair(t *testing.T, opts ...qpmux.Option) (client, server *qpmux.Session) {
+	l, r := net.Pipe()
+	client = qpmux.Client(l, opts...)
+	server = qpmux.Server(r, opts...)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// echo serves streams accepted from s by copying their input back.
+func echo(s *qpmux.Session) {
+	for {
+		st, err := s.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer st.Close()
+			_, _ = io.Copy(st, st)
+			_ = st.CloseWrite()
+		}()
+	}
+}
+
+func TestSession_Echo(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(server)
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+	defer st.Close()
+
+	_, err = st.Write([]byte("hello"))
+	assert.NoError(err)
+	assert.NoError(st.CloseWrite())
+
+	got, err := io.ReadAll(st)
+	assert.NoError(err)
+	assert.Equal("hello", string(got))
+
+	_, err = st.Write([]byte("late"))
+	assert.ErrorIs(err, qpmux.ErrStreamClosed)
+}
+
+func TestSession_BothDirections(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(client)
+
+	st, err := server.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(uint32(2), st.ID())
+
+	_, err = st.Write([]byte("ping"))
+	assert.NoError(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(st, buf)
+	assert.NoError(err)
+	assert.Equal("ping", string(buf))
+}
+
+func TestSession_FlowControl(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(server)
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Many times the window, in both directions at once.
+	want := make([]byte, 4<<20)
+	_, _ = rand.Read(want)
+
+	var got []byte
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		got, err = io.ReadAll(st)
+	}()
+
+	n, werr := st.Write(want)
+	assert.NoError(werr)
+	assert.Equal(len(want), n)
+	assert.NoError(st.CloseWrite())
+
+	wg.Wait()
+	assert.NoError(err)
+	assert.True(bytes.Equal(want, got), "data mismatch")
+}
+
+func TestSession_WindowBlocksWriter(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+
+	accepted := make(chan *qpmux.Stream, 1)
+	go func() {
+		st, err := server.AcceptStream()
+		if err == nil {
+			accepted <- st
+		}
+	}()
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Nobody reads, so the writer stops after one window.
+	assert.NoError(st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)))
+	n, err := st.Write(make([]byte, 1<<20))
+	assert.ErrorIs(err, os.ErrDeadlineExceeded)
+	assert.Equal(256<<10, n)
+
+	// Reading opens the window again.
+	peer := <-accepted
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+	assert.NoError(st.SetWriteDeadline(time.Time{}))
+	n, err = st.Write(make([]byte, 1<<20))
+	assert.NoError(err)
+	assert.Equal(1<<20, n)
+}
+
+func TestSession_WriteTimeoutKeepsWindow(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	defer r.Close()
+	s := qpmux.Client(l)
+	defer s.Close()
+
+	// A peer that accepts stream 1 without reading, so the session
+	// is stuck sending the SYN and the first write times out before
+	// its frame is handed over.
+	resume := make(chan struct{})
+	go func() {
+		_, _ = r.Write([]byte{0, 1, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0})
+		<-resume
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	st, err := s.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(st.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)))
+	n, err := st.Write(make([]byte, 100))
+	assert.ErrorIs(err, os.ErrDeadlineExceeded)
+	assert.Zero(n)
+
+	// The whole window is still available.
+	close(resume)
+	assert.NoError(st.SetWriteDeadline(time.Now().Add(5 * time.Second)))
+	n, err = st.Write(make([]byte, 256<<10))
+	assert.NoError(err)
+	assert.Equal(256<<10, n)
+}
+
+func TestSession_ManyStreams(t *testing.T) {
+	client, server := newPair(t)
+	go echo(server)
+
+	ctx := testContext(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert := assertpkg.New(t)
+
+			st, err := client.Open(ctx)
+			if !assert.NoError(err) {
+				return
+			}
+			defer st.Close()
+
+			want := make([]byte, 100<<10)
+			binary.BigEndian.PutUint32(want, uint32(i))
+			_, err = st.Write(want)
+			assert.NoError(err)
+			assert.NoError(st.CloseWrite())
+
+			got, err := io.ReadAll(st)
+			assert.NoError(err)
+			assert.True(bytes.Equal(want, got), "stream %d: data mismatch", i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSession_Refused(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, _ := newPair(t, qpmux.WithAcceptBacklog(1))
+	ctx := testContext(t)
+
+	_, err := client.Open(ctx)
+	assert.NoError(err)
+	_, err = client.Open(ctx)
+	assert.ErrorIs(err, qpmux.ErrRefused)
+}
+
+func TestSession_ReadDeadline(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(server)
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(st.SetReadDeadline(time.Now().Add(50 * time.Millisecond)))
+	_, err = st.Read(make([]byte, 1))
+	assert.ErrorIs(err, os.ErrDeadlineExceeded)
+
+	var netErr net.Error
+	if assert.ErrorAs(err, &netErr) {
+		assert.True(netErr.Timeout())
+	}
+}
+
+func TestSession_Close(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(server)
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(server.Close())
+	<-client.Done()
+	assert.ErrorIs(client.Err(), qpmux.ErrGoAway)
+
+	_, err = st.Read(make([]byte, 1))
+	assert.ErrorIs(err, qpmux.ErrGoAway)
+	_, err = client.Open(testContext(t))
+	assert.ErrorIs(err, qpmux.ErrGoAway)
+	_, err = server.Accept()
+	assert.ErrorIs(err, qpmux.ErrSessionClosed)
+}
+
+func TestSession_Ping(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, _ := newPair(t)
+	rtt, err := client.Ping(testContext(t))
+	assert.NoError(err)
+	assert.Positive(rtt)
+}
+
+func TestSession_KeepAliveTimeout(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	defer r.Close()
+
+	// A peer that says hello with a ping reply nobody asked for and
+	// then stops answering.
+	go func() {
+		_, _ = r.Write([]byte{0, 2, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0})
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	s := qpmux.Client(l, qpmux.WithKeepAlive(10*time.Millisecond, 20*time.Millisecond))
+	select {
+	case <-s.Done():
+		assert.ErrorIs(s.Err(), qpmux.ErrKeepAliveTimeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session still up")
+	}
+}
+
+func TestSession_ProtocolError(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	defer r.Close()
+	go func() {
+		_, _ = r.Write([]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	s := qpmux.Server(l)
+	_, err := s.Accept()
+	assert.ErrorIs(err, qpmux.ErrProtocol)
+}
+
+type Arith struct{}
+
+func (Arith) Add(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func TestSession_NetRPC(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+
+	srv := rpc.NewServer()
+	if !assert.NoError(srv.Register(Arith{})) {
+		return
+	}
+	go srv.Accept(server)
+
+	conn, err := client.DialContext(testContext(t), "agent")
+	if !assert.NoError(err) {
+		return
+	}
+	c := rpc.NewClient(conn)
+	defer c.Close()
+
+	var sum int
+	assert.NoError(c.Call("Arith.Add", [2]int{40, 2}, &sum))
+	assert.Equal(42, sum)
+}
+
+func TestAddr(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	client, server := newPair(t)
+	go echo(server)
+
+	st, err := client.Open(testContext(t))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("qpmux", st.LocalAddr().Network())
+	assert.Equal("qpmux:1", st.RemoteAddr().String())
+}
@@ -0,0 +1,363 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpmux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// errTimeout is returned when a deadline passes.
+var errTimeout = os.ErrDeadlineExceeded
+
+// Stream is a bidirectional stream within a Session.
+type Stream struct {
+	id uint32
+	s  *Session
+
+	established chan struct{}
+	readSignal  chan struct{}
+	writeSignal chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	mu         sync.Mutex
+	recv       bytes.Buffer
+	recvWindow uint32
+	consumed   uint32
+	sendWindow uint32
+
+	refused, reset bool
+
+	// remoteFIN is set when the peer will not write anymore,
+	// localFIN when we will not, closed when we will not read.
+	remoteFIN, localFIN, closed bool
+}
+
+var _ net.Conn = &Stream{}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:            id,
+		s:             s,
+		established:   make(chan struct{}),
+		readSignal:    make(chan struct{}, 1),
+		writeSignal:   make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		recvWindow:    s.opts.window,
+		sendWindow:    initialWindow,
+	}
+}
+
+// ID returns the id of the stream within its session.
+func (st *Stream) ID() uint32 { return st.id }
+
+func (st *Stream) LocalAddr() net.Addr  { return Addr{Stream: st.id} }
+func (st *Stream) RemoteAddr() net.Addr { return Addr{Stream: st.id} }
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	st.writeDeadline.set(t)
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error  { st.readDeadline.set(t); return nil }
+func (st *Stream) SetWriteDeadline(t time.Time) error { st.writeDeadline.set(t); return nil }
+
+// notify wakes up readers and writers to check the stream state.
+func (st *Stream) notify() {
+	for _, ch := range []chan struct{}{st.readSignal, st.writeSignal} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (st *Stream) isEstablished() bool {
+	select {
+	case <-st.established:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle processes a frame the peer sent for the stream.
+func (st *Stream) handle(h header, payload []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	defer st.notify()
+
+	if h.flags&flagACK != 0 && !st.isEstablished() {
+		close(st.established)
+	}
+	if h.flags&flagRST != 0 {
+		if !st.isEstablished() {
+			st.refused = true
+			close(st.established)
+		}
+		st.reset = true
+		st.s.remove(st.id)
+		return nil
+	}
+
+	switch h.typ {
+	case typeWindowUpdate:
+		st.sendWindow += h.length
+	case typeData:
+		n := uint32(len(payload))
+		if n > st.recvWindow {
+			return fmt.Errorf("%w: stream %d exceeded its window", ErrProtocol, st.id)
+		}
+		st.recvWindow -= n
+		if st.closed {
+			// Nobody is going to read, hand the window back.
+			st.grant(n)
+		} else {
+			st.recv.Write(payload)
+		}
+	}
+
+	if h.flags&flagFIN != 0 {
+		st.remoteFIN = true
+		if st.localFIN {
+			st.s.remove(st.id)
+		}
+	}
+	return nil
+}
+
+// grant returns n bytes of the receive window to the peer once enough
+// accumulated.  It is called with mu held.
+func (st *Stream) grant(n uint32) {
+	st.consumed += n
+	if st.consumed < st.s.opts.window/2 {
+		return
+	}
+	st.recvWindow += st.consumed
+	st.s.queue(header{typ: typeWindowUpdate, stream: st.id, length: st.consumed})
+	st.consumed = 0
+}
+
+// wait releases mu until signal, the deadline or the end of the
+// session.  It is called with mu held.
+func (st *Stream) wait(signal chan struct{}, d *deadline) error {
+	st.mu.Unlock()
+	defer st.mu.Lock()
+
+	select {
+	case <-signal:
+		return nil
+	case <-st.s.doneCh:
+		return nil
+	case <-d.wait():
+		return errTimeout
+	}
+}
+
+func (st *Stream) sessionErr() error {
+	select {
+	case <-st.s.doneCh:
+		return st.s.Err()
+	default:
+		return nil
+	}
+}
+
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for st.recv.Len() == 0 {
+		switch {
+		case st.closed:
+			return 0, ErrStreamClosed
+		case st.reset:
+			return 0, ErrStreamReset
+		case st.remoteFIN:
+			return 0, io.EOF
+		}
+		if err := st.sessionErr(); err != nil {
+			return 0, err
+		}
+		if err := st.wait(st.readSignal, &st.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+
+	n, _ := st.recv.Read(p)
+	st.grant(uint32(n))
+	return n, nil
+}
+
+func (st *Stream) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		st.mu.Lock()
+		for {
+			var err error
+			switch {
+			case st.localFIN:
+				err = ErrStreamClosed
+			case st.reset:
+				err = ErrStreamReset
+			default:
+				err = st.sessionErr()
+			}
+			if err == nil && st.sendWindow == 0 {
+				err = st.wait(st.writeSignal, &st.writeDeadline)
+				if err == nil {
+					continue
+				}
+			}
+			if err != nil {
+				st.mu.Unlock()
+				return total, err
+			}
+			break
+		}
+
+		n := min(uint32(len(p)), st.sendWindow, maxFrameSize)
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		h := header{typ: typeData, stream: st.id, length: n}
+		if err := st.s.writeData(h, p[:n], st.writeDeadline.wait()); err != nil {
+			// The frame was not sent, or the session is gone.
+			st.mu.Lock()
+			st.sendWindow += n
+			st.mu.Unlock()
+			return total, err
+		}
+		total += int(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// CloseWrite tells the peer no more data follows, which it reads as
+// io.EOF, while data can still be read from the stream.
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.finish()
+}
+
+// finish sends a FIN unless it was sent already.  It is called with
+// mu held.
+func (st *Stream) finish() error {
+	if st.localFIN || st.reset {
+		return nil
+	}
+	st.localFIN = true
+	st.s.queue(header{typ: typeWindowUpdate, flags: flagFIN, stream: st.id})
+	if st.remoteFIN {
+		st.s.remove(st.id)
+	}
+	st.notify()
+	return nil
+}
+
+// Close closes both directions of the stream.  Data the peer still
+// sends is discarded.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closed {
+		return nil
+	}
+	st.closed = true
+
+	n := st.recv.Len()
+	st.recv.Reset()
+	st.grant(uint32(n))
+	return st.finish()
+}
+
+// abort resets the stream.
+func (st *Stream) abort() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.reset {
+		st.reset = true
+		st.s.queue(header{typ: typeWindowUpdate, flags: flagRST, stream: st.id})
+	}
+	st.s.remove(st.id)
+	st.notify()
+}
+
+// deadline is a channel closed once a point in time passed.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline { return deadline{cancel: make(chan struct{})} }
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish.
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		d.timer = time.AfterFunc(dur, func() { close(d.cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpqemu provides device types generated by qpgen from the
+// introspection data of QEMU 8.2, which is kept in testdata.  Types
+// qpdevices already provides, such as RNGRandomObject or
+// FileCharDevice, are left out.
+//
+// To regenerate the types for another QEMU version, replace the
+// snapshots with the output of query-qmp-schema and
+// device-list-properties of that version, adjust the version below and
+// run go generate.
+package qpqemu
+
+//go:generate go run ../cmd/qpgen -schema testdata/qmp-schema.json -devices testdata/devices.json -skip iothread,rng-builtin,rng-random,file -qemu 8.2.0 -o types_gen.go
@@ -0,0 +1,67 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqemu_test
+
+import (
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+	"github.com/qatapult/libqatapult/qpdevices"
+	"github.com/qatapult/libqatapult/qpoption"
+	"github.com/qatapult/libqatapult/qpqemu"
+	"github.com/qatapult/libqatapult/qptest"
+)
+
+func TestGeneratedTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  libqatapult.Device
+		want []string
+	}{
+		{"virtio-rng-pci", qpqemu.VirtIORNGPCIDevice{
+			BaseDevice:    qpdevices.BaseDevice{Name: "vrng0"},
+			RNG:           "rng0",
+			MaxBytes:      qpoption.Value[uint64](1024),
+			Period:        qpoption.Value[uint32](1000),
+			DisableLegacy: qpqemu.OnOffAutoOn,
+		}, []string{"-device", "virtio-rng-pci,id=vrng0,disable-legacy=on,max-bytes=1024,period=1000,rng=rng0"}},
+
+		{"parallel", qpqemu.ParallelCharDevice{
+			CharDevice: qpdevices.CharDevice{Name: "lp0"},
+			Path:       "/dev/parport0",
+		}, []string{"-chardev", "parallel,id=lp0,path=/dev/parport0"}},
+
+		{"null-co", qpqemu.NullCoBlockDevice{
+			BlockDevice: qpdevices.BlockDevice{Name: "null0", ReadOnly: qpoption.Value(true)},
+			Size:        qpoption.Value[int64](1 << 30),
+			ReadZeroes:  qpoption.Value(true),
+		}, []string{"-blockdev", "driver=null-co,node-name=null0,read-only=on,size=1073741824,read-zeroes=on"}},
+
+		{"hubport", qpqemu.HubportNetworkPeerDevice{
+			NetworkPeerDevice: qpdevices.NetworkPeerDevice{Name: "hub0port0"},
+			Hubid:             qpoption.Value[int32](0),
+		}, []string{"-netdev", "hubport,id=hub0port0,hubid=0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qptest.DeviceCliArgs(tt.dev)
+			if assertpkg.NoError(t, err) {
+				assertpkg.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
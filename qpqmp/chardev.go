@@ -0,0 +1,92 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ChardevInfo describes a chardev as returned by query-chardev.
+type ChardevInfo struct {
+	Label string `json:"label"`
+
+	// Filename describes the backend, e.g. "pty:/dev/pts/3".
+	Filename     string `json:"filename"`
+	FrontendOpen bool   `json:"frontend-open"`
+}
+
+// QueryChardev lists all chardevs of the VM.
+func (c *Client) QueryChardev(ctx context.Context) ([]ChardevInfo, error) {
+	var infos []ChardevInfo
+	if err := c.Execute(ctx, "query-chardev", nil, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// PtyPath returns the path of the pseudo terminal allocated for the
+// pty chardev with the given id.
+func (c *Client) PtyPath(ctx context.Context, id string) (string, error) {
+	infos, err := c.QueryChardev(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		if info.Label != id {
+			continue
+		}
+		path, found := strings.CutPrefix(info.Filename, "pty:")
+		if !found {
+			return "", fmt.Errorf("qmp: chardev %s is not a pty: %s", id, info.Filename)
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("qmp: chardev %s not found", id)
+}
+
+// Ringbuf accesses a ringbuf chardev through QMP.
+type Ringbuf struct {
+	c  *Client
+	id string
+}
+
+// Ringbuf returns a handle on the ringbuf chardev with the given id.
+func (c *Client) Ringbuf(id string) *Ringbuf { return &Ringbuf{c: c, id: id} }
+
+// Read takes up to size bytes the guest sent out of the ring buffer.
+func (r *Ringbuf) Read(ctx context.Context, size int) ([]byte, error) {
+	var data string
+	err := r.c.Execute(ctx, "ringbuf-read", map[string]any{
+		"device": r.id,
+		"size":   size,
+		"format": "base64",
+	}, &data)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+// Write sends p to the guest.
+func (r *Ringbuf) Write(ctx context.Context, p []byte) error {
+	return r.c.Execute(ctx, "ringbuf-write", map[string]any{
+		"device": r.id,
+		"data":   base64.StdEncoding.EncodeToString(p),
+		"format": "base64",
+	}, nil)
+}
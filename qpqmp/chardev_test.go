@@ -0,0 +1,99 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+func TestClient_PtyPath(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	fakeMonitor(t, r, func(cmd string, args json.RawMessage) string {
+		return `"return": [
+			{"frontend-open": true, "filename": "pty:/dev/pts/7", "label": "serial0"},
+			{"frontend-open": false, "filename": "ringbuf", "label": "rb0"}]`
+	})
+
+	c := qpqmp.NewClient(l)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if path, err := c.PtyPath(ctx, "serial0"); assert.NoError(err) {
+		assert.Equal("/dev/pts/7", path)
+	}
+	_, err := c.PtyPath(ctx, "rb0")
+	assert.ErrorContains(err, "not a pty")
+	_, err = c.PtyPath(ctx, "bogus")
+	assert.ErrorContains(err, "not found")
+}
+
+func TestRingbuf(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	var buf []byte
+	l, r := net.Pipe()
+	fakeMonitor(t, r, func(cmd string, args json.RawMessage) string {
+		var a struct {
+			Device string `json:"device"`
+			Data   string `json:"data"`
+			Size   int    `json:"size"`
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.Device != "rb0" || a.Format != "base64" {
+			return `"error": {"class": "GenericError", "desc": "bad arguments"}`
+		}
+		switch cmd {
+		case "ringbuf-write":
+			data, _ := base64.StdEncoding.DecodeString(a.Data)
+			buf = append(buf, data...)
+			return `"return": {}`
+		case "ringbuf-read":
+			n := min(a.Size, len(buf))
+			data := buf[:n]
+			buf = buf[n:]
+			return fmt.Sprintf(`"return": %q`, base64.StdEncoding.EncodeToString(data))
+		}
+		return `"error": {"class": "CommandNotFound", "desc": "unknown command"}`
+	})
+
+	c := qpqmp.NewClient(l)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rb := c.Ringbuf("rb0")
+	assert.NoError(rb.Write(ctx, []byte("hello\x00world")))
+	if data, err := rb.Read(ctx, 6); assert.NoError(err) {
+		assert.Equal([]byte("hello\x00"), data)
+	}
+	if data, err := rb.Read(ctx, 100); assert.NoError(err) {
+		assert.Equal([]byte("world"), data)
+	}
+}
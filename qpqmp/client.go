@@ -0,0 +1,296 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+// Package qpqmp implements a client for the QEMU Machine Protocol.
+//
+// <https://www.qemu.org/docs/master/interop/qmp-spec.html>
+package qpqmp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+var (
+	ErrClosed = errors.New("qpqmp: connection closed")
+)
+
+// Error is an error response returned by QEMU.
+type Error struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("qmp: %s: %s", e.Class, e.Desc) }
+
+// Version describes the QEMU version announced in the Greeting.
+type Version struct {
+	QEMU struct {
+		Major int `json:"major"`
+		Minor int `json:"minor"`
+		Micro int `json:"micro"`
+	} `json:"qemu"`
+	Package string `json:"package"`
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.QEMU.Major, v.QEMU.Minor, v.QEMU.Micro)
+}
+
+// Greeting is the message QEMU sends when a client connects.
+type Greeting struct {
+	Version      Version  `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type (
+	request struct {
+		Execute   string `json:"execute"`
+		Arguments any    `json:"arguments,omitempty"`
+		ID        string `json:"id"`
+	}
+
+	response struct {
+		Return json.RawMessage
+		Error  *Error
+	}
+
+	message struct {
+		QMP *Greeting `json:"QMP"`
+
+		Event     string          `json:"event"`
+		Data      json.RawMessage `json:"data"`
+		Timestamp *timestamp      `json:"timestamp"`
+
+		Return json.RawMessage `json:"return"`
+		Error  *Error          `json:"error"`
+		ID     string          `json:"id"`
+	}
+)
+
+// Client is a QMP client connected to a single QEMU monitor.
+//
+// The client negotiates capabilities on its own after QEMU greeted
+// it, commands issued before that wait for the negotiation.
+type Client struct {
+	conn io.ReadWriteCloser
+
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu        sync.Mutex
+	nextID    uint64
+	pending   map[string]chan response
+	listeners map[uint64]func(Event)
+	greeting  *Greeting
+
+	greeted chan struct{}
+	ready   chan struct{}
+	doneCh  chan struct{}
+	err     atomic.Error
+}
+
+// Greeted is closed once QEMU sent its greeting.
+func (c *Client) Greeted() <-chan struct{} { return c.greeted }
+
+// Ready is closed once the capabilities negotiation finished.
+func (c *Client) Ready() <-chan struct{} { return c.ready }
+
+// Done is closed once the connection to QEMU is gone.
+func (c *Client) Done() <-chan struct{} { return c.doneCh }
+
+// Err returns the reason the connection terminated.
+func (c *Client) Err() error { return c.err.Load() }
+
+func (c *Client) Close() error { return c.conn.Close() }
+
+// Greeting waits for and returns the greeting QEMU sent.
+func (c *Client) Greeting(ctx context.Context) (*Greeting, error) {
+	if err := c.waitReady(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.greeting, nil
+}
+
+func (c *Client) waitReady(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-c.doneCh:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Listen registers fn to be called for every event received.  fn is
+// called from the receiving goroutine and must not block.  The
+// returned function removes the listener again.
+func (c *Client) Listen(fn func(Event)) (cancel func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	id := c.nextID
+	c.listeners[id] = fn
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.listeners, id)
+	}
+}
+
+// Execute runs command with the given arguments and decodes the
+// return value into result, unless result is nil.
+func (c *Client) Execute(ctx context.Context, command string, arguments, result any) error {
+	if err := c.waitReady(ctx); err != nil {
+		return err
+	}
+	return c.execute(ctx, command, arguments, result)
+}
+
+func (c *Client) execute(ctx context.Context, command string, arguments, result any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.pending, id)
+	}()
+
+	if err := c.send(request{Execute: command, Arguments: arguments, ID: id}); err != nil {
+		return fmt.Errorf("qmp: %s: %w", command, err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Return, result); err != nil {
+			return fmt.Errorf("qmp: %s: %w", command, err)
+		}
+		return nil
+	case <-c.doneCh:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) send(req request) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.enc.Encode(req)
+}
+
+func (c *Client) dispatchEvent(ev Event) {
+	c.mu.Lock()
+	listeners := make([]func(Event), 0, len(c.listeners))
+	for _, fn := range c.listeners {
+		listeners = append(listeners, fn)
+	}
+	c.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+func (c *Client) dispatchResponse(m *message) {
+	c.mu.Lock()
+	ch, found := c.pending[m.ID]
+	c.mu.Unlock()
+
+	if found {
+		ch <- response{Return: m.Return, Error: m.Error}
+	}
+}
+
+func (c *Client) negotiate() {
+	if err := c.execute(context.Background(), "qmp_capabilities", nil, nil); err != nil {
+		// The connection is broken, the receiving goroutine
+		// will notice and record the error.
+		_ = c.conn.Close()
+		return
+	}
+	close(c.ready)
+}
+
+func (c *Client) receive() {
+	defer close(c.doneCh)
+
+	dec := json.NewDecoder(c.conn)
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrClosed
+			}
+			c.err.Store(err)
+			return
+		}
+
+		switch {
+		case m.QMP != nil:
+			c.mu.Lock()
+			first := c.greeting == nil
+			c.greeting = m.QMP
+			c.mu.Unlock()
+
+			if first {
+				close(c.greeted)
+				go c.negotiate()
+			}
+		case m.Event != "":
+			c.dispatchEvent(Event{Name: m.Event, Data: m.Data, Timestamp: m.Timestamp.Time()})
+		default:
+			c.dispatchResponse(&m)
+		}
+	}
+}
+
+// NewClient creates a new Client talking to the QMP monitor on the
+// other end of conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:      conn,
+		enc:       json.NewEncoder(conn),
+		pending:   map[string]chan response{},
+		listeners: map[uint64]func(Event){},
+		greeted:   make(chan struct{}),
+		ready:     make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+const greeting = `{"QMP": {"version": {"qemu": {"micro": 0, "minor": 2, "major": 8}, "package": ""}, "capabilities": ["oob"]}}`
+
+// fakeMonitor answers every command with the reply returned by
+// handle, after sending the QMP greeting.
+func fakeMonitor(t *testing.T, conn net.Conn, handle func(cmd string, args json.RawMessage) string) {
+	t.Helper()
+
+	go func() {
+		defer conn.Close()
+
+		fmt.Fprintln(conn, greeting)
+
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var req struct {
+				Execute   string          `json:"execute"`
+				Arguments json.RawMessage `json:"arguments"`
+				ID        string          `json:"id"`
+			}
+			if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+				t.Error(err)
+				return
+			}
+
+			reply := `"return": {}`
+			if req.Execute != "qmp_capabilities" {
+				reply = handle(req.Execute, req.Arguments)
+			}
+			if reply == "" {
+				return
+			}
+			fmt.Fprintf(conn, `{%s, "id": %q}`+"\n", reply, req.ID)
+		}
+	}()
+}
+
+func TestClient_Execute(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	fakeMonitor(t, r, func(cmd string, args json.RawMessage) string {
+		switch cmd {
+		case "query-status":
+			return `"return": {"status": "running", "running": true}`
+		case "echo":
+			return fmt.Sprintf(`"return": %s`, args)
+		default:
+			return `"error": {"class": "CommandNotFound", "desc": "The command ` + cmd + ` has not been found"}`
+		}
+	})
+
+	c := qpqmp.NewClient(l)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if g, err := c.Greeting(ctx); assert.NoError(err) {
+		assert.Equal("8.2.0", g.Version.String())
+		assert.Equal([]string{"oob"}, g.Capabilities)
+	}
+
+	var status struct {
+		Status  string `json:"status"`
+		Running bool   `json:"running"`
+	}
+	if assert.NoError(c.Execute(ctx, "query-status", nil, &status)) {
+		assert.Equal("running", status.Status)
+		assert.True(status.Running)
+	}
+
+	var echo map[string]int
+	if assert.NoError(c.Execute(ctx, "echo", map[string]int{"a": 1}, &echo)) {
+		assert.Equal(map[string]int{"a": 1}, echo)
+	}
+
+	var qmpErr *qpqmp.Error
+	if assert.ErrorAs(c.Execute(ctx, "bogus", nil, nil), &qmpErr) {
+		assert.Equal("CommandNotFound", qmpErr.Class)
+	}
+}
+
+func TestClient_Events(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	fakeMonitor(t, r, func(cmd string, args json.RawMessage) string {
+		fmt.Fprintln(r, `{"event": "STOP", "timestamp": {"seconds": 1, "microseconds": 5}}`)
+		fmt.Fprintln(r, `{"event": "SHUTDOWN", "data": {"guest": true, "reason": "guest-shutdown"}, "timestamp": {"seconds": 2, "microseconds": 0}}`)
+		return ""
+	})
+
+	c := qpqmp.NewClient(l)
+
+	var seen []string
+	c.Listen(func(ev qpqmp.Event) { seen = append(seen, ev.Name) })
+	a := c.Await("SHUTDOWN", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.ErrorIs(c.Execute(ctx, "quit", nil, nil), qpqmp.ErrClosed)
+
+	if ev, err := a.Wait(ctx); assert.NoError(err) {
+		var data struct{ Reason string }
+		assert.NoError(ev.Decode(&data))
+		assert.Equal("guest-shutdown", data.Reason)
+		assert.Equal(time.Unix(2, 0), ev.Timestamp)
+	}
+
+	<-c.Done()
+	assert.Equal([]string{"STOP", "SHUTDOWN"}, seen)
+	assert.ErrorIs(c.Err(), qpqmp.ErrClosed)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp
+
+import (
+	"context"
+)
+
+// CPUModelInfo is a CPU model with properties, mostly features,
+// changed from their defaults.
+type CPUModelInfo struct {
+	Name  string         `json:"name"`
+	Props map[string]any `json:"props,omitempty"`
+}
+
+// CPUModelExpansionType selects how query-cpu-model-expansion
+// expands a model.
+type CPUModelExpansionType string
+
+const (
+	// CPUModelExpansionStatic expands a model into a static base
+	// model, which never changes between QEMU versions, and the
+	// properties that differ from it.
+	CPUModelExpansionStatic CPUModelExpansionType = "static"
+
+	// CPUModelExpansionFull expands a model into itself and all of
+	// its properties.
+	CPUModelExpansionFull CPUModelExpansionType = "full"
+)
+
+// QueryCPUModelExpansion expands model as the accelerator QEMU runs
+// with would, e.g. to learn the features the host model has.
+func (c *Client) QueryCPUModelExpansion(ctx context.Context, typ CPUModelExpansionType, model CPUModelInfo) (*CPUModelInfo, error) {
+	args := struct {
+		Type  CPUModelExpansionType `json:"type"`
+		Model CPUModelInfo          `json:"model"`
+	}{typ, model}
+
+	var result struct {
+		Model CPUModelInfo `json:"model"`
+	}
+	if err := c.Execute(ctx, "query-cpu-model-expansion", args, &result); err != nil {
+		return nil, err
+	}
+	return &result.Model, nil
+}
+
+// CPUDefinition describes a CPU model as returned by
+// query-cpu-definitions.
+type CPUDefinition struct {
+	Name     string `json:"name"`
+	Typename string `json:"typename"`
+	AliasOf  string `json:"alias-of,omitempty"`
+
+	// MigrationSafe tells whether the model is the same on all
+	// hosts, unlike e.g. host.
+	MigrationSafe bool `json:"migration-safe"`
+
+	// Static tells whether the model never changes between QEMU
+	// versions and machine types.
+	Static bool `json:"static"`
+
+	// UnavailableFeatures lists the features of the model the
+	// accelerator cannot provide on this host, which keep the model
+	// from being used.  It is nil if QEMU cannot tell.
+	UnavailableFeatures []string `json:"unavailable-features"`
+	Deprecated          bool     `json:"deprecated"`
+}
+
+// QueryCPUDefinitions lists the CPU models QEMU knows.
+func (c *Client) QueryCPUDefinitions(ctx context.Context) ([]CPUDefinition, error) {
+	var defs []CPUDefinition
+	if err := c.Execute(ctx, "query-cpu-definitions", nil, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// CPUInstanceProperties locates a CPU in the topology of the machine.
+// Levels the machine does not have are nil.
+type CPUInstanceProperties struct {
+	NodeID    *int `json:"node-id,omitempty"`
+	SocketID  *int `json:"socket-id,omitempty"`
+	DieID     *int `json:"die-id,omitempty"`
+	ClusterID *int `json:"cluster-id,omitempty"`
+	CoreID    *int `json:"core-id,omitempty"`
+	ThreadID  *int `json:"thread-id,omitempty"`
+}
+
+// HotpluggableCPU is a slot for a CPU device as returned by
+// query-hotpluggable-cpus.
+type HotpluggableCPU struct {
+	// Type is the driver of the CPU device to plug into the slot.
+	Type       string                `json:"type"`
+	VCPUsCount int                   `json:"vcpus-count"`
+	Props      CPUInstanceProperties `json:"props"`
+
+	// QOMPath is the path of the CPU in the slot, empty if the slot
+	// is free.
+	QOMPath string `json:"qom-path,omitempty"`
+}
+
+// QueryHotpluggableCPUs lists the CPU slots of the machine, both used
+// and free ones.
+func (c *Client) QueryHotpluggableCPUs(ctx context.Context) ([]HotpluggableCPU, error) {
+	var cpus []HotpluggableCPU
+	if err := c.Execute(ctx, "query-hotpluggable-cpus", nil, &cpus); err != nil {
+		return nil, err
+	}
+	return cpus, nil
+}
+
+// CPUInfoFast describes a vCPU as returned by query-cpus-fast.
+type CPUInfoFast struct {
+	CPUIndex int    `json:"cpu-index"`
+	QOMPath  string `json:"qom-path"`
+
+	// ThreadID is the id of the host thread running the vCPU.
+	ThreadID int                   `json:"thread-id"`
+	Props    CPUInstanceProperties `json:"props"`
+	Target   string                `json:"target"`
+}
+
+// QueryCPUsFast lists the vCPUs of the machine without interrupting
+// them.
+func (c *Client) QueryCPUsFast(ctx context.Context) ([]CPUInfoFast, error) {
+	var cpus []CPUInfoFast
+	if err := c.Execute(ctx, "query-cpus-fast", nil, &cpus); err != nil {
+		return nil, err
+	}
+	return cpus, nil
+}
+
+// IOThreadInfo describes an iothread object as returned by
+// query-iothreads.
+type IOThreadInfo struct {
+	ID       string `json:"id"`
+	ThreadID int    `json:"thread-id"`
+}
+
+// QueryIOThreads lists the iothread objects of the machine.
+func (c *Client) QueryIOThreads(ctx context.Context) ([]IOThreadInfo, error) {
+	var threads []IOThreadInfo
+	if err := c.Execute(ctx, "query-iothreads", nil, &threads); err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
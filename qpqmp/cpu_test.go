@@ -0,0 +1,70 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+func TestClient_QueryCPUModelExpansion(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	l, r := net.Pipe()
+	fakeMonitor(t, r, func(cmd string, args json.RawMessage) string {
+		switch cmd {
+		case "query-cpu-model-expansion":
+			if string(args) != `{"type":"static","model":{"name":"Skylake-Client","props":{"vmx":true}}}` {
+				return `"error": {"class": "GenericError", "desc": "bad arguments"}`
+			}
+			return `"return": {"model": {"name": "base", "props": {"vmx": true, "family": 6}}}`
+		case "query-cpu-definitions":
+			return `"return": [{"name": "Skylake-Client", "typename": "Skylake-Client-x86_64-cpu",
+				"migration-safe": true, "static": false, "unavailable-features": ["hle"], "deprecated": false}]`
+		}
+		return `"error": {"class": "CommandNotFound", "desc": "not found"}`
+	})
+
+	c := qpqmp.NewClient(l)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model, err := c.QueryCPUModelExpansion(ctx, qpqmp.CPUModelExpansionStatic, qpqmp.CPUModelInfo{
+		Name:  "Skylake-Client",
+		Props: map[string]any{"vmx": true},
+	})
+	if assert.NoError(err) {
+		assert.Equal(&qpqmp.CPUModelInfo{Name: "base", Props: map[string]any{"vmx": true, "family": float64(6)}}, model)
+	}
+
+	defs, err := c.QueryCPUDefinitions(ctx)
+	if assert.NoError(err) {
+		assert.Equal([]qpqmp.CPUDefinition{{
+			Name:                "Skylake-Client",
+			Typename:            "Skylake-Client-x86_64-cpu",
+			MigrationSafe:       true,
+			UnavailableFeatures: []string{"hle"},
+		}}, defs)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package qpqmp
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type timestamp struct {
+	Seconds      int64 `json:"seconds"`
+	Microseconds int64 `json:"microseconds"`
+}
+
+func (t *timestamp) Time() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Unix(t.Seconds, t.Microseconds*int64(time.Microsecond))
+}
+
+// Event is an asynchronous event emitted by QEMU.
+type Event struct {
+	Name      string
+	Data      json.RawMessage
+	Timestamp time.Time
+}
+
+// Decode decodes the event data into v.
+func (e Event) Decode(v any) error { return json.Unmarshal(e.Data, v) }
+
+// Awaiter waits for a single event.
+type Awaiter struct {
+	ch     chan Event
+	cancel func()
+	doneCh <-chan struct{}
+}
+
+// Wait blocks until the awaited event arrived.
+func (a *Awaiter) Wait(ctx context.Context) (Event, error) {
+	defer a.cancel()
+
+	select {
+	case ev := <-a.ch:
+		return ev, nil
+	case <-a.doneCh:
+		// The event may have been the last thing QEMU sent.
+		select {
+		case ev := <-a.ch:
+			return ev, nil
+		default:
+			return Event{}, ErrClosed
+		}
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
+
+// Stop stops waiting for the event.
+func (a *Awaiter) Stop() { a.cancel() }
+
+// Await starts waiting for the first event called name for which
+// match returns true.  A nil match accepts any event of that name.
+//
+// Await must be called before triggering the event to not miss it.
+func (c *Client) Await(name string, match func(Event) bool) *Awaiter {
+	a := &Awaiter{ch: make(chan Event, 1), doneCh: c.doneCh}
+	a.cancel = c.Listen(func(ev Event) {
+		if ev.Name != name || (match != nil && !match(ev)) {
+			return
+		}
+		select {
+		case a.ch <- ev:
+		default:
+		}
+	})
+	return a
+}
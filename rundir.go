@@ -0,0 +1,277 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/atomic"
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/procfs"
+)
+
+var (
+	ErrRuntimeDirInUse = errors.New("runtime directory in use")
+
+	// ErrRuntimeDirKept is returned when creating a runtime directory
+	// that holds the artifacts an earlier VM of the same name kept.
+	ErrRuntimeDirKept = errors.New("runtime directory holds kept artifacts")
+)
+
+const (
+	metadataFile = "metadata.json"
+	pidFile      = "qemu.pid"
+	logFile      = "qemu.log"
+	lockFile     = "lock"
+	keptFile     = "kept"
+
+	// daemonStderrFile and debugLogFile hold the stderr and debug
+	// log of a detached VM.
+	daemonStderrFile = "stderr.log"
+	debugLogFile     = "debug.log"
+)
+
+// RuntimeRoot returns the directory runtime directories are created
+// in by default.  It can be overridden with QATAPULT_RUNTIME_DIR
+// and defaults to $XDG_RUNTIME_DIR/qatapult.
+func RuntimeRoot() string {
+	if dir := os.Getenv("QATAPULT_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "qatapult")
+	}
+	return filepath.Join(os.TempDir(), "qatapult-"+strconv.Itoa(os.Getuid()))
+}
+
+// RuntimeMetadata describes a VM owning a RuntimeDir.
+type RuntimeMetadata struct {
+	Name      string `json:"name"`
+	Pid       int    `json:"pid"`
+	StartTime uint64 `json:"start-time"`
+	Owner     string `json:"owner"`
+	// Description is the command line rendered from the Description
+	// of the VM, CmdLine the one QEMU was actually launched with.
+	Description []string  `json:"description"`
+	CmdLine     []string  `json:"cmdline"`
+	Created     time.Time `json:"created"`
+
+	// Monitor is the path of the QMP socket of a detached VM.
+	Monitor  string `json:"monitor,omitempty"`
+	Detached bool   `json:"detached,omitempty"`
+}
+
+// Running reports whether the VM described by m is still running.
+func (m *RuntimeMetadata) Running() bool {
+	st, err := procfs.ReadStat(m.Pid)
+	return err == nil && st.StartTime == m.StartTime && st.State != 'Z'
+}
+
+// RuntimeDir is a directory owned by a single VM, holding its named
+// sockets, pidfile, logs and metadata.
+//
+// The directory is locked for as long as the RuntimeDir or the QEMU
+// process using it is alive.
+type RuntimeDir struct {
+	name string
+	path string
+	lock *os.File
+	keep atomic.Bool
+}
+
+func (d *RuntimeDir) Name() string { return d.name }
+func (d *RuntimeDir) Path() string { return d.path }
+
+// Join returns the path of the given file inside the directory.
+func (d *RuntimeDir) Join(elem ...string) string {
+	return filepath.Join(append([]string{d.path}, elem...)...)
+}
+
+// Socket returns the path of the unix socket with the given name.
+func (d *RuntimeDir) Socket(name string) string { return d.Join(name + ".sock") }
+
+// PidFile returns the path of the pidfile of the VM.
+func (d *RuntimeDir) PidFile() string { return d.Join(pidFile) }
+
+// LogFile returns the path of the file all QEMU log output goes to.
+func (d *RuntimeDir) LogFile() string { return d.Join(logFile) }
+
+// Keep marks the directory to be kept when the VM exits, so its
+// artifacts can be inspected afterwards.
+func (d *RuntimeDir) Keep()        { d.keep.Store(true) }
+func (d *RuntimeDir) IsKept() bool { return d.keep.Load() }
+
+// WriteMetadata atomically replaces the metadata of the directory.
+func (d *RuntimeDir) WriteMetadata(m *RuntimeMetadata) (err error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(d.path, metadataFile+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, os.Remove(f.Name()))
+		}
+	}()
+
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return multierr.Append(err, f.Close())
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), d.Join(metadataFile))
+}
+
+// ReadMetadata reads the metadata of the directory.
+func (d *RuntimeDir) ReadMetadata() (*RuntimeMetadata, error) {
+	return readMetadata(d.path)
+}
+
+func readMetadata(dir string) (*RuntimeMetadata, error) {
+	b, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var m RuntimeMetadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", metadataFile, err)
+	}
+	return &m, nil
+}
+
+// Release gives up the lock on the directory without removing it.
+func (d *RuntimeDir) Release() error {
+	if d.lock == nil {
+		return nil
+	}
+	return d.lock.Close()
+}
+
+// Remove removes the directory with all its contents, unless the
+// directory is marked to be kept.  A directory that was attached to
+// is only removed once nobody else holds its lock anymore.
+func (d *RuntimeDir) Remove() (err error) {
+	if d.IsKept() {
+		// Mark the artifacts, so the next VM of the same name does
+		// not clear them out.
+		return multierr.Append(os.WriteFile(d.Join(keptFile), nil, 0o600), d.Release())
+	}
+	if d.lock == nil {
+		if d.lock, err = lockDir(d.path); err != nil {
+			return err
+		}
+	}
+	return multierr.Append(os.RemoveAll(d.path), d.Release())
+}
+
+// lockDir takes the lock of the runtime directory at path.
+func lockDir(path string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE|unix.O_CLOEXEC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			err = ErrRuntimeDirInUse
+		}
+		return nil, multierr.Append(err, f.Close())
+	}
+	return f, nil
+}
+
+type runtimeDirOpts struct {
+	root string
+}
+
+type RuntimeDirOpt func(o *runtimeDirOpts)
+
+// WithRuntimeRoot creates the runtime directory below root instead
+// of RuntimeRoot.
+func WithRuntimeRoot(root string) RuntimeDirOpt {
+	return func(o *runtimeDirOpts) { o.root = root }
+}
+
+func (o *runtimeDirOpts) apply(opts []RuntimeDirOpt) *runtimeDirOpts {
+	o.root = RuntimeRoot()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func checkRuntimeDirName(name string) error {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("runtime dir: invalid name %q", name)
+	}
+	return nil
+}
+
+// NewRuntimeDir creates and locks the runtime directory for the VM
+// with the given name.  A stale directory left behind by a VM that
+// is gone is taken over, unless that VM kept its artifacts, which
+// have to be removed first.
+func NewRuntimeDir(name string, opts ...RuntimeDirOpt) (*RuntimeDir, error) {
+	o := (&runtimeDirOpts{}).apply(opts)
+	if err := checkRuntimeDirName(name); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(o.root, 0o700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(o.root, name)
+	if err := os.Mkdir(path, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, err
+	}
+
+	lock, err := lockDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("runtime dir %s: %w", name, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, keptFile)); err == nil {
+		return nil, multierr.Append(fmt.Errorf("runtime dir %s: %w", name, ErrRuntimeDirKept), lock.Close())
+	}
+
+	// Clear out whatever a previous owner left behind.
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, multierr.Append(err, lock.Close())
+	}
+	for _, e := range entries {
+		if e.Name() == lockFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(path, e.Name())); err != nil {
+			return nil, multierr.Append(err, lock.Close())
+		}
+	}
+
+	return &RuntimeDir{name: name, path: path, lock: lock}, nil
+}
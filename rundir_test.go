@@ -0,0 +1,138 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+)
+
+func TestNewRuntimeDir(t *testing.T) {
+	assert := assertpkg.New(t)
+	root := t.TempDir()
+
+	d, err := libqatapult.NewRuntimeDir("vm", libqatapult.WithRuntimeRoot(root))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(filepath.Join(root, "vm"), d.Path())
+	assert.Equal(filepath.Join(root, "vm", "qmp.sock"), d.Socket("qmp"))
+	assert.NoError(os.WriteFile(d.Join("stale"), nil, 0o600))
+
+	_, err = libqatapult.NewRuntimeDir("vm", libqatapult.WithRuntimeRoot(root))
+	assert.ErrorIs(err, libqatapult.ErrRuntimeDirInUse)
+
+	// A released directory is taken over and cleared out.
+	assert.NoError(d.Release())
+	d, err = libqatapult.NewRuntimeDir("vm", libqatapult.WithRuntimeRoot(root))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoFileExists(d.Join("stale"))
+
+	assert.NoError(d.Remove())
+	assert.NoDirExists(d.Path())
+
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		_, err := libqatapult.NewRuntimeDir(name, libqatapult.WithRuntimeRoot(root))
+		assert.Error(err, name)
+	}
+}
+
+func TestYeet_RuntimeDir(t *testing.T) {
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{"remove", false},
+		{"keep", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assertpkg.New(t)
+			root := t.TempDir()
+
+			opts := []libqatapult.YeetOption{
+				libqatapult.YeetWithName("vm"),
+				libqatapult.YeetWithRuntimeRoot(root),
+			}
+			if tt.keep {
+				opts = append(opts, libqatapult.YeetWithKeepArtifacts())
+			}
+
+			vm, err := libqatapult.Yeet(context.Background(),
+				shellConfig(`echo "qemu: warning: hello" >&2; sleep 0.2`), opts...)
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal("vm", vm.Name())
+
+			dir := vm.RuntimeDir()
+			m, err := dir.ReadMetadata()
+			if assert.NoError(err) {
+				assert.Equal("vm", m.Name)
+				assert.Equal(vm.Pid(), m.Pid)
+				assert.NotZero(m.StartTime)
+			}
+			assert.FileExists(dir.PidFile())
+
+			if !waitDone(t, vm) {
+				return
+			}
+			if !tt.keep {
+				assert.NoDirExists(dir.Path())
+				return
+			}
+
+			log, err := os.ReadFile(dir.LogFile())
+			if assert.NoError(err) {
+				assert.Contains(string(log), "stderr: qemu: warning: hello")
+			}
+			assert.FileExists(filepath.Join(dir.Path(), "metadata.json"))
+
+			// The next VM of the same name leaves the artifacts be.
+			_, err = libqatapult.Yeet(context.Background(), shellConfig("exit 0"), opts...)
+			assert.ErrorIs(err, libqatapult.ErrRuntimeDirKept)
+			assert.FileExists(dir.LogFile())
+		})
+	}
+}
+
+func TestYeet_WithoutRuntimeDir(t *testing.T) {
+	assert := assertpkg.New(t)
+	root := t.TempDir()
+
+	// Without a runtime dir, QEMU gets no file descriptors.
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(`[ ! -e /proc/$$/fd/3 ]`),
+		libqatapult.YeetWithName("vm"),
+		libqatapult.YeetWithoutRuntimeDir())
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+	assert.Equal("vm", vm.Name())
+	assert.Nil(vm.RuntimeDir())
+	assert.True(vm.ExitStatus().Success())
+
+	_, err = libqatapult.Yeet(context.Background(), shellConfig("exit 0"),
+		libqatapult.YeetWithoutRuntimeDir(),
+		libqatapult.YeetWithRuntimeRoot(root))
+	assert.Error(err)
+}
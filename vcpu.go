@@ -0,0 +1,217 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/qpqmp"
+)
+
+// ErrNoCPUSlot is returned by AddCPU when the machine has no free
+// slot for a CPU at the requested position.
+var ErrNoCPUSlot = errors.New("no free CPU slot")
+
+// HotpluggableCPUs lists the CPU slots of the VM.  Slots with an
+// empty QOMPath are free and can be filled with AddCPU, which needs
+// SMP.MaxCPUs to be larger than SMP.CPUs.
+func (v *VM) HotpluggableCPUs(ctx context.Context) ([]qpqmp.HotpluggableCPU, error) {
+	mon, err := v.qmp()
+	if err != nil {
+		return nil, err
+	}
+	return mon.QueryHotpluggableCPUs(ctx)
+}
+
+// instanceAt reports whether props are at the given position.  Levels
+// the machine does not have are at 0.
+func instanceAt(props qpqmp.CPUInstanceProperties, socket, core, thread int) bool {
+	at := func(id *int, want int) bool {
+		if id == nil {
+			return want == 0
+		}
+		return *id == want
+	}
+	return at(props.SocketID, socket) && at(props.CoreID, core) && at(props.ThreadID, thread)
+}
+
+// AddCPU plugs a CPU into the free slot at the given position and
+// returns the id of the new device, which Unplug takes to remove it
+// again.  Like on hardware, the guest still has to online the CPU.
+func (v *VM) AddCPU(ctx context.Context, socket, core, thread int) (string, error) {
+	mon, err := v.qmp()
+	if err != nil {
+		return "", err
+	}
+	slots, err := mon.QueryHotpluggableCPUs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, slot := range slots {
+		if slot.QOMPath != "" || !instanceAt(slot.Props, socket, core, thread) {
+			continue
+		}
+
+		id := fmt.Sprintf("cpu-%d-%d-%d", socket, core, thread)
+		args := map[string]any{"driver": slot.Type, "id": id}
+		for name, prop := range map[string]*int{
+			"node-id":    slot.Props.NodeID,
+			"socket-id":  slot.Props.SocketID,
+			"die-id":     slot.Props.DieID,
+			"cluster-id": slot.Props.ClusterID,
+			"core-id":    slot.Props.CoreID,
+			"thread-id":  slot.Props.ThreadID,
+		} {
+			if prop != nil {
+				args[name] = *prop
+			}
+		}
+
+		if err := mon.Execute(ctx, "device_add", args, nil); err != nil {
+			return "", fmt.Errorf("add CPU %s: %w", id, err)
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("add CPU at socket %d, core %d, thread %d: %w", socket, core, thread, ErrNoCPUSlot)
+}
+
+// VCPUThreads returns the ids of the host threads running the vCPUs
+// of the VM by their CPU index.
+func (v *VM) VCPUThreads(ctx context.Context) (map[int]int, error) {
+	mon, err := v.qmp()
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := mon.QueryCPUsFast(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	threads := make(map[int]int, len(cpus))
+	for _, cpu := range cpus {
+		threads[cpu.CPUIndex] = cpu.ThreadID
+	}
+	return threads, nil
+}
+
+// Affinity assigns host CPUs to the threads of a VM.  Threads left
+// out keep their affinity.
+type Affinity struct {
+	// VCPUs maps the index of a vCPU to the host CPUs it may run
+	// on.
+	VCPUs map[int][]int
+
+	// MainLoop are the host CPUs of the QEMU main loop, which also
+	// does the I/O of devices without an iothread.
+	MainLoop []int
+
+	// IOThreads maps the id of an iothread object to its host CPUs.
+	IOThreads map[string][]int
+}
+
+// Pin sets the affinity of the threads of the VM, e.g. to keep the
+// scheduler from moving vCPUs around during benchmarks.  QEMU has to
+// run on this host.
+func (v *VM) Pin(ctx context.Context, a Affinity) error {
+	mon, err := v.qmp()
+	if err != nil {
+		return err
+	}
+
+	if len(a.VCPUs) > 0 {
+		threads, err := v.VCPUThreads(ctx)
+		if err != nil {
+			return err
+		}
+		for index, cpus := range a.VCPUs {
+			tid, ok := threads[index]
+			if !ok {
+				return fmt.Errorf("pin vCPU %d: no such vCPU", index)
+			}
+			if err := PinThread(tid, cpus...); err != nil {
+				return fmt.Errorf("pin vCPU %d: %w", index, err)
+			}
+		}
+	}
+
+	if len(a.MainLoop) > 0 {
+		// The main loop runs in the main thread, whose id is the
+		// one of the process.
+		if err := PinThread(v.pid, a.MainLoop...); err != nil {
+			return fmt.Errorf("pin main loop: %w", err)
+		}
+	}
+
+	if len(a.IOThreads) > 0 {
+		iothreads, err := mon.QueryIOThreads(ctx)
+		if err != nil {
+			return err
+		}
+		threads := make(map[string]int, len(iothreads))
+		for _, t := range iothreads {
+			threads[t.ID] = t.ThreadID
+		}
+		for id, cpus := range a.IOThreads {
+			tid, ok := threads[id]
+			if !ok {
+				return fmt.Errorf("pin iothread %s: no such iothread", id)
+			}
+			if err := PinThread(tid, cpus...); err != nil {
+				return fmt.Errorf("pin iothread %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PinThread restricts the host thread with the given id to the given
+// host CPUs.
+func PinThread(tid int, cpus ...int) error {
+	if len(cpus) == 0 {
+		return errors.New("no CPUs to pin to")
+	}
+
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= len(set)*int(unsafe.Sizeof(set[0]))*8 {
+			return fmt.Errorf("no such host CPU: %d", cpu)
+		}
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(tid, &set)
+}
+
+// ThreadAffinity returns the host CPUs the host thread with the given
+// id may run on.
+func ThreadAffinity(tid int) ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(tid, &set); err != nil {
+		return nil, err
+	}
+
+	cpus := make([]int, 0, set.Count())
+	for cpu := 0; len(cpus) < cap(cpus); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+)
+
+// fakeCPUPlugged tells whether the fake QEMU has its second CPU.
+var fakeCPUPlugged bool
+
+// fakeCPU answers the CPU commands of fakeServe and reports whether
+// it did.  The fake machine has two sockets with a CPU each, of which
+// the second is plugged with device_add.
+func fakeCPU(conn net.Conn, cmd string, args map[string]any, id string) bool {
+	switch cmd {
+	case "query-hotpluggable-cpus":
+		qomPath := ""
+		if fakeCPUPlugged {
+			qomPath = `, "qom-path": "/machine/peripheral/cpu-1-0-0"`
+		}
+		fmt.Fprintf(conn, `{"return": [
+			{"type": "qemu64-x86_64-cpu", "vcpus-count": 1, "props": {"socket-id": 1, "die-id": 0, "core-id": 0, "thread-id": 0}%s},
+			{"type": "qemu64-x86_64-cpu", "vcpus-count": 1, "props": {"socket-id": 0, "die-id": 0, "core-id": 0, "thread-id": 0}, "qom-path": "/machine/unattached/device[0]"}
+		], "id": %q}`+"\n", qomPath, id)
+	case "device_add":
+		if args["driver"] != "qemu64-x86_64-cpu" {
+			return false
+		}
+		if args["id"] != "cpu-1-0-0" || args["socket-id"] != float64(1) || args["die-id"] != float64(0) {
+			fmt.Fprintf(conn, `{"error": {"class": "GenericError", "desc": "bad arguments"}, "id": %q}`+"\n", id)
+			return true
+		}
+		fakeCPUPlugged = true
+		fmt.Fprintf(conn, `{"return": {}, "id": %q}`+"\n", id)
+	case "query-cpus-fast", "query-iothreads":
+		// All threads of the fake QEMU are its main thread.
+		fmt.Fprintf(conn, `{"return": [{"cpu-index": 0, "qom-path": "/machine/unattached/device[0]",
+			"thread-id": %d, "props": {"socket-id": 0}, "target": "x86_64", "id": "io0"}], "id": %q}`+"\n", os.Getpid(), id)
+	default:
+		return false
+	}
+	return true
+}
+
+func TestVM_AddCPU(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	vm := yeetFake(t)
+
+	slots, err := vm.HotpluggableCPUs(ctx)
+	if assert.NoError(err) && assert.Len(slots, 2) {
+		assert.Empty(slots[0].QOMPath)
+		assert.Equal(1, *slots[0].Props.SocketID)
+	}
+
+	id, err := vm.AddCPU(ctx, 1, 0, 0)
+	if assert.NoError(err) {
+		assert.Equal("cpu-1-0-0", id)
+	}
+
+	_, err = vm.AddCPU(ctx, 1, 0, 0)
+	assert.ErrorIs(err, libqatapult.ErrNoCPUSlot)
+	_, err = vm.AddCPU(ctx, 0, 0, 0)
+	assert.ErrorIs(err, libqatapult.ErrNoCPUSlot)
+}
+
+func TestVM_Pin(t *testing.T) {
+	assert := assertpkg.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	vm := yeetFake(t)
+
+	threads, err := vm.VCPUThreads(ctx)
+	if assert.NoError(err) {
+		assert.Equal(map[int]int{0: vm.Pid()}, threads)
+	}
+
+	cpus, err := libqatapult.ThreadAffinity(os.Getpid())
+	if !assert.NoError(err) || !assert.NotEmpty(cpus) {
+		return
+	}
+	first := cpus[:1]
+
+	for _, a := range []libqatapult.Affinity{
+		{VCPUs: map[int][]int{0: first}},
+		{MainLoop: first},
+		{IOThreads: map[string][]int{"io0": first}},
+	} {
+		assert.NoError(libqatapult.PinThread(vm.Pid(), cpus...))
+		assert.NoError(vm.Pin(ctx, a))
+
+		got, err := libqatapult.ThreadAffinity(vm.Pid())
+		if assert.NoError(err) {
+			assert.Equal(first, got)
+		}
+	}
+
+	assert.ErrorContains(vm.Pin(ctx, libqatapult.Affinity{VCPUs: map[int][]int{1: first}}), "no such vCPU")
+	assert.ErrorContains(vm.Pin(ctx, libqatapult.Affinity{IOThreads: map[string][]int{"io1": first}}), "no such iothread")
+}
+
+func TestPinThread_BadCPU(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	assert.ErrorContains(libqatapult.PinThread(os.Getpid()), "no CPUs")
+	assert.ErrorContains(libqatapult.PinThread(os.Getpid(), -1), "no such host CPU: -1")
+	assert.ErrorContains(libqatapult.PinThread(os.Getpid(), 1024), "no such host CPU: 1024")
+	assert.ErrorContains(libqatapult.PinThread(os.Getpid(), 1<<20), "no such host CPU")
+}
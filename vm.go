@@ -16,57 +16,567 @@ package libqatapult
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/atomic"
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+
+	"github.com/qatapult/libqatapult/internal/procfs"
+	"github.com/qatapult/libqatapult/internal/ring"
+	"github.com/qatapult/libqatapult/qpqmp"
 )
 
 const FdOffset = 3
 
+const (
+	// defaultLogTail is the number of log records kept per VM.
+	defaultLogTail = 64
+
+	// drainTimeout limits how long to wait for events and log
+	// lines still in flight after QEMU exited.
+	drainTimeout = time.Second
+)
+
 // VM represents a running QEMU virtual machine that was launched
-// with Yeet.
+// with Yeet or attached to with Attach.
 type VM struct {
-	cmd    *exec.Cmd
+	cmd    *exec.Cmd // nil unless QEMU is a child process
+	pid    int
+	pidfd  *os.File
 	doneCh chan struct{}
 	err    atomic.Error
+	status atomic.Pointer[ExitStatus]
+
+	monitor  *qpqmp.Client
+	shutdown atomic.Pointer[ShutdownEvent]
+
+	name       string
+	runtimeDir *RuntimeDir
+
+	// detachR wakes up wait when the VM is detached from.
+	detachR, detachW *os.File
+
+	logs       *ring.Buffer[LogRecord]
+	logFile    *os.File
+	logHandler slog.Handler
+	logWG      sync.WaitGroup
+	stderrTail *lineWriter
+
+	// daemonStderr is the file a detached QEMU writes its stderr to.
+	daemonStderr *os.File
 }
 
 func (v *VM) Done() <-chan struct{} { return v.doneCh }
-func (v *VM) Error() error          { return v.err.Load() }
-func (v *VM) Stop() error           { return v.cmd.Process.Signal(syscall.SIGTERM) }
-func (v *VM) Kill() error           { return v.cmd.Process.Kill() }
+func (v *VM) Name() string          { return v.name }
+func (v *VM) Pid() int              { return v.pid }
+func (v *VM) Stop() error           { return v.Signal(syscall.SIGTERM) }
+func (v *VM) Kill() error           { return v.Signal(syscall.SIGKILL) }
+
+// Error returns an *ExitError once QEMU exited unsuccessfully.
+func (v *VM) Error() error { return v.err.Load() }
+
+// ExitStatus describes how QEMU exited.  It returns nil as long as
+// QEMU is running.
+func (v *VM) ExitStatus() *ExitStatus { return v.status.Load() }
+
+// RuntimeDir returns the runtime directory owned by the VM or nil
+// when it was launched with YeetWithoutRuntimeDir.
+func (v *VM) RuntimeDir() *RuntimeDir { return v.runtimeDir }
+
+// Monitor returns the QMP client connected to QEMU or nil unless the
+// VM was launched with YeetWithMonitor.
+func (v *VM) Monitor() *qpqmp.Client { return v.monitor }
+
+// Signal sends sig to the QEMU process.  When the VM was launched
+// with YeetWithPidfd the signal is delivered through the pidfd, so
+// it can never hit an unrelated process that reused the pid.
+func (v *VM) Signal(sig syscall.Signal) error {
+	if v.pidfd == nil {
+		return v.cmd.Process.Signal(sig)
+	}
+
+	rc, err := v.pidfd.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if ctlErr := rc.Control(func(fd uintptr) {
+		err = unix.PidfdSendSignal(int(fd), sig, nil, 0)
+	}); ctlErr != nil {
+		// The pidfd is closed once the process has been reaped.
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// reap blocks until the QEMU process exits and reaps it, if it is
+// a child process.  It returns ErrDetached when the VM was detached
+// from before QEMU exited.
+func (v *VM) reap() (*os.ProcessState, error) {
+	if v.pidfd != nil {
+		// A pidfd becomes readable once the process terminated,
+		// the exit status is collected by cmd.Wait below.
+		fds := []unix.PollFd{{Fd: int32(v.pidfd.Fd()), Events: unix.POLLIN}}
+		if v.detachR != nil {
+			fds = append(fds, unix.PollFd{Fd: int32(v.detachR.Fd()), Events: unix.POLLIN})
+		}
+		for {
+			if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+				break
+			}
+		}
+		if fds[0].Revents == 0 {
+			return nil, ErrDetached
+		}
+	}
+	if v.cmd == nil {
+		return nil, nil
+	}
+	err := v.cmd.Wait()
+	return v.cmd.ProcessState, err
+}
+
+// wait waits for QEMU to exit and records how it went.
+func (v *VM) wait() {
+	defer close(v.doneCh)
+
+	state, err := v.reap()
+	if errors.Is(err, ErrDetached) {
+		v.err.Store(err)
+		_ = v.release(false)
+		return
+	}
+	if v.stderrTail != nil {
+		v.stderrTail.Flush()
+	}
+
+	drained := make(chan struct{})
+	go func() { defer close(drained); v.logWG.Wait() }()
+	timeout := time.After(drainTimeout)
+
+	select {
+	case <-drained:
+	case <-timeout:
+	}
+
+	status := v.exitStatus(err, state)
+	if v.cmd == nil {
+		status.Code, status.Detached = 0, true
+	}
+
+	if v.monitor != nil {
+		select {
+		case <-v.monitor.Done():
+		case <-timeout:
+		}
 
-type YeetOption func(cmd *exec.Cmd)
+		status.startKnown = true
+		status.Shutdown = v.shutdown.Load()
+		select {
+		case <-v.monitor.Greeted():
+			status.Started = true
+		default:
+		}
+	}
+
+	if status.Detached && status.Shutdown == nil {
+		status.err = ErrUnknownExit
+	}
+
+	v.status.Store(status)
+	v.err.Store(status.Err())
+
+	_ = v.release(true)
+}
+
+// exitStatus builds the ExitStatus of QEMU from its wait status and
+// the records logged so far.
+func (v *VM) exitStatus(waitErr error, state *os.ProcessState) *ExitStatus {
+	status := newExitStatus(waitErr, state)
+	status.Log = v.logs.Snapshot()
+	for _, r := range status.Log {
+		if r.Source == LogSourceStderr {
+			status.Stderr = append(status.Stderr, r.Line)
+		}
+	}
+	return status
+}
+
+// release frees all resources held by the VM.  The runtime directory
+// is only removed if remove is set.
+func (v *VM) release(remove bool) (err error) {
+	if v.monitor != nil {
+		err = multierr.Append(err, v.monitor.Close())
+	}
+	if v.logFile != nil {
+		err = multierr.Append(err, v.logFile.Close())
+	}
+	if v.daemonStderr != nil {
+		err = multierr.Append(err, v.daemonStderr.Close())
+	}
+	if v.pidfd != nil {
+		err = multierr.Append(err, v.pidfd.Close())
+	}
+	if v.detachR != nil {
+		err = multierr.Combine(err, v.detachR.Close(), v.detachW.Close())
+	}
+	if v.runtimeDir != nil {
+		if remove {
+			err = multierr.Append(err, v.runtimeDir.Remove())
+		} else {
+			err = multierr.Append(err, v.runtimeDir.Release())
+		}
+	}
+	return err
+}
+
+// writeRuntimeFiles completes m and writes it to the runtime
+// directory of the VM, together with the pidfile unless QEMU wrote
+// that one itself.
+func (v *VM) writeRuntimeFiles(m *RuntimeMetadata) error {
+	if !m.Detached {
+		if err := os.WriteFile(v.runtimeDir.PidFile(), []byte(strconv.Itoa(v.pid)+"\n"), 0o600); err != nil {
+			return err
+		}
+	}
+
+	st, err := procfs.ReadStat(v.pid)
+	if err != nil {
+		return err
+	}
+	owner, err := self()
+	if err != nil {
+		return err
+	}
+
+	m.Name = v.runtimeDir.Name()
+	m.Pid, m.StartTime = v.pid, st.StartTime
+	m.Owner = owner.String()
+	m.Created = time.Now()
+	return v.runtimeDir.WriteMetadata(m)
+}
+
+func (v *VM) recordShutdown(ev qpqmp.Event) {
+	if ev.Name != "SHUTDOWN" {
+		return
+	}
+	var s ShutdownEvent
+	if err := ev.Decode(&s); err == nil {
+		s.Timestamp = ev.Timestamp
+		v.shutdown.Store(&s)
+	}
+}
+
+type yeetOptions struct {
+	cmd     *exec.Cmd
+	args    []string
+	pidfd   bool
+	monitor bool
+
+	logHandler slog.Handler
+	logTail    int
+	debugLog   []string
+
+	name          string
+	runtimeDir    *RuntimeDir
+	runtimeRoot   string
+	noRuntimeDir  bool
+	keepArtifacts bool
+	detach        bool
+}
+
+// openRuntimeDir returns the runtime directory the VM will own.
+func (o *yeetOptions) openRuntimeDir() (*RuntimeDir, error) {
+	if o.runtimeDir != nil {
+		return o.runtimeDir, nil
+	}
+
+	name := o.name
+	if name == "" {
+		name = uuid.NewString()
+	}
+
+	var opts []RuntimeDirOpt
+	if o.runtimeRoot != "" {
+		opts = append(opts, WithRuntimeRoot(o.runtimeRoot))
+	}
+	return NewRuntimeDir(name, opts...)
+}
+
+// addFile passes f to QEMU and returns its file descriptor number.
+func (o *yeetOptions) addFile(f *os.File) int {
+	o.cmd.ExtraFiles = append(o.cmd.ExtraFiles, f)
+	return FdOffset + len(o.cmd.ExtraFiles) - 1
+}
+
+func (o *yeetOptions) sysProcAttr() *syscall.SysProcAttr {
+	if o.cmd.SysProcAttr == nil {
+		o.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return o.cmd.SysProcAttr
+}
+
+type YeetOption func(o *yeetOptions)
 
 // YeetWithStdPipes specifies the std pipes to be used in the given VM.
+// QEMU writes to e directly, so its stderr does not show up in Logs
+// or the stderr tail of an ExitError then.
 func YeetWithStdPipes(i io.Reader, o io.Writer, e io.Writer) YeetOption {
-	return func(cmd *exec.Cmd) {
-		cmd.Stdin = i
-		cmd.Stdout = o
-		cmd.Stderr = e
+	return func(opts *yeetOptions) {
+		opts.cmd.Stdin = i
+		opts.cmd.Stdout = o
+		opts.cmd.Stderr = e
+	}
+}
+
+// YeetWithPdeathsig asks the kernel to send sig to QEMU as soon as
+// the launching process dies, so a crashing host process does not
+// leave orphaned virtual machines behind.
+//
+// The signal is tied to the OS thread that launched QEMU, which in
+// Go programs usually lives as long as the process itself.
+func YeetWithPdeathsig(sig syscall.Signal) YeetOption {
+	return func(o *yeetOptions) { o.sysProcAttr().Pdeathsig = sig }
+}
+
+// YeetWithProcessGroup launches QEMU in a new process group, so
+// signals sent to the foreground process group of the host process
+// (e.g. ^C in a terminal) are not delivered to QEMU.
+func YeetWithProcessGroup() YeetOption {
+	return func(o *yeetOptions) {
+		attr := o.sysProcAttr()
+		attr.Setpgid, attr.Setsid = true, false
+	}
+}
+
+// YeetWithSession launches QEMU in a new session, detaching it from
+// the controlling terminal of the host process.
+func YeetWithSession() YeetOption {
+	return func(o *yeetOptions) {
+		attr := o.sysProcAttr()
+		attr.Setsid, attr.Setpgid = true, false
 	}
 }
 
+// YeetWithPidfd makes the VM track the QEMU process through a pidfd,
+// which avoids pid reuse races when waiting for or signalling QEMU.
+func YeetWithPidfd() YeetOption {
+	return func(o *yeetOptions) { o.pidfd = true }
+}
+
+// YeetWithName names the VM, which also names its runtime directory.
+// A random name is chosen if none is given.
+func YeetWithName(name string) YeetOption {
+	return func(o *yeetOptions) { o.name = name }
+}
+
+// YeetWithRuntimeDir hands d over to the VM, which is useful when
+// devices need paths inside of the directory before launching.
+func YeetWithRuntimeDir(d *RuntimeDir) YeetOption {
+	return func(o *yeetOptions) { o.runtimeDir = d }
+}
+
+// YeetWithRuntimeRoot creates the runtime directory of the VM below
+// root instead of RuntimeRoot.
+func YeetWithRuntimeRoot(root string) YeetOption {
+	return func(o *yeetOptions) { o.runtimeRoot = root }
+}
+
+// YeetWithoutRuntimeDir launches QEMU without a runtime directory, so
+// nothing is written to disk and QEMU gets no file descriptors beside
+// the ones of its devices.  The VM can neither be detached nor be
+// found by ListVMs then.
+func YeetWithoutRuntimeDir() YeetOption {
+	return func(o *yeetOptions) { o.noRuntimeDir = true }
+}
+
+// YeetWithKeepArtifacts keeps the runtime directory of the VM after
+// it exited.
+func YeetWithKeepArtifacts() YeetOption {
+	return func(o *yeetOptions) { o.keepArtifacts = true }
+}
+
+// YeetWithMonitor attaches a QMP monitor to QEMU, which Monitor
+// returns.  Features depending on the monitor, like the shutdown
+// reason in ExitStatus or hotplug, are unavailable without it.
+//
+// The monitor adds a -chardev named qatapult-qmp and a -mon using it
+// to the command line, which must not clash with monitors configured
+// by the caller.  YeetWithDetach implies it.
+func YeetWithMonitor() YeetOption {
+	return func(o *yeetOptions) { o.monitor = true }
+}
+
 // YeetDescription yeets a VM instance, in style, by launching QEMU
 // with the given Description.
-func YeetDescription(ctx context.Context, d *Description, opts ...YeetOption) (*VM, error) {
+func YeetDescription(ctx context.Context, d *Description, opts ...YeetOption) (_ *VM, err error) {
 	args := d.CmdLine()
 
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd := exec.CommandContext(ctx, args[0])
 	cmd.Env = d.environ
-	cmd.ExtraFiles = d.Files()
+	cmd.ExtraFiles = append([]*os.File(nil), d.Files()...)
+
+	o := yeetOptions{cmd: cmd, args: args[1:], logTail: defaultLogTail}
 	for _, opt := range opts {
-		opt(cmd)
+		opt(&o)
+	}
+	if o.logTail < 0 {
+		return nil, fmt.Errorf("negative log tail of %d records", o.logTail)
 	}
 
-	if err := cmd.Start(); err != nil {
+	if o.detach {
+		// Detached VMs are meant to outlive the host process and
+		// must not be mistaken for orphans.
+		cmd.Env = untagEnviron(cmd.Env)
+	} else if cmd.Env, err = tagEnviron(cmd.Env); err != nil {
 		return nil, err
 	}
 
-	vm := &VM{cmd: cmd, doneCh: make(chan struct{})}
-	go func() { defer close(vm.doneCh); vm.err.Store(cmd.Wait()) }()
+	vm := &VM{
+		cmd:        cmd,
+		doneCh:     make(chan struct{}),
+		logs:       ring.New[LogRecord](o.logTail),
+		logHandler: o.logHandler,
+	}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, vm.release(true))
+		}
+	}()
+
+	if o.noRuntimeDir {
+		if o.detach || o.runtimeDir != nil || o.runtimeRoot != "" || o.keepArtifacts {
+			return nil, errors.New("runtime directory options given without a runtime directory")
+		}
+		vm.name = o.name
+	} else {
+		if vm.runtimeDir, err = o.openRuntimeDir(); err != nil {
+			return nil, err
+		}
+		vm.name = vm.runtimeDir.Name()
+		if o.keepArtifacts {
+			vm.runtimeDir.Keep()
+		}
+
+		vm.logFile, err = os.OpenFile(vm.runtimeDir.LogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND|unix.O_CLOEXEC, 0o600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A stderr of the caller is passed on to QEMU as is.
+	if cmd.Stderr == nil {
+		vm.stderrTail = &lineWriter{fn: func(line string) { vm.log(LogSourceStderr, line) }}
+		cmd.Stderr = vm.stderrTail
+
+		// The daemon may hold on to its stderr, which must not be
+		// a pipe nobody drains anymore once the host process exits.
+		if o.detach {
+			vm.daemonStderr, err = os.OpenFile(vm.runtimeDir.Join(daemonStderrFile),
+				os.O_RDWR|os.O_CREATE|os.O_TRUNC|unix.O_CLOEXEC, 0o600)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Stderr = vm.daemonStderr
+		}
+	} else if _, ok := cmd.Stderr.(*os.File); o.detach && !ok {
+		return nil, errors.New("detach: stderr has to be an *os.File")
+	}
+
+	meta := RuntimeMetadata{Description: d.CmdLine(), Detached: o.detach}
+	switch {
+	case o.detach:
+		// The monitor is how a detached VM's exit is told apart
+		// from a crash, so it is always there.
+		meta.Monitor = vm.runtimeDir.Socket("qmp")
+		installMonitorSocket(&o, meta.Monitor)
+		o.args = append(o.args, "-daemonize", "-pidfile", vm.runtimeDir.PidFile())
+
+	case o.monitor:
+		var m *monitor
+		if m, err = newMonitor(); err != nil {
+			return nil, err
+		}
+		// QEMU holds its own copy after the start.
+		defer multierr.AppendInvoke(&err, multierr.Close(m.vmFile))
+
+		m.install(&o)
+		vm.monitor = m.client
+		vm.monitor.Listen(vm.recordShutdown)
+	}
+
+	var logR *os.File
+	if len(o.debugLog) > 0 && o.detach {
+		o.args = append(o.args, "-d", strings.Join(o.debugLog, ","), "-D", vm.runtimeDir.Join(debugLogFile))
+	} else if len(o.debugLog) > 0 {
+		var logW *os.File
+		if logR, logW, err = os.Pipe(); err != nil {
+			return nil, err
+		}
+		defer multierr.AppendInvoke(&err, multierr.Close(logW))
+
+		fd := o.addFile(logW)
+		o.args = append(o.args, "-d", strings.Join(o.debugLog, ","), "-D", fmt.Sprintf("/dev/fd/%d", fd))
+	}
+
+	// Passing the lock on to QEMU keeps the directory locked for as
+	// long as QEMU is alive.
+	if vm.runtimeDir != nil {
+		o.addFile(vm.runtimeDir.lock)
+	}
+
+	cmd.Args = append(cmd.Args, o.args...)
+	if err = cmd.Start(); err != nil {
+		if logR != nil {
+			err = multierr.Append(err, logR.Close())
+		}
+		return nil, err
+	}
+
+	if logR != nil {
+		vm.logWG.Add(1)
+		go vm.readLog(LogSourceLogfile, logR)
+	}
+
+	meta.CmdLine = cmd.Args
+	if o.detach {
+		if err = vm.adoptDaemon(meta.Monitor); err != nil {
+			return nil, err
+		}
+		if err = vm.writeRuntimeFiles(&meta); err != nil {
+			return nil, multierr.Append(err, vm.Kill())
+		}
+		go vm.wait()
+		return vm, nil
+	}
+
+	vm.pid = cmd.Process.Pid
+	if o.pidfd {
+		// QEMU can not be reaped before cmd.Wait is called, so the
+		// pid is guaranteed to still refer to QEMU at this point.
+		if vm.pidfd, err = openPidfd(vm.pid); err != nil {
+			return nil, multierr.Combine(err, cmd.Process.Kill(), cmd.Wait())
+		}
+	}
+
+	if vm.runtimeDir != nil {
+		if err = vm.writeRuntimeFiles(&meta); err != nil {
+			return nil, multierr.Combine(err, cmd.Process.Kill(), cmd.Wait())
+		}
+	}
+
+	go vm.wait()
 
 	return vm, nil
 }
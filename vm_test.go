@@ -0,0 +1,239 @@
+// Copyright (c) 2022 individual contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     <http://www.apache.org/licenses/LICENSE-2.0>
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific
+// language governing permissions and limitations under the License.
+
+package libqatapult_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	assertpkg "github.com/stretchr/testify/assert"
+
+	"github.com/qatapult/libqatapult"
+)
+
+// shellConfig returns a Config that runs script in place of QEMU.
+func shellConfig(script string) *libqatapult.Config {
+	return &libqatapult.Config{
+		Emulator:       []string{"/bin/sh", "-c", script},
+		KeepDefaults:   true,
+		KeepUserConfig: true,
+		DontUseEnv:     true,
+		Devices:        libqatapult.NewDeviceGroup(),
+	}
+}
+
+func waitDone(t *testing.T, vm *libqatapult.VM) bool {
+	select {
+	case <-vm.Done():
+		return true
+	case <-time.After(10 * time.Second):
+		t.Error("VM did not terminate")
+		return false
+	}
+}
+
+func TestYeet_Pidfd(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig("exec sleep 30"),
+		libqatapult.YeetWithPidfd(),
+		libqatapult.YeetWithProcessGroup(),
+		libqatapult.YeetWithPdeathsig(syscall.SIGKILL))
+	if !assert.NoError(err) {
+		return
+	}
+
+	pgid, err := syscall.Getpgid(vm.Pid())
+	if assert.NoError(err) {
+		assert.Equal(vm.Pid(), pgid)
+	}
+
+	assert.NoError(vm.Stop())
+	if !waitDone(t, vm) {
+		return
+	}
+
+	var exitErr *exec.ExitError
+	if assert.ErrorAs(vm.Error(), &exitErr) {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		assert.Equal(syscall.SIGTERM, status.Signal())
+	}
+	assert.ErrorIs(vm.Kill(), os.ErrProcessDone)
+}
+
+func TestYeet_OwnerTag(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	vm, err := libqatapult.Yeet(context.Background(),
+		shellConfig(`test -n "$`+libqatapult.OwnerEnv+`"`))
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+	assert.NoError(vm.Error())
+}
+
+func TestFindOrphans(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	// Pretend the sleep process was launched by a process that is
+	// long gone.
+	cmd := exec.Command("sleep", "30")
+	cmd.Env = append(os.Environ(), libqatapult.OwnerEnv+"=999999999:1")
+	if !assert.NoError(cmd.Start()) {
+		return
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+	// The environment may not be visible right after exec.
+	var found *libqatapult.Orphan
+	assert.Eventually(func() bool {
+		orphans, err := libqatapult.FindOrphans()
+		if !assert.NoError(err) {
+			return true
+		}
+		for i := range orphans {
+			if orphans[i].Pid == cmd.Process.Pid {
+				found = &orphans[i]
+			}
+		}
+		return found != nil
+	}, 5*time.Second, 10*time.Millisecond, "orphan not detected")
+	if found == nil {
+		return
+	}
+	assert.Equal(libqatapult.Owner{Pid: 999999999, StartTime: 1}, found.Owner)
+	assert.Equal([]string{"sleep", "30"}, found.CmdLine)
+
+	assert.NoError(found.Kill())
+
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); assert.True(errors.As(err, &exitErr)) {
+		assert.Equal(syscall.SIGKILL, exitErr.Sys().(syscall.WaitStatus).Signal())
+	}
+}
+
+// fakeQMP is a shell snippet speaking just enough QMP on fd 3 to
+// negotiate capabilities.
+const fakeQMP = `
+printf '%s\n' '{"QMP": {"version": {"qemu": {"micro": 0, "minor": 2, "major": 8}, "package": ""}, "capabilities": []}}' >&3
+read -r line <&3
+id=$(echo "$line" | sed 's/.*"id": *"\([0-9]*\)".*/\1/')
+printf '{"return": {}, "id": "%s"}\n' "$id" >&3
+`
+
+func shutdownEvent(reason string) string {
+	return `printf '%s\n' '{"event": "SHUTDOWN", "data": {"guest": true, "reason": "` + reason + `"}, "timestamp": {"seconds": 1, "microseconds": 0}}' >&3` + "\n"
+}
+
+func TestYeet_ExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		check  func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus)
+	}{
+		{"config-error", `echo "qemu-system-x86_64: -device foo: 'foo' is not a valid device model name" >&2; exit 1`,
+			func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus) {
+				assert.True(s.IsConfigError())
+				assert.False(s.IsCrash())
+				assert.False(s.Started)
+				assert.Equal([]string{"qemu-system-x86_64: -device foo: 'foo' is not a valid device model name"}, s.Stderr)
+			}},
+
+		{"guest-shutdown", fakeQMP + shutdownEvent("guest-shutdown") + "exit 0",
+			func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus) {
+				assert.True(s.Success())
+				assert.True(s.Started)
+				assert.True(s.IsGuestShutdown())
+				assert.False(s.IsGuestReset())
+				assert.NotNil(s.Rusage)
+			}},
+
+		{"guest-reset", fakeQMP + shutdownEvent("guest-reset") + "exit 0",
+			func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus) {
+				assert.True(s.IsGuestReset())
+				assert.False(s.IsGuestShutdown())
+			}},
+
+		{"crash", fakeQMP + `echo "qemu: fatal: oops" >&2; kill -SEGV $$`,
+			func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus) {
+				assert.True(s.IsCrash())
+				assert.False(s.IsConfigError())
+				assert.Equal(syscall.SIGSEGV, s.Signal)
+				assert.Equal(-1, s.Code)
+			}},
+
+		{"killed", fakeQMP + `kill -KILL $$`,
+			func(assert *assertpkg.Assertions, s *libqatapult.ExitStatus) {
+				assert.True(s.IsKilled())
+				assert.False(s.IsCrash())
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assertpkg.New(t)
+
+			vm, err := libqatapult.Yeet(context.Background(), shellConfig(tt.script), libqatapult.YeetWithMonitor())
+			if !assert.NoError(err) || !waitDone(t, vm) {
+				return
+			}
+
+			s := vm.ExitStatus()
+			if !assert.NotNil(s) {
+				return
+			}
+			tt.check(assert, s)
+
+			if s.Success() {
+				assert.NoError(vm.Error())
+			} else {
+				var exitErr *libqatapult.ExitError
+				assert.ErrorAs(vm.Error(), &exitErr)
+			}
+		})
+	}
+}
+
+func TestYeet_ExitStatusUnmonitored(t *testing.T) {
+	assert := assertpkg.New(t)
+
+	// Without a monitor, failing at runtime looks like a bad
+	// command line, so neither is claimed.
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(`sleep 0.1; echo "qemu: failed to map memory" >&2; exit 1`))
+	if !assert.NoError(err) || !waitDone(t, vm) {
+		return
+	}
+
+	s := vm.ExitStatus()
+	if !assert.NotNil(s) {
+		return
+	}
+	assert.Equal(1, s.Code)
+	assert.False(s.Started)
+	assert.False(s.IsConfigError())
+	assert.False(s.IsCrash())
+}
+
+func TestExitError_Error(t *testing.T) {
+	vm, err := libqatapult.Yeet(context.Background(), shellConfig(`echo first >&2; echo -n second >&2; exit 3`))
+	if !assertpkg.NoError(t, err) || !waitDone(t, vm) {
+		return
+	}
+	assertpkg.EqualError(t, vm.Error(), "qemu: exit status 3 (first; second)")
+}